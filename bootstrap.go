@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CzarRamos/chirpy/internal/config"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/settings"
+)
+
+// PROMOTE_ADMIN_COMMAND makes the first admin: chirpy promote-admin <email> [flags]
+const PROMOTE_ADMIN_COMMAND = "promote-admin"
+
+// runPromoteAdmin promotes an existing user to admin. It only works while nobody is an admin,
+// after that admins use PUT /admin/users/{user_id}/role
+func runPromoteAdmin(args []string) error {
+	if len(args) <= 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("usage: chirpy promote-admin <email> [flags]")
+	}
+	email := args[0]
+
+	appSettings, err := settings.Load(args[1:], os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	if appSettings.UsesMemoryStore() {
+		return errors.New("error: promote-admin needs Postgres, the memory store lives inside the running server")
+	}
+
+	db, err := sql.Open("postgres", appSettings.DBURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	promotedUser, err := config.PromoteFirstAdmin(context.Background(), database.New(db), email)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s) is now an admin\n", promotedUser.Email, promotedUser.ID)
+	return nil
+}
@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -14,15 +16,79 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const DEFAULT_ACCESS_TOKEN_DURATION = time.Hour
 const DEFAULT_REFRESH_TOKEN_DURATION_IN_HOURS = 1440 // 1440 hours is 60 days
 
+var ErrPasswordEmpty = errors.New("error: password cannot be empty")
+var ErrPasswordTooLong = errors.New("error: password is too long")
+
+// ErrTokenExpired is returned by ValidateJWT for tokens that were fine until they ran out
+var ErrTokenExpired = jwt.ErrTokenExpired
+
+// ErrTokenRevoked is returned for tokens that haven't expired but were killed early,
+// by a newer token version or by the denylist
+var ErrTokenRevoked = errors.New("error: token has been revoked")
+
+// roles from least to most privileged, each one can do everything the ones before it can
+const (
+	ROLE_USER      = "user"
+	ROLE_MODERATOR = "moderator"
+	ROLE_ADMIN     = "admin"
+)
+
+var ErrInvalidRole = errors.New("error: role must be one of user, moderator or admin")
+
+var roleRank = map[string]int{
+	ROLE_USER:      1,
+	ROLE_MODERATOR: 2,
+	ROLE_ADMIN:     3,
+}
+
+func ParseRole(raw string) (string, error) {
+	role := strings.ToLower(strings.TrimSpace(raw))
+	if roleRank[role] <= 0 {
+		return "", ErrInvalidRole
+	}
+	return role, nil
+}
+
+// HasRole reports whether role is allowed to do what required is allowed to do.
+// Unknown roles are never allowed anything
+func HasRole(role, required string) bool {
+	rank := roleRank[role]
+	return rank > 0 && rank >= roleRank[required]
+}
+
+// Claims are what chirpy puts in its access tokens.
+// The jti lets one token be denylisted, Version lets every token of a user be revoked at once
+type Claims struct {
+	jwt.RegisteredClaims
+	Role    string `json:"role,omitempty"`
+	Version int32  `json:"ver"`
+}
+
+// AccessClaims are the parts of a validated access token the handlers care about
+type AccessClaims struct {
+	UserID    uuid.UUID
+	Role      string
+	Version   int32
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// TokenVersionLookup returns the user's current token version, see ValidateJWT
+type TokenVersionLookup func(userID uuid.UUID) (int32, error)
+
+// NewRefreshToken is handed to the client once. Only Hash is stored
 type NewRefreshToken struct {
 	Token     string
+	Hash      string
 	ExpiresAt time.Time
 }
 
 type NewAccessToken struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 type AuthUserData struct {
@@ -34,18 +100,23 @@ type ChirpyEvent struct {
 	Data  AuthUserData `json:"data"`
 }
 
+// HashPassword hashes with bcrypt's default cost, use a PasswordHasher to pick the cost or algorithm
 func HashPassword(password string) (string, error) {
+	return HashPasswordWithCost(password, bcrypt.DefaultCost)
+}
+
+func HashPasswordWithCost(password string, cost int) (string, error) {
 	if len(password) <= 0 {
-		return "", errors.New("error: password cannot be empty")
+		return "", ErrPasswordEmpty
 	}
 
 	// 72 bytes is the limit for our password
 	// Two different passwords with the same first 72 bytes will be considered the same password
 	if len(password) > 72 {
-		return "", errors.New("error: password is too long")
+		return "", ErrPasswordTooLong
 	}
 
-	newHash, err := bcrypt.GenerateFromPassword([]byte(password), 4)
+	newHash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	if err != nil {
 		return "", err
 	}
@@ -53,45 +124,106 @@ func HashPassword(password string) (string, error) {
 	return string(newHash), nil
 }
 
+// CheckPasswordHash checks a password against a bcrypt hash or a PHC formatted argon2id hash
 func CheckPasswordHash(password, hash string) error {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return checkArgon2idHash(password, hash)
+	}
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
+// MakeJWT signs an access token with the ring's active key, naming it in the kid header
+func MakeJWT(userID uuid.UUID, role string, tokenVersion int32, keys *KeyRing, expiresIn time.Duration) (string, error) {
 	currentTime := time.Now()
-	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(currentTime),
-		ExpiresAt: jwt.NewNumericDate(currentTime.Add(time.Duration(3600) * time.Second)), // one hour
-		Subject:   userID.String(),
+	return keys.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keys.Issuer,
+			IssuedAt:  jwt.NewNumericDate(currentTime),
+			ExpiresAt: jwt.NewNumericDate(currentTime.Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+		},
+		Role:    role,
+		Version: tokenVersion,
 	})
+}
 
-	signedString, err := newToken.SignedString([]byte(tokenSecret))
+// ValidateJWT checks the token's signature and expiry, then asks currentVersion for the user's
+// token version. Tokens made before the version was last bumped are revoked
+func ValidateJWT(tokenString string, keys *KeyRing, currentVersion TokenVersionLookup) (AccessClaims, error) {
+	claims, err := ParseJWT(tokenString, keys)
 	if err != nil {
-		log.Printf("error creating signed string: %s", err)
-		return "", err
+		return AccessClaims{}, err
+	}
+
+	version, err := currentVersion(claims.UserID)
+	if err != nil {
+		slog.Debug("error looking up token version", "err", err)
+		return AccessClaims{}, err
+	}
+	if claims.Version != version {
+		return AccessClaims{}, ErrTokenRevoked
 	}
 
-	return signedString, nil
+	return claims, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-		return []byte(tokenSecret), nil
-	})
+// ParseJWT checks the token's signature, algorithm, issuer and expiry only,
+// use ValidateJWT to also check its version. Tokens made before roles existed count as ROLE_USER
+func ParseJWT(tokenString string, keys *KeyRing) (AccessClaims, error) {
+	claims := &Claims{}
+	err := keys.parse(tokenString, claims)
 	if err != nil {
-		log.Printf("error validating token: %s", err)
-		return uuid.Nil, err
+		slog.Debug("error validating token", "err", err)
+		return AccessClaims{}, err
+	}
+
+	// purpose tokens carry an audience, access tokens never do
+	if len(claims.Audience) > 0 {
+		return AccessClaims{}, ErrWrongPurpose
 	}
 
 	userID, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		log.Printf("error parsing user uuid: %s", err)
-		return uuid.Nil, err
+		slog.Debug("error parsing user uuid", "err", err)
+		return AccessClaims{}, err
+	}
+
+	role := claims.Role
+	if len(role) <= 0 {
+		role = ROLE_USER
+	}
+
+	accessClaims := AccessClaims{
+		UserID:  userID,
+		Role:    role,
+		Version: claims.Version,
+		TokenID: claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		accessClaims.ExpiresAt = claims.ExpiresAt.Time
 	}
 
-	return userID, nil
+	return accessClaims, nil
+}
+
+type accessClaimsKey struct{}
+
+// WithAccessClaims stores a validated token's claims on the request context
+func WithAccessClaims(ctx context.Context, claims AccessClaims) context.Context {
+	return context.WithValue(ctx, accessClaimsKey{}, claims)
+}
+
+// AccessClaimsFromContext returns the claims set by WithAccessClaims
+func AccessClaimsFromContext(ctx context.Context) (AccessClaims, bool) {
+	claims, found := ctx.Value(accessClaimsKey{}).(AccessClaims)
+	return claims, found
+}
+
+// UserIDFromContext returns who the validated access token belongs to
+func UserIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	claims, found := AccessClaimsFromContext(ctx)
+	return claims.UserID, found
 }
 
 func GetTokenBearer(headers http.Header) (string, error) {
@@ -106,22 +238,31 @@ func GetTokenBearer(headers http.Header) (string, error) {
 	return token, nil
 }
 
-func MakeRefreshToken() (NewRefreshToken, error) {
+func MakeRefreshToken(expiresIn time.Duration) (NewRefreshToken, error) {
 	tokenBytes := make([]byte, 32)
 	_, err := rand.Read(tokenBytes)
 	if err != nil {
-		log.Printf("error creating random value for token: %s", err)
+		slog.Error("error creating random value for token", "err", err)
 		return NewRefreshToken{}, err
 	}
 
+	token := hex.EncodeToString(tokenBytes)
 	newRefreshToken := NewRefreshToken{
-		Token:     hex.EncodeToString(tokenBytes),
-		ExpiresAt: time.Now().Add(time.Duration(DEFAULT_REFRESH_TOKEN_DURATION_IN_HOURS) * time.Hour),
+		Token:     token,
+		Hash:      HashRefreshToken(token),
+		ExpiresAt: time.Now().Add(expiresIn),
 	}
 
 	return newRefreshToken, nil
 }
 
+// HashRefreshToken is how refresh tokens are looked up and stored.
+// They're 256 random bits, so a fast hash is enough, unlike passwords
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authInfo := headers.Get("Authorization")
 	if len(authInfo) <= 0 {
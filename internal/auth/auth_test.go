@@ -1,7 +1,9 @@
 package auth_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/CzarRamos/chirpy/internal/auth"
 	"github.com/google/uuid"
@@ -82,20 +84,20 @@ func TestJWTValidToken(t *testing.T) {
 	userID := uuid.New()
 	//token secret
 	tokenSecret := "this-is-my-secret-token"
-	newJWT, err := auth.MakeJWT(userID, tokenSecret)
+	newJWT, err := auth.MakeJWT(userID, auth.ROLE_USER, 0, hmacRing(tokenSecret), auth.DEFAULT_ACCESS_TOKEN_DURATION)
 	if err != nil {
 		t.Errorf(`MakeJWT failed: %v`, err)
 		return
 	}
 
-	output, err := auth.ValidateJWT(newJWT, tokenSecret)
+	output, err := auth.ValidateJWT(newJWT, hmacRing(tokenSecret), versionIs(0))
 	if err != nil {
 		t.Errorf(`ValidateJWT failed: %v`, err)
 		return
 	}
 
-	if output != userID {
-		t.Errorf(`ValidateJWT returned wrong userID: got %v, want %v`, output, userID.String())
+	if output.UserID != userID {
+		t.Errorf(`ValidateJWT returned wrong userID: got %v, want %v`, output.UserID, userID.String())
 		return
 	}
 }
@@ -107,13 +109,13 @@ func TestWrongSecretKey(t *testing.T) {
 	tokenSecret := "this-is-my-secret-token"
 	// some token secret for something else
 	differentTokenSecret := "this-is-a-different-secret-token"
-	newJWT, err := auth.MakeJWT(userID, tokenSecret)
+	newJWT, err := auth.MakeJWT(userID, auth.ROLE_USER, 0, hmacRing(tokenSecret), auth.DEFAULT_ACCESS_TOKEN_DURATION)
 	if err != nil {
 		t.Errorf(`MakeJWT failed: %v`, err)
 		return
 	}
 
-	_, err = auth.ValidateJWT(newJWT, differentTokenSecret)
+	_, err = auth.ValidateJWT(newJWT, hmacRing(differentTokenSecret), versionIs(0))
 	if err == nil {
 		t.Errorf(`ValidateJWT should have rejected when validating with a different key than initialization`)
 	}
@@ -124,13 +126,13 @@ func TestValidatingInvalidStrings(t *testing.T) {
 	//token secret
 	tokenSecret := "this-is-my-secret-token"
 
-	_, err := auth.ValidateJWT("a-made-up-token", tokenSecret)
+	_, err := auth.ValidateJWT("a-made-up-token", hmacRing(tokenSecret), versionIs(0))
 	if err == nil {
 		t.Errorf(`ValidateJWT should have rejected non-existent token`)
 	}
 
 	// test with empty string
-	_, err = auth.ValidateJWT("", tokenSecret)
+	_, err = auth.ValidateJWT("", hmacRing(tokenSecret), versionIs(0))
 	if err == nil {
 		t.Errorf(`ValidateJWT should have rejected validating an empty string`)
 	}
@@ -142,7 +144,7 @@ func TestCorruptedToken(t *testing.T) {
 	userID := uuid.New()
 	//token secret
 	tokenSecret := "this-is-my-secret-token"
-	newJWT, err := auth.MakeJWT(userID, tokenSecret)
+	newJWT, err := auth.MakeJWT(userID, auth.ROLE_USER, 0, hmacRing(tokenSecret), auth.DEFAULT_ACCESS_TOKEN_DURATION)
 	if err != nil {
 		t.Errorf(`MakeJWT failed: %v`, err)
 		return
@@ -151,9 +153,99 @@ func TestCorruptedToken(t *testing.T) {
 	// corruption happens
 	corruptedJWT := newJWT + "i-am-inflitrating-the-token"
 
-	_, err = auth.ValidateJWT(corruptedJWT, tokenSecret)
+	_, err = auth.ValidateJWT(corruptedJWT, hmacRing(tokenSecret), versionIs(0))
 	if err == nil {
 		t.Errorf(`ValidateJWT should have rejected a corrupted/modified token`)
 		return
 	}
 }
+
+func TestJWTRoleClaim(t *testing.T) {
+	userID := uuid.New()
+	tokenSecret := "this-is-my-secret-token"
+	newJWT, err := auth.MakeJWT(userID, auth.ROLE_ADMIN, 0, hmacRing(tokenSecret), auth.DEFAULT_ACCESS_TOKEN_DURATION)
+	if err != nil {
+		t.Errorf(`MakeJWT failed: %v`, err)
+		return
+	}
+
+	output, err := auth.ParseJWT(newJWT, hmacRing(tokenSecret))
+	if err != nil {
+		t.Errorf(`ParseJWT failed: %v`, err)
+		return
+	}
+	if output.UserID != userID || output.Role != auth.ROLE_ADMIN {
+		t.Errorf(`ParseJWT returned %v %q, want %v %q`, output.UserID, output.Role, userID, auth.ROLE_ADMIN)
+	}
+}
+
+func hmacRing(secret string) *auth.KeyRing {
+	keys := auth.NewKeyRing(auth.DEFAULT_ISSUER)
+	keys.Add(auth.NewHMACKey(auth.HMAC_KEY_ID, []byte(secret)))
+	return keys
+}
+
+func versionIs(version int32) auth.TokenVersionLookup {
+	return func(userID uuid.UUID) (int32, error) {
+		return version, nil
+	}
+}
+
+func TestJWTVersionAndID(t *testing.T) {
+	userID := uuid.New()
+	tokenSecret := "this-is-my-secret-token"
+	firstJWT, err := auth.MakeJWT(userID, auth.ROLE_USER, 3, hmacRing(tokenSecret), auth.DEFAULT_ACCESS_TOKEN_DURATION)
+	if err != nil {
+		t.Errorf(`MakeJWT failed: %v`, err)
+		return
+	}
+	secondJWT, err := auth.MakeJWT(userID, auth.ROLE_USER, 3, hmacRing(tokenSecret), auth.DEFAULT_ACCESS_TOKEN_DURATION)
+	if err != nil {
+		t.Errorf(`MakeJWT failed: %v`, err)
+		return
+	}
+
+	first, err := auth.ValidateJWT(firstJWT, hmacRing(tokenSecret), versionIs(3))
+	if err != nil {
+		t.Errorf(`ValidateJWT failed on the current version: %v`, err)
+		return
+	}
+	second, err := auth.ValidateJWT(secondJWT, hmacRing(tokenSecret), versionIs(3))
+	if err != nil {
+		t.Errorf(`ValidateJWT failed on the current version: %v`, err)
+		return
+	}
+	if len(first.TokenID) <= 0 || first.TokenID == second.TokenID {
+		t.Errorf(`every token should get its own jti, got %q and %q`, first.TokenID, second.TokenID)
+	}
+	if first.ExpiresAt.Before(time.Now()) {
+		t.Errorf(`ValidateJWT returned an expiry in the past: %v`, first.ExpiresAt)
+	}
+
+	_, err = auth.ValidateJWT(firstJWT, hmacRing(tokenSecret), versionIs(4))
+	if !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf(`ValidateJWT should revoke tokens from an older version, got %v`, err)
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	cases := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{auth.ROLE_ADMIN, auth.ROLE_ADMIN, true},
+		{auth.ROLE_ADMIN, auth.ROLE_MODERATOR, true},
+		{auth.ROLE_MODERATOR, auth.ROLE_MODERATOR, true},
+		{auth.ROLE_MODERATOR, auth.ROLE_ADMIN, false},
+		{auth.ROLE_USER, auth.ROLE_MODERATOR, false},
+		{"superuser", auth.ROLE_USER, false},
+		{"", auth.ROLE_USER, false},
+	}
+
+	for _, c := range cases {
+		if got := auth.HasRole(c.role, c.required); got != c.want {
+			t.Errorf(`HasRole(%q, %q) = %v, want %v`, c.role, c.required, got, c.want)
+		}
+	}
+}
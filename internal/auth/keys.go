@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signing algorithms a key ring understands, named like the JWT alg header
+const (
+	ALGORITHM_HS256 = "HS256"
+	ALGORITHM_RS256 = "RS256"
+	ALGORITHM_EDDSA = "EdDSA"
+)
+
+// HMAC_KEY_ID is the kid of the shared secret. Tokens signed before kids existed
+// have none and are checked against it
+const HMAC_KEY_ID = "secret"
+
+const DEFAULT_ISSUER = "chirpy"
+
+// MIN_RSA_KEY_BITS is the smallest RSA key we sign with, anything shorter is breakable
+const MIN_RSA_KEY_BITS = 2048
+
+var ErrUnknownKey = errors.New("error: token was signed with an unknown or retired key")
+var ErrAlgorithmMismatch = errors.New("error: token algorithm doesn't match its key")
+var ErrNoActiveKey = errors.New("error: key ring has no active key")
+
+// SigningKey is one key in a KeyRing. Asymmetric keys hold the private half
+// so they can sign, and publish only the public half in the JWKS
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Retired   bool
+
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+func NewHMACKey(id string, secret []byte) SigningKey {
+	return SigningKey{
+		ID:        id,
+		Algorithm: ALGORITHM_HS256,
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+func NewRSAKey(id string, privateKey *rsa.PrivateKey) (SigningKey, error) {
+	if privateKey.N.BitLen() < MIN_RSA_KEY_BITS {
+		return SigningKey{}, fmt.Errorf("error: RSA key %s must be at least %d bits", id, MIN_RSA_KEY_BITS)
+	}
+	return SigningKey{
+		ID:        id,
+		Algorithm: ALGORITHM_RS256,
+		method:    jwt.SigningMethodRS256,
+		signKey:   privateKey,
+		verifyKey: &privateKey.PublicKey,
+	}, nil
+}
+
+func NewEd25519Key(id string, privateKey ed25519.PrivateKey) SigningKey {
+	return SigningKey{
+		ID:        id,
+		Algorithm: ALGORITHM_EDDSA,
+		method:    jwt.SigningMethodEdDSA,
+		signKey:   privateKey,
+		verifyKey: privateKey.Public(),
+	}
+}
+
+// ParsePrivateKeyPEM reads an RSA or Ed25519 private key, in PKCS #8 or, for RSA, PKCS #1.
+// The algorithm follows from the key type
+func ParsePrivateKeyPEM(id string, pemBytes []byte) (SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("error: key %s is not PEM encoded", id)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("error: key %s: %w", id, err)
+		}
+		return NewRSAKey(id, privateKey)
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("error: key %s: %w", id, err)
+		}
+		switch privateKey := parsed.(type) {
+		case *rsa.PrivateKey:
+			return NewRSAKey(id, privateKey)
+		case ed25519.PrivateKey:
+			return NewEd25519Key(id, privateKey), nil
+		}
+		return SigningKey{}, fmt.Errorf("error: key %s must be an RSA or Ed25519 key, got %T", id, parsed)
+	}
+	return SigningKey{}, fmt.Errorf("error: key %s must be a PRIVATE KEY or RSA PRIVATE KEY block, got %s", id, block.Type)
+}
+
+func LoadPrivateKeyFile(id, path string) (SigningKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return ParsePrivateKeyPEM(id, pemBytes)
+}
+
+// KeyRing signs with its active key and verifies with any key that isn't retired,
+// so a new key can take over while tokens signed by the old one run out
+type KeyRing struct {
+	Issuer string
+
+	mu       sync.RWMutex
+	keys     map[string]SigningKey
+	activeID string
+}
+
+func NewKeyRing(issuer string) *KeyRing {
+	return &KeyRing{
+		Issuer: issuer,
+		keys:   make(map[string]SigningKey),
+	}
+}
+
+// Add puts a key on the ring, the first key added becomes the active one
+func (ring *KeyRing) Add(key SigningKey) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	if len(key.ID) <= 0 {
+		return errors.New("error: key needs an id")
+	}
+	if _, exists := ring.keys[key.ID]; exists {
+		return fmt.Errorf("error: key %s is already on the ring", key.ID)
+	}
+
+	ring.keys[key.ID] = key
+	if len(ring.activeID) <= 0 && !key.Retired {
+		ring.activeID = key.ID
+	}
+	return nil
+}
+
+// SetActive picks which key signs new tokens
+func (ring *KeyRing) SetActive(id string) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	key, exists := ring.keys[id]
+	if !exists {
+		return fmt.Errorf("error: key %s is not on the ring", id)
+	}
+	if key.Retired {
+		return fmt.Errorf("error: key %s is retired and can't sign", id)
+	}
+	ring.activeID = id
+	return nil
+}
+
+// Retire stops a key from verifying tokens and drops it from the JWKS.
+// The active key can't be retired, activate another one first
+func (ring *KeyRing) Retire(id string) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	key, exists := ring.keys[id]
+	if !exists {
+		return fmt.Errorf("error: key %s is not on the ring", id)
+	}
+	if id == ring.activeID {
+		return fmt.Errorf("error: key %s is active and can't be retired", id)
+	}
+	key.Retired = true
+	ring.keys[id] = key
+	return nil
+}
+
+// ActiveKeyID is the kid new tokens are signed with
+func (ring *KeyRing) ActiveKeyID() string {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return ring.activeID
+}
+
+func (ring *KeyRing) activeKey() (SigningKey, error) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	key, exists := ring.keys[ring.activeID]
+	if !exists {
+		return SigningKey{}, ErrNoActiveKey
+	}
+	return key, nil
+}
+
+// sign signs claims with the active key, naming it in the kid header
+func (ring *KeyRing) sign(claims jwt.Claims) (string, error) {
+	key, err := ring.activeKey()
+	if err != nil {
+		return "", err
+	}
+
+	newToken := jwt.NewWithClaims(key.method, claims)
+	newToken.Header["kid"] = key.ID
+
+	signedString, err := newToken.SignedString(key.signKey)
+	if err != nil {
+		slog.Error("error creating signed string", "err", err)
+		return "", err
+	}
+	return signedString, nil
+}
+
+// parse checks a token's signature, algorithm, issuer and expiry and fills in claims
+func (ring *KeyRing) parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) error {
+	options = append(options,
+		jwt.WithValidMethods(ring.algorithms()),
+		jwt.WithIssuer(ring.Issuer),
+		jwt.WithExpirationRequired(),
+	)
+	_, err := jwt.ParseWithClaims(tokenString, claims, ring.verifyingKey, options...)
+	return err
+}
+
+// verifyingKey finds the key a token names, pinning the algorithm to the one that key uses
+// so a token can never pick how it gets checked
+func (ring *KeyRing) verifyingKey(token *jwt.Token) (any, error) {
+	id := HMAC_KEY_ID
+	if kid, found := token.Header["kid"]; found {
+		kidString, isString := kid.(string)
+		if !isString {
+			return nil, ErrUnknownKey
+		}
+		id = kidString
+	}
+
+	ring.mu.RLock()
+	key, exists := ring.keys[id]
+	ring.mu.RUnlock()
+
+	if !exists || key.Retired {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.verifyKey, nil
+}
+
+// algorithms lists what the ring's keys sign with, so tokens naming anything else fail early
+func (ring *KeyRing) algorithms() []string {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	found := make(map[string]bool)
+	algorithms := make([]string, 0)
+	for _, key := range ring.keys {
+		if !found[key.Algorithm] {
+			found[key.Algorithm] = true
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	return algorithms
+}
+
+// JSONWebKey is a public key as RFC 7517 describes it
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS publishes the public half of every asymmetric key that isn't retired, sorted by kid.
+// Shared secrets are never published, other services can't verify those
+func (ring *KeyRing) JWKS() JSONWebKeySet {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	keySet := JSONWebKeySet{Keys: make([]JSONWebKey, 0)}
+	for _, key := range ring.keys {
+		if key.Retired {
+			continue
+		}
+
+		webKey := JSONWebKey{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			webKey.KeyType = "RSA"
+			webKey.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			webKey.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			webKey.KeyType = "OKP"
+			webKey.Curve = "Ed25519"
+			webKey.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		default:
+			continue
+		}
+		keySet.Keys = append(keySet.Keys, webKey)
+	}
+
+	sort.Slice(keySet.Keys, func(i, j int) bool {
+		return keySet.Keys[i].KeyID < keySet.Keys[j].KeyID
+	})
+	return keySet
+}
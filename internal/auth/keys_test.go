@@ -0,0 +1,211 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newAsymmetricRing(t *testing.T) (*auth.KeyRing, *rsa.PrivateKey, ed25519.PrivateKey) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf(`error generating RSA key: %v`, err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`error generating Ed25519 key: %v`, err)
+	}
+
+	keys := auth.NewKeyRing(auth.DEFAULT_ISSUER)
+	signingKey, err := auth.NewRSAKey("rsa-1", rsaKey)
+	if err != nil {
+		t.Fatalf(`NewRSAKey failed: %v`, err)
+	}
+	for _, key := range []auth.SigningKey{signingKey, auth.NewEd25519Key("ed-1", edKey), auth.NewHMACKey(auth.HMAC_KEY_ID, []byte("this-is-my-secret-token"))} {
+		err = keys.Add(key)
+		if err != nil {
+			t.Fatalf(`Add failed: %v`, err)
+		}
+	}
+	return keys, rsaKey, edKey
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	keys, _, _ := newAsymmetricRing(t)
+	userID := uuid.New()
+
+	if keys.ActiveKeyID() != "rsa-1" {
+		t.Errorf(`the first key added should be active, got %q`, keys.ActiveKeyID())
+		return
+	}
+	rsaToken, err := auth.MakeJWT(userID, auth.ROLE_USER, 0, keys, time.Hour)
+	if err != nil {
+		t.Errorf(`MakeJWT failed: %v`, err)
+		return
+	}
+
+	err = keys.SetActive("ed-1")
+	if err != nil {
+		t.Errorf(`SetActive failed: %v`, err)
+		return
+	}
+	edToken, err := auth.MakeJWT(userID, auth.ROLE_USER, 0, keys, time.Hour)
+	if err != nil {
+		t.Errorf(`MakeJWT failed: %v`, err)
+		return
+	}
+
+	for name, token := range map[string]string{"rsa-1": rsaToken, "ed-1": edToken} {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+		if err != nil || parsed.Header["kid"] != name {
+			t.Errorf(`token should name its key %q in the header, got %v: %v`, name, parsed.Header["kid"], err)
+		}
+		claims, err := auth.ValidateJWT(token, keys, versionIs(0))
+		if err != nil || claims.UserID != userID {
+			t.Errorf(`token signed by %s should still verify: %v`, name, err)
+		}
+	}
+
+	// the old key stops verifying once it's retired
+	err = keys.Retire("rsa-1")
+	if err != nil {
+		t.Errorf(`Retire failed: %v`, err)
+		return
+	}
+	_, err = auth.ValidateJWT(rsaToken, keys, versionIs(0))
+	if !errors.Is(err, auth.ErrUnknownKey) {
+		t.Errorf(`token signed by a retired key should fail with ErrUnknownKey, got %v`, err)
+	}
+	_, err = auth.ValidateJWT(edToken, keys, versionIs(0))
+	if err != nil {
+		t.Errorf(`token signed by the active key should verify: %v`, err)
+	}
+
+	if keys.Retire("ed-1") == nil {
+		t.Errorf(`the active key should not be retirable`)
+	}
+	if keys.SetActive("rsa-1") == nil {
+		t.Errorf(`a retired key should not become active`)
+	}
+}
+
+func TestKeyRingPinsAlgorithmAndIssuer(t *testing.T) {
+	keys, _, _ := newAsymmetricRing(t)
+	claims := auth.Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    auth.DEFAULT_ISSUER,
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+
+	// an HS256 token claiming to come from the RSA key must not be checked as HMAC
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	confused.Header["kid"] = "rsa-1"
+	signed, err := confused.SignedString([]byte("this-is-my-secret-token"))
+	if err != nil {
+		t.Fatalf(`error signing token: %v`, err)
+	}
+	_, err = auth.ParseJWT(signed, keys)
+	if !errors.Is(err, auth.ErrAlgorithmMismatch) {
+		t.Errorf(`a token with the wrong algorithm for its key should fail with ErrAlgorithmMismatch, got %v`, err)
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	signed, err = unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf(`error signing token: %v`, err)
+	}
+	_, err = auth.ParseJWT(signed, keys)
+	if err == nil {
+		t.Errorf(`an unsigned token should be rejected`)
+	}
+
+	otherIssuer := auth.NewKeyRing("someone-else")
+	otherIssuer.Add(auth.NewHMACKey(auth.HMAC_KEY_ID, []byte("this-is-my-secret-token")))
+	signed, err = auth.MakeJWT(uuid.New(), auth.ROLE_USER, 0, otherIssuer, time.Hour)
+	if err != nil {
+		t.Fatalf(`MakeJWT failed: %v`, err)
+	}
+	_, err = auth.ParseJWT(signed, keys)
+	if !errors.Is(err, jwt.ErrTokenInvalidIssuer) {
+		t.Errorf(`a token from another issuer should be rejected, got %v`, err)
+	}
+
+	// tokens from before kids existed are checked against the shared secret
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err = legacy.SignedString([]byte("this-is-my-secret-token"))
+	if err != nil {
+		t.Fatalf(`error signing token: %v`, err)
+	}
+	_, err = auth.ParseJWT(signed, keys)
+	if err != nil {
+		t.Errorf(`a token without a kid should verify with the shared secret: %v`, err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys, rsaKey, edKey := newAsymmetricRing(t)
+
+	keySet := keys.JWKS()
+	if len(keySet.Keys) != 2 || keySet.Keys[0].KeyID != "ed-1" || keySet.Keys[1].KeyID != "rsa-1" {
+		t.Errorf(`JWKS should list both public keys and never the shared secret: %+v`, keySet)
+		return
+	}
+
+	edWebKey, rsaWebKey := keySet.Keys[0], keySet.Keys[1]
+	if edWebKey.KeyType != "OKP" || edWebKey.Curve != "Ed25519" || edWebKey.Algorithm != auth.ALGORITHM_EDDSA ||
+		edWebKey.X != base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)) {
+		t.Errorf(`Ed25519 key published wrong: %+v`, edWebKey)
+	}
+
+	modulus, err := base64.RawURLEncoding.DecodeString(rsaWebKey.N)
+	if err != nil || new(big.Int).SetBytes(modulus).Cmp(rsaKey.N) != 0 || rsaWebKey.E != "AQAB" || rsaWebKey.Algorithm != auth.ALGORITHM_RS256 {
+		t.Errorf(`RSA key published wrong: %+v`, rsaWebKey)
+	}
+
+	keys.Retire("ed-1")
+	if keySet = keys.JWKS(); len(keySet.Keys) != 1 || keySet.Keys[0].KeyID != "rsa-1" {
+		t.Errorf(`retired keys should leave the JWKS: %+v`, keySet)
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`error generating Ed25519 key: %v`, err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf(`error marshalling Ed25519 key: %v`, err)
+	}
+
+	key, err := auth.ParsePrivateKeyPEM("ed-1", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil || key.Algorithm != auth.ALGORITHM_EDDSA || key.ID != "ed-1" {
+		t.Errorf(`ParsePrivateKeyPEM returned %+v: %v`, key, err)
+	}
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf(`error generating RSA key: %v`, err)
+	}
+	_, err = auth.ParsePrivateKeyPEM("rsa-small", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(smallKey)}))
+	if err == nil {
+		t.Errorf(`ParsePrivateKeyPEM should refuse RSA keys under %d bits`, auth.MIN_RSA_KEY_BITS)
+	}
+
+	_, err = auth.ParsePrivateKeyPEM("garbage", []byte("not a key"))
+	if err == nil {
+		t.Errorf(`ParsePrivateKeyPEM should refuse something that isn't PEM`)
+	}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// password hashing algorithms new hashes can be made with
+const (
+	HASHER_BCRYPT   = "bcrypt"
+	HASHER_ARGON2ID = "argon2id"
+)
+
+// argon2id defaults follow the OWASP recommendation of at least 19 MiB, 2 passes and 1 thread,
+// with more memory since that's what makes guessing expensive
+const (
+	ARGON2ID_DEFAULT_MEMORY  = 64 * 1024 // KiB
+	ARGON2ID_DEFAULT_TIME    = 2
+	ARGON2ID_DEFAULT_THREADS = 1
+	ARGON2ID_SALT_BYTES      = 16
+	ARGON2ID_KEY_BYTES       = 32
+)
+
+const argon2idPrefix = "$" + HASHER_ARGON2ID + "$"
+
+var ErrInvalidHasher = errors.New("error: password hasher must be bcrypt or argon2id")
+var ErrInvalidHash = errors.New("error: password hash is malformed")
+var ErrPasswordMismatch = errors.New("error: password doesn't match the hash")
+
+// PasswordHasher makes new password hashes. CheckPasswordHash verifies hashes from any of them
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether a hash was made with another algorithm or other parameters
+	NeedsRehash(hash string) bool
+}
+
+// NewPasswordHasher picks a hasher by name. Argon2id uses the default parameters
+func NewPasswordHasher(algorithm string, bcryptCost int) (PasswordHasher, error) {
+	switch algorithm {
+	case HASHER_BCRYPT:
+		return BcryptHasher{Cost: bcryptCost}, nil
+	case HASHER_ARGON2ID:
+		return NewArgon2idHasher(), nil
+	}
+	return nil, ErrInvalidHasher
+}
+
+// BcryptHasher hashes with bcrypt, a Cost below bcrypt.MinCost means bcrypt.DefaultCost
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher BcryptHasher) cost() int {
+	if hasher.Cost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return hasher.Cost
+}
+
+func (hasher BcryptHasher) Hash(password string) (string, error) {
+	return HashPasswordWithCost(password, hasher.cost())
+}
+
+func (hasher BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != hasher.cost()
+}
+
+// Argon2idHasher hashes with argon2id, writing hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=2,p=1$<salt>$<key>
+type Argon2idHasher struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+func NewArgon2idHasher() Argon2idHasher {
+	return Argon2idHasher{
+		Memory:  ARGON2ID_DEFAULT_MEMORY,
+		Time:    ARGON2ID_DEFAULT_TIME,
+		Threads: ARGON2ID_DEFAULT_THREADS,
+	}
+}
+
+func (hasher Argon2idHasher) Hash(password string) (string, error) {
+	if len(password) <= 0 {
+		return "", ErrPasswordEmpty
+	}
+
+	salt := make([]byte, ARGON2ID_SALT_BYTES)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hasher.Time, hasher.Memory, hasher.Threads, ARGON2ID_KEY_BYTES)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		hasher.Memory,
+		hasher.Time,
+		hasher.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (hasher Argon2idHasher) NeedsRehash(hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return parsed.params != hasher || len(parsed.key) != ARGON2ID_KEY_BYTES
+}
+
+type argon2idHash struct {
+	params Argon2idHasher
+	salt   []byte
+	key    []byte
+}
+
+func parseArgon2idHash(hash string) (argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HASHER_ARGON2ID {
+		return argon2idHash{}, ErrInvalidHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return argon2idHash{}, ErrInvalidHash
+	}
+
+	parsed := argon2idHash{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.params.Memory, &parsed.params.Time, &parsed.params.Threads)
+	if err != nil || parsed.params.Time <= 0 || parsed.params.Threads <= 0 {
+		return argon2idHash{}, ErrInvalidHash
+	}
+
+	parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idHash{}, ErrInvalidHash
+	}
+	parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(parsed.key) <= 0 {
+		return argon2idHash{}, ErrInvalidHash
+	}
+	return parsed, nil
+}
+
+func checkArgon2idHash(password, hash string) error {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+
+	params := parsed.params
+	key := argon2.IDKey([]byte(password), parsed.salt, params.Time, params.Memory, params.Threads, uint32(len(parsed.key)))
+	if subtle.ConstantTimeCompare(key, parsed.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package auth_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// small parameters keep the tests fast, real hashes use NewArgon2idHasher
+var testArgon2id = auth.Argon2idHasher{Memory: 1024, Time: 1, Threads: 1}
+
+func TestArgon2idHasher(t *testing.T) {
+	hash, err := testArgon2id.Hash("my-super-secure-password")
+	if err != nil {
+		t.Fatalf(`Hash failed: %v`, err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") || strings.Count(hash, "$") != 5 {
+		t.Errorf(`hash should be in the PHC string format, got %q`, hash)
+	}
+
+	err = auth.CheckPasswordHash("my-super-secure-password", hash)
+	if err != nil {
+		t.Errorf(`CheckPasswordHash failed with the correct password: %v`, err)
+	}
+	err = auth.CheckPasswordHash("some-random-password", hash)
+	if !errors.Is(err, auth.ErrPasswordMismatch) {
+		t.Errorf(`CheckPasswordHash should fail with ErrPasswordMismatch for the wrong password, got %v`, err)
+	}
+
+	// the parameters come from the hash, so changing them changes the key
+	err = auth.CheckPasswordHash("my-super-secure-password", strings.Replace(hash, "m=1024", "m=2048", 1))
+	if err == nil {
+		t.Errorf(`a hash with tampered parameters should not verify`)
+	}
+	err = auth.CheckPasswordHash("my-super-secure-password", "$argon2id$v=19$m=1024,t=1,p=1$not-base64!")
+	if !errors.Is(err, auth.ErrInvalidHash) {
+		t.Errorf(`a malformed hash should fail with ErrInvalidHash, got %v`, err)
+	}
+
+	_, err = testArgon2id.Hash("")
+	if !errors.Is(err, auth.ErrPasswordEmpty) {
+		t.Errorf(`Hash should refuse an empty password, got %v`, err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := auth.BcryptHasher{Cost: bcrypt.MinCost}.Hash("my-super-secure-password")
+	if err != nil {
+		t.Fatalf(`Hash failed: %v`, err)
+	}
+	argon2idHash, err := testArgon2id.Hash("my-super-secure-password")
+	if err != nil {
+		t.Fatalf(`Hash failed: %v`, err)
+	}
+
+	cases := []struct {
+		name   string
+		hasher auth.PasswordHasher
+		hash   string
+		want   bool
+	}{
+		{"same bcrypt cost", auth.BcryptHasher{Cost: bcrypt.MinCost}, bcryptHash, false},
+		{"higher bcrypt cost", auth.BcryptHasher{Cost: bcrypt.MinCost + 1}, bcryptHash, true},
+		{"bcrypt to argon2id", testArgon2id, bcryptHash, true},
+		{"same argon2id parameters", testArgon2id, argon2idHash, false},
+		{"more argon2id memory", auth.Argon2idHasher{Memory: 2048, Time: 1, Threads: 1}, argon2idHash, true},
+		{"argon2id to bcrypt", auth.BcryptHasher{Cost: bcrypt.MinCost}, argon2idHash, true},
+		{"garbage", testArgon2id, "not a hash", true},
+	}
+	for _, c := range cases {
+		if got := c.hasher.NeedsRehash(c.hash); got != c.want {
+			t.Errorf(`%s: NeedsRehash returned %v, want %v`, c.name, got, c.want)
+		}
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	hasher, err := auth.NewPasswordHasher(auth.HASHER_BCRYPT, 12)
+	if err != nil || hasher != (auth.BcryptHasher{Cost: 12}) {
+		t.Errorf(`NewPasswordHasher returned %+v: %v`, hasher, err)
+	}
+	hasher, err = auth.NewPasswordHasher(auth.HASHER_ARGON2ID, 12)
+	if err != nil || hasher != auth.NewArgon2idHasher() {
+		t.Errorf(`NewPasswordHasher returned %+v: %v`, hasher, err)
+	}
+	_, err = auth.NewPasswordHasher("md5", 12)
+	if !errors.Is(err, auth.ErrInvalidHasher) {
+		t.Errorf(`NewPasswordHasher should refuse unknown algorithms, got %v`, err)
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// purposes a signed token can be made for. Each one is its own audience,
+// so a token made for one purpose is useless for any other
+const (
+	PURPOSE_EMAIL_VERIFICATION = "email_verification"
+	PURPOSE_MFA_CHALLENGE      = "mfa_challenge"
+)
+
+// PURPOSE_KEY_ID is the kid of the shared secret purpose tokens are signed with
+const PURPOSE_KEY_ID = "purpose"
+
+// purposeSecretLabel tells a purpose secret derived from the access token secret apart from it
+const purposeSecretLabel = "chirpy purpose tokens"
+
+var ErrWrongPurpose = errors.New("error: token was made for something else")
+
+// PurposeToken is what a signed purpose token says: who it's for and which stored row it matches.
+// The row is what makes the token single use. Version is the user's token version when it was made
+type PurposeToken struct {
+	UserID  uuid.UUID
+	TokenID uuid.UUID
+	Version int32
+}
+
+type purposeClaims struct {
+	jwt.RegisteredClaims
+	Version int32 `json:"ver"`
+}
+
+// NewPurposeKeyRing holds the secret purpose tokens are signed with. It has to stay apart from
+// the access token ring: anything trusting the published JWKS would otherwise take a token
+// made before the second factor, or mailed out in a link, for an access token
+func NewPurposeKeyRing(issuer string, secret []byte) *KeyRing {
+	keys := NewKeyRing(issuer)
+	keys.Add(NewHMACKey(PURPOSE_KEY_ID, secret))
+	return keys
+}
+
+// DerivePurposeSecret turns the access token secret into a different one for purpose tokens,
+// for setups that don't configure a purpose secret of their own
+func DerivePurposeSecret(accessSecret []byte) []byte {
+	mac := hmac.New(sha256.New, accessSecret)
+	mac.Write([]byte(purposeSecretLabel))
+	return mac.Sum(nil)
+}
+
+// MakePurposeToken signs a short lived token with the ring's active key, which should come
+// from NewPurposeKeyRing
+func MakePurposeToken(purpose string, userID, tokenID uuid.UUID, tokenVersion int32, keys *KeyRing, expiresIn time.Duration) (string, error) {
+	currentTime := time.Now()
+	return keys.sign(purposeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keys.Issuer,
+			Audience:  jwt.ClaimStrings{purpose},
+			IssuedAt:  jwt.NewNumericDate(currentTime),
+			ExpiresAt: jwt.NewNumericDate(currentTime.Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        tokenID.String(),
+		},
+		Version: tokenVersion,
+	})
+}
+
+// ParsePurposeToken checks the token like any other and that it was made for purpose
+func ParsePurposeToken(tokenString, purpose string, keys *KeyRing) (PurposeToken, error) {
+	claims := &purposeClaims{}
+	err := keys.parse(tokenString, claims, jwt.WithAudience(purpose))
+	if errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		return PurposeToken{}, ErrWrongPurpose
+	}
+	if err != nil {
+		slog.Debug("error validating purpose token", "purpose", purpose, "err", err)
+		return PurposeToken{}, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return PurposeToken{}, err
+	}
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return PurposeToken{}, err
+	}
+
+	return PurposeToken{UserID: userID, TokenID: tokenID, Version: claims.Version}, nil
+}
@@ -0,0 +1,78 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestPurposeTokens(t *testing.T) {
+	keys := hmacRing("this-is-my-secret-token")
+	purposeKeys := auth.NewPurposeKeyRing(auth.DEFAULT_ISSUER, auth.DerivePurposeSecret([]byte("this-is-my-secret-token")))
+	userID, tokenID := uuid.New(), uuid.New()
+
+	token, err := auth.MakePurposeToken(auth.PURPOSE_EMAIL_VERIFICATION, userID, tokenID, 3, purposeKeys, time.Hour)
+	if err != nil {
+		t.Errorf(`MakePurposeToken failed: %v`, err)
+		return
+	}
+
+	parsed, err := auth.ParsePurposeToken(token, auth.PURPOSE_EMAIL_VERIFICATION, purposeKeys)
+	if err != nil || parsed.UserID != userID || parsed.TokenID != tokenID || parsed.Version != 3 {
+		t.Errorf(`ParsePurposeToken returned %+v: %v`, parsed, err)
+	}
+
+	_, err = auth.ParsePurposeToken(token, "some_other_purpose", purposeKeys)
+	if !errors.Is(err, auth.ErrWrongPurpose) {
+		t.Errorf(`a token made for one purpose should not work for another, got %v`, err)
+	}
+
+	// a purpose token must never pass as an access token, nor the other way around
+	_, err = auth.ValidateJWT(token, keys, versionIs(3))
+	if !errors.Is(err, auth.ErrUnknownKey) {
+		t.Errorf(`a purpose token should not validate as an access token, got %v`, err)
+	}
+	accessToken, err := auth.MakeJWT(userID, auth.ROLE_USER, 0, keys, time.Hour)
+	if err != nil {
+		t.Errorf(`MakeJWT failed: %v`, err)
+		return
+	}
+	_, err = auth.ParsePurposeToken(accessToken, auth.PURPOSE_EMAIL_VERIFICATION, purposeKeys)
+	if err == nil {
+		t.Errorf(`an access token should not parse as a purpose token`)
+	}
+
+	// even signed by the same ring, the audience keeps them apart
+	sameRing, err := auth.MakePurposeToken(auth.PURPOSE_EMAIL_VERIFICATION, userID, tokenID, 0, keys, time.Hour)
+	if err != nil {
+		t.Errorf(`MakePurposeToken failed: %v`, err)
+		return
+	}
+	_, err = auth.ValidateJWT(sameRing, keys, versionIs(0))
+	if !errors.Is(err, auth.ErrWrongPurpose) {
+		t.Errorf(`a purpose token should not validate as an access token, got %v`, err)
+	}
+
+	expired, err := auth.MakePurposeToken(auth.PURPOSE_EMAIL_VERIFICATION, userID, tokenID, 0, purposeKeys, -time.Minute)
+	if err != nil {
+		t.Errorf(`MakePurposeToken failed: %v`, err)
+		return
+	}
+	_, err = auth.ParsePurposeToken(expired, auth.PURPOSE_EMAIL_VERIFICATION, purposeKeys)
+	if err == nil {
+		t.Errorf(`an expired purpose token should be rejected`)
+	}
+}
+
+func TestPurposeKeysArePrivate(t *testing.T) {
+	purposeKeys := auth.NewPurposeKeyRing(auth.DEFAULT_ISSUER, auth.DerivePurposeSecret([]byte("this-is-my-secret-token")))
+	if len(purposeKeys.JWKS().Keys) != 0 {
+		t.Errorf(`the purpose key should never be published: %+v`, purposeKeys.JWKS())
+	}
+	if string(auth.DerivePurposeSecret([]byte("this-is-my-secret-token"))) == "this-is-my-secret-token" {
+		t.Errorf(`the derived secret should differ from the access token secret`)
+	}
+}
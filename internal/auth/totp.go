@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP settings every authenticator app understands: SHA-1, six digits, thirty second steps
+const TOTP_PERIOD = 30 * time.Second
+const TOTP_DIGITS = 6
+const TOTP_ALGORITHM = "SHA1"
+
+// TOTP_SKEW is how many steps either side of now are accepted, for phones with drifting clocks
+const TOTP_SKEW = 1
+
+// TOTP_SECRET_BYTES is the 160 bits RFC 4226 recommends for the shared secret
+const TOTP_SECRET_BYTES = 20
+
+// RECOVERY_CODE_COUNT codes are handed out at a time, each RECOVERY_CODE_BYTES of randomness
+const RECOVERY_CODE_COUNT = 10
+const RECOVERY_CODE_BYTES = 10
+
+var ErrInvalidTOTPSecret = errors.New("error: TOTP secret must be base32")
+
+// secrets are shown without padding, the way authenticator apps expect them
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret makes a random shared secret, base32 encoded for the otpauth URI
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, TOTP_SECRET_BYTES)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI is what an authenticator app scans, as described by Google's Key URI Format
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", TOTP_ALGORITHM)
+	query.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	query.Set("period", fmt.Sprint(int(TOTP_PERIOD.Seconds())))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// HOTP is RFC 4226: an HMAC-SHA-1 of the counter, dynamically truncated to digits decimal digits
+func HOTP(key []byte, counter uint64, digits int) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, truncated%modulus)
+}
+
+// TOTPStep is the RFC 6238 time step a moment falls in
+func TOTPStep(at time.Time) int64 {
+	return at.Unix() / int64(TOTP_PERIOD.Seconds())
+}
+
+// TOTP is RFC 6238: HOTP with the time step as the counter
+func TOTP(key []byte, at time.Time, digits int) string {
+	return HOTP(key, uint64(TOTPStep(at)), digits)
+}
+
+// ValidateTOTP checks a code against the steps around at and returns the step it matched.
+// Callers store that step and refuse anything not after it, so a code can't be replayed
+func ValidateTOTP(secret, code string, at time.Time) (int64, bool, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false, ErrInvalidTOTPSecret
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != TOTP_DIGITS {
+		return 0, false, nil
+	}
+
+	current := TOTPStep(at)
+	for step := current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		expected := HOTP(key, uint64(step), TOTP_DIGITS)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// NewRecoveryCodes makes one-time codes like abcd-efgh-ijkl-mnop. Each has 80 random bits,
+// so like refresh tokens they're stored as fast hashes, see HashRecoveryCode
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		random := make([]byte, RECOVERY_CODE_BYTES)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded := strings.ToLower(totpEncoding.EncodeToString(random))
+		groups := make([]string, 0, len(encoded)/4)
+		for start := 0; start < len(encoded); start += 4 {
+			groups = append(groups, encoded[start:min(start+4, len(encoded))])
+		}
+		codes = append(codes, strings.Join(groups, "-"))
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode ignores case, spaces and dashes so a code typed in by hand still matches
+func HashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	return HashRefreshToken(normalized)
+}
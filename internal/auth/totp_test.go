@@ -0,0 +1,120 @@
+package auth_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226 appendix D
+	key := []byte("12345678901234567890")
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range want {
+		if got := auth.HOTP(key, uint64(counter), 6); got != code {
+			t.Errorf(`HOTP(counter %d) = %s, want %s`, counter, got, code)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, the SHA-1 rows
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, c := range cases {
+		if got := auth.TOTP(key, time.Unix(c.unix, 0), 8); got != c.code {
+			t.Errorf(`TOTP(%d) = %s, want %s`, c.unix, got, c.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	at := time.Unix(1111111111, 0)
+	code := auth.TOTP([]byte("12345678901234567890"), at, auth.TOTP_DIGITS)
+
+	step, ok, err := auth.ValidateTOTP(secret, code, at)
+	if err != nil || !ok || step != auth.TOTPStep(at) {
+		t.Errorf(`ValidateTOTP should accept the current code, got %d %v %v`, step, ok, err)
+	}
+
+	// one step of drift is fine, two is not
+	_, ok, _ = auth.ValidateTOTP(secret, code, at.Add(auth.TOTP_PERIOD))
+	if !ok {
+		t.Errorf(`ValidateTOTP should accept a code one step old`)
+	}
+	_, ok, _ = auth.ValidateTOTP(secret, code, at.Add(2*auth.TOTP_PERIOD))
+	if ok {
+		t.Errorf(`ValidateTOTP should refuse a code two steps old`)
+	}
+
+	_, ok, _ = auth.ValidateTOTP(secret, "12345", at)
+	if ok {
+		t.Errorf(`ValidateTOTP should refuse a code of the wrong length`)
+	}
+	_, _, err = auth.ValidateTOTP("not base32!", code, at)
+	if err == nil {
+		t.Errorf(`ValidateTOTP should fail on a secret that isn't base32`)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf(`NewTOTPSecret failed: %v`, err)
+	}
+	if len(secret) != 32 || strings.Contains(secret, "=") {
+		t.Errorf(`160 bit secrets should be 32 base32 characters without padding, got %q`, secret)
+	}
+
+	parsed, err := url.Parse(auth.TOTPURI("Chirpy", "walt@breakingbad.com", secret))
+	if err != nil {
+		t.Fatalf(`TOTPURI is not a URL: %v`, err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/Chirpy:walt@breakingbad.com" {
+		t.Errorf(`TOTPURI has the wrong label: %s`, parsed)
+	}
+	query := parsed.Query()
+	if query.Get("secret") != secret || query.Get("issuer") != "Chirpy" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf(`TOTPURI has the wrong parameters: %s`, parsed.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf(`NewRecoveryCodes failed: %v`, err)
+	}
+	if len(codes) != auth.RECOVERY_CODE_COUNT {
+		t.Fatalf(`got %d recovery codes, want %d`, len(codes), auth.RECOVERY_CODE_COUNT)
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf(`recovery code %s was handed out twice`, code)
+		}
+		seen[code] = true
+	}
+
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if auth.HashRecoveryCode(typed) != auth.HashRecoveryCode(codes[0]) {
+		t.Errorf(`a code typed in capitals with spaces should still match`)
+	}
+}
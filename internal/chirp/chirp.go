@@ -10,21 +10,88 @@ const EXPIRES_IN_SECONDS_DEFAULT_LIMIT = 3600
 const EXPIRES_IN_SECONDS_MAX_LIMIT = 3600
 
 type ShortChirp struct {
-	ID      uuid.UUID `json:"id"`
-	Message string    `json:"body"`
-	UserID  uuid.UUID `json:"user_id"`
+	ID        uuid.UUID  `json:"id"`
+	Message   string     `json:"body"`
+	UserID    uuid.UUID  `json:"user_id"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
 }
 
 type DetailedChirp struct {
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Body          string     `json:"body"`
+	UserID        uuid.UUID  `json:"user_id"`
+	InReplyTo     *uuid.UUID `json:"in_reply_to"`
+	Deleted       bool       `json:"deleted,omitempty"`
+	LikeCount     int64      `json:"like_count"`
+	RechirpCount  int64      `json:"rechirp_count"`
+	LikedByMe     *bool      `json:"liked_by_me,omitempty"`
+	RechirpedByMe *bool      `json:"rechirped_by_me,omitempty"`
+}
+
+// ThreadNode is a chirp along with every reply beneath it
+type ThreadNode struct {
+	DetailedChirp
+	Replies []ThreadNode `json:"replies"`
+}
+
+type ChirpThread struct {
+	Ancestors []DetailedChirp `json:"ancestors"`
+	Chirp     ThreadNode      `json:"chirp"`
+}
+
+type ChirpPage struct {
+	Chirps     []DetailedChirp `json:"chirps"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	PrevCursor string          `json:"prev_cursor,omitempty"`
+}
+
+type SearchResult struct {
+	DetailedChirp
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type BannedWord struct {
+	Word   string `json:"word"`
+	Action string `json:"action"`
+}
+
+type ModerationFlag struct {
 	ID        uuid.UUID `json:"id"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	Reason    string    `json:"reason"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
 }
 
+type FollowEntry struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type FollowPage struct {
+	Users      []FollowEntry `json:"users"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// ChirpError is the body of every error response
 type ChirpError struct {
-	ErrorMessage string `json:"error"`
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail explains what went wrong. Code is stable for clients to check,
+// Message is meant for people, and Details maps field names to what was wrong with them
+type ErrorDetail struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
 }
 
 type ChirpValidated struct {
@@ -38,11 +105,109 @@ type UserCredentials struct {
 }
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	AccessToken   string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Role          string    `json:"role,omitempty"`
+	EmailVerified bool      `json:"email_verified"`
+}
+
+type EmailVerification struct {
+	Token string `json:"token"`
+}
+
+// MFAChallenge answers a correct password when the account has MFA,
+// the token and a code are then sent to /api/login/mfa
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// MFACode is a code from an authenticator app, or one of the recovery codes instead
+type MFACode struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type MFALogin struct {
+	MFAToken string `json:"mfa_token"`
+	MFACode
+}
+
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// AdminUser is what admins see about an account
+type AdminUser struct {
+	ID                    uuid.UUID  `json:"id"`
+	Email                 string     `json:"email"`
+	Role                  string     `json:"role"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+	IsChirpyRed           bool       `json:"is_chirpy_red"`
+	SuspendedAt           *time.Time `json:"suspended_at"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	EmailVerifiedAt       *time.Time `json:"email_verified_at"`
+}
+
+type AdminUserPage struct {
+	Users      []AdminUser `json:"users"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// AdminUserDetail adds what an account has been up to
+type AdminUserDetail struct {
+	AdminUser
+	ChirpCount          int64              `json:"chirp_count"`
+	ActiveRefreshTokens []RefreshTokenInfo `json:"active_refresh_tokens"`
+}
+
+// LoginLockout is an account that has to wait before trying another password.
+// LockedOut means it hit the lockout threshold rather than just backing off
+type LoginLockout struct {
 	Email        string    `json:"email"`
-	AccessToken  string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Failures     int32     `json:"failures"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+	LockedUntil  time.Time `json:"locked_until"`
+	LockedOut    bool      `json:"locked_out"`
+}
+
+// RefreshTokenInfo describes a refresh token without giving it away
+type RefreshTokenInfo struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Session is one signed in device. Its id stays the same while the refresh token rotates
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type UserRole struct {
+	ID    uuid.UUID `json:"id"`
+	Email string    `json:"email"`
+	Role  string    `json:"role"`
 }
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/logging"
+	"github.com/google/uuid"
+)
+
+// DENYLIST_CLEANUP_INTERVAL is how often denylisted access tokens that expired anyway are dropped
+const DENYLIST_CLEANUP_INTERVAL = 10 * time.Minute
+
+// authenticate checks the request's access token: signature, expiry, token version and the denylist.
+// A valid token isn't enough once the account is suspended or gone, so the user is loaded too
+func (config *ApiConfig) authenticate(r *http.Request) (database.User, auth.AccessClaims, error) {
+	accessToken, err := auth.GetTokenBearer(r.Header)
+	if err != nil {
+		return database.User{}, auth.AccessClaims{}, err
+	}
+
+	foundUser := database.User{}
+	claims, err := auth.ValidateJWT(accessToken, config.Keys, func(userID uuid.UUID) (int32, error) {
+		user, err := config.DbQueries.GetUserViaID(r.Context(), userID)
+		foundUser = user
+		return user.TokenVersion, err
+	})
+	if err != nil {
+		return database.User{}, auth.AccessClaims{}, err
+	}
+
+	logging.SetUserID(r.Context(), claims.UserID)
+
+	if len(claims.TokenID) > 0 {
+		isDenied, err := config.DbQueries.IsAccessTokenDenied(r.Context(), claims.TokenID)
+		if err != nil {
+			return database.User{}, auth.AccessClaims{}, err
+		}
+		if isDenied {
+			return database.User{}, auth.AccessClaims{}, auth.ErrTokenRevoked
+		}
+	}
+
+	if foundUser.SuspendedAt.Valid {
+		return database.User{}, auth.AccessClaims{}, ErrAccountSuspended
+	}
+	// only the emailed reset link can clear this, not a session that may be the reason for it
+	if foundUser.PasswordResetRequired {
+		return database.User{}, auth.AccessClaims{}, ErrPasswordResetRequired
+	}
+
+	return foundUser, claims, nil
+}
+
+// MiddlewareRequireAuth only lets a request through with a live access token,
+// and puts the token's claims in the request context for the handler
+func (config *ApiConfig) MiddlewareRequireAuth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := config.authenticate(r)
+		if err != nil {
+			slog.WarnContext(r.Context(), "error token not valid", "err", err)
+			writeAuthError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithAccessClaims(r.Context(), claims)))
+	})
+}
+
+// LogoutHandler kills the access token it was called with, before it would have expired
+func (config *ApiConfig) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, found := auth.AccessClaimsFromContext(r.Context())
+	if !found {
+		slog.ErrorContext(r.Context(), "logout called without MiddlewareRequireAuth")
+		writeInternalError(w, r)
+		return
+	}
+
+	// tokens made before jti existed can only be killed by bumping the version
+	if len(claims.TokenID) <= 0 {
+		_, err := config.DbQueries.BumpTokenVersion(r.Context(), claims.UserID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error bumping token version", "err", err)
+			writeInternalError(w, r)
+			return
+		}
+		w.WriteHeader(204)
+		return
+	}
+
+	err := config.DbQueries.DenyAccessToken(r.Context(), database.DenyAccessTokenParams{
+		Jti:       claims.TokenID,
+		ExpiresAt: claims.ExpiresAt,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error denylisting access token", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// RunDenylistCleanup drops denylisted access tokens once they've expired anyway,
+// every interval until ctx is done
+func (config *ApiConfig) RunDenylistCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deletedCount, err := config.DbQueries.DeleteExpiredDeniedAccessTokens(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "error cleaning up access token denylist", "err", err)
+				continue
+			}
+			if deletedCount > 0 {
+				slog.DebugContext(ctx, "cleaned up access token denylist", "deleted", deletedCount)
+			}
+		}
+	}
+}
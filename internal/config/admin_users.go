@@ -0,0 +1,273 @@
+package config
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/pagination"
+	"github.com/google/uuid"
+)
+
+func newAdminUser(user database.User) chirp.AdminUser {
+	adminUser := chirp.AdminUser{
+		ID:                    user.ID,
+		Email:                 user.Email,
+		Role:                  user.Role,
+		CreatedAt:             user.CreatedAt,
+		UpdatedAt:             user.UpdatedAt,
+		IsChirpyRed:           user.IsChirpyRed.Bool,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+	if user.SuspendedAt.Valid {
+		suspendedAt := user.SuspendedAt.Time
+		adminUser.SuspendedAt = &suspendedAt
+	}
+	if user.EmailVerifiedAt.Valid {
+		emailVerifiedAt := user.EmailVerifiedAt.Time
+		adminUser.EmailVerifiedAt = &emailVerifiedAt
+	}
+	return adminUser
+}
+
+// ListUsersHandler pages through accounts, newest first.
+// ?email= narrows the list to addresses containing that text
+func (config *ApiConfig) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, cursor, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		writePageParamsError(w, r, err)
+		return
+	}
+
+	emailSearch := sql.NullString{}
+	if email := r.URL.Query().Get("email"); len(email) > 0 {
+		emailSearch = sql.NullString{String: email, Valid: true}
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	users, err := config.DbQueries.ListUsers(r.Context(), database.ListUsersParams{
+		EmailSearch:     emailSearch,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error listing users", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	users, nextCursor, _ := pagination.Window(users, limit, cursor, func(user database.User) (time.Time, uuid.UUID) {
+		return user.CreatedAt, user.ID
+	})
+
+	output := make([]chirp.AdminUser, 0, len(users))
+	for _, user := range users {
+		output = append(output, newAdminUser(user))
+	}
+
+	writePage(w, r, chirp.AdminUserPage{
+		Users:      output,
+		NextCursor: nextCursor,
+	}, nextCursor, "")
+}
+
+func (config *ApiConfig) GetUserDetailHandler(w http.ResponseWriter, r *http.Request) {
+	foundUser, ok := config.findUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	chirpCount, err := config.DbQueries.CountChirpsOfUser(r.Context(), foundUser.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error counting chirps of user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	refreshTokens, err := config.DbQueries.ListActiveRefreshTokensOfUser(r.Context(), foundUser.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error listing refresh tokens of user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	output := chirp.AdminUserDetail{
+		AdminUser:           newAdminUser(foundUser),
+		ChirpCount:          chirpCount,
+		ActiveRefreshTokens: make([]chirp.RefreshTokenInfo, 0, len(refreshTokens)),
+	}
+	for _, refreshToken := range refreshTokens {
+		output.ActiveRefreshTokens = append(output.ActiveRefreshTokens, chirp.RefreshTokenInfo{
+			CreatedAt: refreshToken.CreatedAt,
+			ExpiresAt: refreshToken.ExpiresAt,
+		})
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling user detail", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// SuspendUserHandler stops a user from logging in or using the tokens they already have
+func (config *ApiConfig) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	foundUser, ok := config.findOtherUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := config.DbQueries.SetUserSuspended(r.Context(), database.SetUserSuspendedParams{
+		SuspendedAt: sql.NullTime{
+			Time:  time.Now(),
+			Valid: true,
+		},
+		ID: foundUser.ID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error suspending user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if !config.revokeAllRefreshTokens(w, r, foundUser.ID) {
+		return
+	}
+
+	slog.InfoContext(r.Context(), "user suspended", "target_user_id", foundUser.ID)
+	w.WriteHeader(204)
+}
+
+func (config *ApiConfig) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	foundUser, ok := config.findUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := config.DbQueries.SetUserSuspended(r.Context(), database.SetUserSuspendedParams{
+		SuspendedAt: sql.NullTime{},
+		ID:          foundUser.ID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error unsuspending user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "user unsuspended", "target_user_id", foundUser.ID)
+	w.WriteHeader(204)
+}
+
+// ForcePasswordResetHandler logs a user out everywhere and refuses logins until they choose a new password
+func (config *ApiConfig) ForcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	foundUser, ok := config.findUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := config.DbQueries.SetPasswordResetRequired(r.Context(), database.SetPasswordResetRequiredParams{
+		PasswordResetRequired: true,
+		ID:                    foundUser.ID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error forcing password reset", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if !config.revokeAllRefreshTokens(w, r, foundUser.ID) {
+		return
+	}
+	_, err = config.DbQueries.BumpTokenVersion(r.Context(), foundUser.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error bumping token version", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "password reset forced", "target_user_id", foundUser.ID)
+	w.WriteHeader(204)
+}
+
+// DeleteUserHandler removes an account for good. The foreign keys cascade,
+// so their chirps, tokens, follows, likes and rechirps go with it
+func (config *ApiConfig) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	foundUser, ok := config.findOtherUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	deletedCount, err := config.DbQueries.DeleteUser(r.Context(), foundUser.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error deleting user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if deletedCount <= 0 {
+		writeNotFound(w, r, "User not found")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "user deleted", "target_user_id", foundUser.ID)
+	w.WriteHeader(204)
+}
+
+// findUserParam looks up the user named by the user_id path parameter, answering 404 if there isn't one
+func (config *ApiConfig) findUserParam(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, ok := parseIDParam(w, r, "user_id")
+	if !ok {
+		return database.User{}, false
+	}
+
+	foundUser, err := config.DbQueries.GetUserViaID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeNotFound(w, r, "User not found")
+		return database.User{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding user", "err", err)
+		writeInternalError(w, r)
+		return database.User{}, false
+	}
+	return foundUser, true
+}
+
+// findOtherUserParam is findUserParam for actions admins can't take on themselves,
+// so nobody locks themselves out by accident
+func (config *ApiConfig) findOtherUserParam(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	foundUser, ok := config.findUserParam(w, r)
+	if !ok {
+		return database.User{}, false
+	}
+
+	adminID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return database.User{}, false
+	}
+	if adminID == foundUser.ID {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "Admins can't do this to their own account")
+		return database.User{}, false
+	}
+	return foundUser, true
+}
+
+func (config *ApiConfig) revokeAllRefreshTokens(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	err := config.DbQueries.RevokeAllRefreshTokensOfUser(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error revoking refresh tokens of user", "err", err)
+		writeInternalError(w, r)
+		return false
+	}
+	return true
+}
@@ -1,13 +1,17 @@
 package config
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -15,6 +19,14 @@ import (
 	"github.com/CzarRamos/chirpy/internal/chirp"
 	"github.com/CzarRamos/chirpy/internal/database"
 	"github.com/CzarRamos/chirpy/internal/events"
+	"github.com/CzarRamos/chirpy/internal/logging"
+	"github.com/CzarRamos/chirpy/internal/mailer"
+	"github.com/CzarRamos/chirpy/internal/metrics"
+	"github.com/CzarRamos/chirpy/internal/moderation"
+	"github.com/CzarRamos/chirpy/internal/pagination"
+	"github.com/CzarRamos/chirpy/internal/passwordpolicy"
+	"github.com/CzarRamos/chirpy/internal/ratelimit"
+	"github.com/CzarRamos/chirpy/internal/settings"
 	"github.com/google/uuid"
 )
 
@@ -23,12 +35,70 @@ var SORT_DESC_KEYWORD = "desc"
 
 type ApiConfig struct {
 	FileserverHits atomic.Int32
-	DbQueries      *database.Queries
-	SecretToken    string
+	DbQueries      database.Store
+	Keys           *auth.KeyRing
 	PolkaKey       string
+	WordFilter     *moderation.WordListFilter
+	Moderator      moderation.Filter
+	MaxBodyBytes   int64
+
+	// PurposeKeys sign verification links and MFA challenges, and are never published
+	PurposeKeys *auth.KeyRing
+
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+	// PasswordHasher hashes new passwords, nil means bcrypt at its default cost
+	PasswordHasher auth.PasswordHasher
+	// PasswordPolicy is what new passwords are checked against, nil only keeps the hasher's limits
+	PasswordPolicy *passwordpolicy.Policy
+
+	Metrics *metrics.Metrics
+
+	// RateLimiter holds routes to their RateLimitPolicy, nil lets everything through
+	RateLimiter ratelimit.Store
+
+	// Mailer sends verification and password reset emails, nil sends nothing
+	Mailer                    mailer.Mailer
+	EmailVerificationURL      string
+	EmailVerificationLifetime time.Duration
+	RequireVerifiedEmail      bool
+	PasswordResetURL          string
+	PasswordResetLifetime     time.Duration
+
+	// Platform is settings.PLATFORM_DEV or settings.PLATFORM_PROD
+	Platform string
+
+	// dummyHash is compared against when a login names no account
+	dummyHashOnce sync.Once
+	dummyHash     string
+
+	// background tracks work that carries on after its request was answered
+	background sync.WaitGroup
 }
 
+// runInBackground runs work that shouldn't hold up the response
+func (config *ApiConfig) runInBackground(work func()) {
+	config.background.Add(1)
+	go func() {
+		defer config.background.Done()
+		work()
+	}()
+}
+
+// WaitForBackground blocks until work started by handlers has finished.
+// Call it once the server has stopped taking requests
+func (config *ApiConfig) WaitForBackground() {
+	config.background.Wait()
+}
+
+// HandlerResetMetrics wipes every user and the visitor count. It only works on the dev platform
 func (config *ApiConfig) HandlerResetMetrics(w http.ResponseWriter, r *http.Request) {
+	if config.Platform != settings.PLATFORM_DEV {
+		slog.WarnContext(r.Context(), "refused reset outside of dev", "platform", config.Platform)
+		writeForbidden(w, r, "Reset is only allowed on the dev platform")
+		return
+	}
+
 	// reset user list
 	config.DbQueries.RemoveAllUsers(r.Context())
 
@@ -44,7 +114,24 @@ func (config *ApiConfig) HandlerHealthz(w http.ResponseWriter, r *http.Request)
 	w.Write([]byte("OK"))
 }
 
+// HandlerMetrics answers browsers with the admin page and everything else,
+// like a Prometheus scraper, with the text exposition format
 func (config *ApiConfig) HandlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if config.Metrics != nil && !acceptsHTML(r.Header.Get("Accept")) {
+		var output bytes.Buffer
+		err := config.Metrics.Registry.WritePrometheus(&output)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error writing metrics", "err", err)
+			writeInternalError(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", metrics.CONTENT_TYPE)
+		w.WriteHeader(200)
+		w.Write(output.Bytes())
+		return
+	}
+
 	hits := config.FileserverHits.Load()
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(200)
@@ -61,6 +148,26 @@ func (config *ApiConfig) HandlerMetrics(w http.ResponseWriter, r *http.Request)
 	w.Write([]byte(output))
 }
 
+// acceptsHTML reports whether an Accept header asks for text/html
+func acceptsHTML(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		if strings.TrimSpace(mediaType) != "text/html" {
+			continue
+		}
+		// text/html;q=0 means anything but html
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			quality, err := strconv.ParseFloat(value, 64)
+			if name == "q" && err == nil && quality <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func (config *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		config.FileserverHits.Add(1)
@@ -68,21 +175,94 @@ func (config *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
+// MiddlewareRequestMetrics records every request by the route pattern it matched.
+// It has to wrap the ServeMux, which fills in r.Pattern once it picks a route
+func (config *ApiConfig) MiddlewareRequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Metrics == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		config.Metrics.InFlight.Inc()
+		defer config.Metrics.InFlight.Dec()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: 200}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		config.Metrics.ObserveRequest(r.Pattern, r.Method, recorder.status, time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status code a handler sent and how much it wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if !recorder.wroteHeader {
+		recorder.status = status
+		recorder.wroteHeader = true
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	recorder.wroteHeader = true
+	written, err := recorder.ResponseWriter.Write(data)
+	recorder.bytes += written
+	return written, err
+}
+
+// Unwrap lets http.ResponseController reach the real writer
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+// MiddlewareLimitBody stops reading request bodies past MaxBodyBytes,
+// so nobody can tie up the server by uploading forever
+func (config *ApiConfig) MiddlewareLimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.MaxBodyBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, config.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getAuthenticatedUserID returns the user behind the request's access token
+// and notes them down for the access log
+func (config *ApiConfig) getAuthenticatedUserID(r *http.Request) (uuid.UUID, error) {
+	// MiddlewareRequireAuth already did the work
+	if userID, found := auth.UserIDFromContext(r.Context()); found {
+		return userID, nil
+	}
+
+	foundUser, _, err := config.authenticate(r)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return foundUser.ID, nil
+}
+
 func (config *ApiConfig) CreateNewUserHandler(w http.ResponseWriter, r *http.Request) {
-	decoder := json.NewDecoder(r.Body)
 	params := chirp.UserCredentials{}
 	// correct info will be stored in params
-	err := decoder.Decode(&params)
-	if err != nil {
-		log.Printf("error decoding parameters: %s", err)
-		w.WriteHeader(500)
+	if !decodeJSON(w, r, &params) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	err := mailer.ValidateEmail(params.Email)
 	if err != nil {
-		log.Printf("error hashing password: %s", err)
-		w.WriteHeader(500)
+		writeValidationError(w, r, "email", err.Error())
+		return
+	}
+
+	hashedPassword, ok := config.hashPassword(w, r, params.Password, params.Email)
+	if !ok {
 		return
 	}
 
@@ -92,6 +272,21 @@ func (config *ApiConfig) CreateNewUserHandler(w http.ResponseWriter, r *http.Req
 		UpdatedAt:      time.Now(),
 		Email:          params.Email,
 	})
+	if database.IsUniqueViolation(err) {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "Email is already taken")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error creating user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// the account works without it, the user can ask for another email later
+	err = config.sendVerificationEmail(r.Context(), newUser)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error sending verification email", "user_id", newUser.ID, "err", err)
+	}
 
 	userInfo := chirp.User{
 		ID:        newUser.ID,
@@ -100,16 +295,10 @@ func (config *ApiConfig) CreateNewUserHandler(w http.ResponseWriter, r *http.Req
 		Email:     newUser.Email,
 	}
 
-	if err != nil {
-		log.Printf("error decoding parameters: %s", err)
-		w.WriteHeader(500)
-		return
-	}
-
 	data, err := json.Marshal(userInfo)
 	if err != nil {
-		log.Printf("error marshalling newly created user: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error marshalling newly created user", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -119,62 +308,94 @@ func (config *ApiConfig) CreateNewUserHandler(w http.ResponseWriter, r *http.Req
 
 func (config *ApiConfig) NewChirpHandler(w http.ResponseWriter, r *http.Request) {
 
-	output, err := auth.GetTokenBearer(r.Header)
+	userID, err := config.getAuthenticatedUserID(r)
 	if err != nil {
-		log.Printf("error getting token bearer: %s", err)
-		w.WriteHeader(500)
+		slog.WarnContext(r.Context(), "error validating new chirp token", "err", err)
+		writeAuthError(w, r, err)
 		return
 	}
 
-	log.Printf("TOKEN BEARER: %s", output)
-
-	userID, err := auth.ValidateJWT(output, config.SecretToken)
-	if err != nil {
-		log.Printf("error validating new chirp token: %s", err)
-		w.WriteHeader(401)
-		return
+	if config.RequireVerifiedEmail {
+		author, err := config.DbQueries.GetUserViaID(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error finding chirp author", "err", err)
+			writeInternalError(w, r)
+			return
+		}
+		if !author.EmailVerifiedAt.Valid {
+			writeError(w, r, 403, ERROR_CODE_EMAIL_NOT_VERIFIED, "Email must be verified before chirping")
+			return
+		}
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	params := chirp.ShortChirp{}
 	// correct info will be stored in params
-	err = decoder.Decode(&params)
-	if err != nil {
-		log.Printf("error decoding parameters: %s", err)
-		w.WriteHeader(500)
+	if !decodeJSON(w, r, &params) {
 		return
 	}
 
 	isValid := isChirpValid(params)
 
 	if !isValid {
-		w.WriteHeader(400)
-		w.Write(newChirpError("Chirp is too long"))
+		writeValidationError(w, r, "body", "Chirp is too long")
 		return
 	}
 
-	filteredChirp, err := filterChirp(params)
-	if err != nil {
-		log.Printf("error filtering chirp: %s", err)
+	inReplyTo := uuid.NullUUID{}
+	if params.InReplyTo != nil {
+		parentChirp, err := config.DbQueries.GetChirpViaID(r.Context(), *params.InReplyTo)
+		if errors.Is(err, sql.ErrNoRows) || parentChirp.DeletedAt.Valid {
+			writeValidationError(w, r, "in_reply_to", "Chirp being replied to does not exist")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error finding chirp being replied to", "err", err)
+			writeInternalError(w, r)
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parentChirp.ID, Valid: true}
+	}
+
+	verdict := config.moderateChirp(params.Message)
+	if verdict.Action == moderation.ACTION_REJECT {
+		writeErrorDetails(w, r, 422, ERROR_CODE_CONTENT_REJECTED, "Chirp was rejected", map[string]string{
+			"body": strings.Join(verdict.Reasons, ", "),
+		})
 		return
 	}
 
 	newChirp, err := config.DbQueries.CreateChirp(r.Context(), database.CreateChirpParams{
 		ID:        uuid.New(),
 		UpdatedAt: time.Now(),
-		Body:      filteredChirp.Message,
+		Body:      verdict.Body,
 		UserID:    userID,
+		InReplyTo: inReplyTo,
 	})
 	if err != nil {
-		log.Printf("error adding chirp: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error adding chirp", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
+	config.Metrics.ObserveChirpCreated()
+
+	if verdict.Action == moderation.ACTION_FLAG {
+		// the chirp still goes out, a moderator looks at it later
+		err = config.DbQueries.CreateModerationFlag(r.Context(), database.CreateModerationFlagParams{
+			ID:      uuid.New(),
+			ChirpID: newChirp.ID,
+			Reason:  strings.Join(verdict.Reasons, ", "),
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error flagging chirp for review", "err", err)
+		}
+	}
+
 	chirpRes := chirp.ShortChirp{
-		ID:      newChirp.ID,
-		Message: newChirp.Body,
-		UserID:  newChirp.UserID,
+		ID:        newChirp.ID,
+		Message:   newChirp.Body,
+		UserID:    newChirp.UserID,
+		InReplyTo: params.InReplyTo,
 	}
 	w.WriteHeader(201)
 	w.Write(newShortChirpData(chirpRes))
@@ -186,63 +407,70 @@ func isChirpValid(chirp chirp.ShortChirp) bool {
 	return len(chirp.Message) <= 140
 }
 
-func filterChirp(userChirp chirp.ShortChirp) (chirp.ShortChirp, error) {
-
-	var profaneWords [3]string
-	profaneWords[0] = "kerfuffle"
-	profaneWords[1] = "sharbert"
-	profaneWords[2] = "fornax"
-
-	hasProfaneWord := false
-	for _, profaneWord := range profaneWords {
-		if !hasProfaneWord && (strings.Contains(strings.ToLower(userChirp.Message), strings.ToLower(profaneWord)) ||
-			strings.Contains(strings.ToUpper(userChirp.Message), strings.ToUpper(profaneWord))) {
-			hasProfaneWord = true
-			break
-		}
-	}
-
-	if !hasProfaneWord {
-		return userChirp, nil
+func (config *ApiConfig) passwordHasher() auth.PasswordHasher {
+	if config.PasswordHasher == nil {
+		return auth.BcryptHasher{}
 	}
+	return config.PasswordHasher
+}
 
-	splitMessage := strings.Split(userChirp.Message, " ")
-
-	for idx, word := range splitMessage {
-		for _, profaneWord := range profaneWords {
-			if strings.EqualFold(word, profaneWord) {
-				splitMessage[idx] = "****"
-			}
+// hashPassword answers 400 for passwords the policy or the hasher won't take and 500 for anything else.
+// email is who the password is for, so it can't be part of it
+func (config *ApiConfig) hashPassword(w http.ResponseWriter, r *http.Request, password, email string) (string, bool) {
+	if config.PasswordPolicy != nil {
+		err := config.PasswordPolicy.Check(password, email)
+		if err != nil {
+			slog.InfoContext(r.Context(), "refused weak password", "reason", err)
+			writeValidationError(w, r, "password", err.Error())
+			return "", false
 		}
 	}
 
-	modifiedChirp := chirp.ShortChirp{
-		Message: strings.Join(splitMessage, " "),
+	hashedPassword, err := config.passwordHasher().Hash(password)
+	if errors.Is(err, auth.ErrPasswordEmpty) || errors.Is(err, auth.ErrPasswordTooLong) {
+		writeValidationError(w, r, "password", err.Error())
+		return "", false
 	}
-
-	return modifiedChirp, nil
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error hashing password", "err", err)
+		writeInternalError(w, r)
+		return "", false
+	}
+	return hashedPassword, true
 }
 
-func newChirpError(errorMessage string) []byte {
-
-	newChirpError := chirp.ChirpError{
-		ErrorMessage: errorMessage,
+// upgradePasswordHash rehashes a password that just checked out if its hash was made
+// with another algorithm or cost. The login goes ahead whether it works or not
+func (config *ApiConfig) upgradePasswordHash(ctx context.Context, foundUser database.User, password string) {
+	hasher := config.passwordHasher()
+	if !hasher.NeedsRehash(foundUser.HashedPassword) {
+		return
 	}
 
-	data, err := json.Marshal(newChirpError)
+	newHashedPassword, err := hasher.Hash(password)
 	if err != nil {
-		log.Printf("error marshalling error message: %s", err)
-		return nil
+		slog.WarnContext(ctx, "error rehashing password", "user_id", foundUser.ID, "err", err)
+		return
 	}
 
-	return data
+	// only swaps out the hash that was checked, in case the password changed in the meantime
+	_, err = config.DbQueries.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		NewHashedPassword: newHashedPassword,
+		ID:                foundUser.ID,
+		OldHashedPassword: foundUser.HashedPassword,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error saving rehashed password", "user_id", foundUser.ID, "err", err)
+		return
+	}
+	slog.InfoContext(ctx, "password rehashed", "user_id", foundUser.ID)
 }
 
 func newShortChirpData(userChirp chirp.ShortChirp) []byte {
 
 	data, err := json.Marshal(userChirp)
 	if err != nil {
-		log.Printf("error marshalling chirp validity: %s", err)
+		slog.Error("error marshalling chirp validity", "err", err)
 		return nil
 	}
 
@@ -254,245 +482,447 @@ func (config *ApiConfig) GetAllChirpsHandler(w http.ResponseWriter, r *http.Requ
 	authorID := r.URL.Query().Get("author_id")
 	customSort := r.URL.Query().Get("sort")
 
-	foundChirps := make([]chirp.DetailedChirp, 0)
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		writePageParamsError(w, r, err)
+		return
+	}
 
+	authorUUID := uuid.NullUUID{}
 	if len(authorID) > 0 {
-		// authorId exists
-		var err error
-		authorUUID := uuid.Must(uuid.MustParse(authorID), err)
+		// authorId exists, only show their chirps
+		authorUUID.UUID, err = uuid.Parse(authorID)
 		if err != nil {
-			log.Printf("error parsing authorID to UUID: %s", err)
-			w.WriteHeader(500)
+			slog.WarnContext(r.Context(), "error parsing authorID to UUID", "err", err)
+			writeValidationError(w, r, "author_id", "must be a valid id")
 			return
 		}
+		authorUUID.Valid = true
+	}
 
-		allUserChirps, err := config.DbQueries.GetAllChirpsOfUserID(r.Context(), authorUUID)
-		if err != nil {
-			log.Printf("error getting user's chirps: %s", err)
-			w.WriteHeader(500)
-			return
-		}
+	// chirps are shown from oldest to latest unless asked otherwise
+	ascending := customSort != SORT_DESC_KEYWORD
 
-		for _, chirpRow := range allUserChirps {
-			foundChirp := chirp.DetailedChirp{
-				ID:        chirpRow.ID,
-				CreatedAt: chirpRow.CreatedAt,
-				UpdatedAt: chirpRow.UpdatedAt,
-				Body:      chirpRow.Body,
-				UserID:    chirpRow.UserID,
-			}
+	chirpRows, err := config.getChirpsPage(r.Context(), authorUUID, cursor, limit, ascending)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirps", "err", err)
+		writeInternalError(w, r)
+		return
+	}
 
-			foundChirps = append(foundChirps, foundChirp)
-		}
-	} else {
-		// authorId does not exist, show all chirps
-		allChirps, err := config.DbQueries.GetAllChirpsSinceCreation(r.Context())
-		if err != nil {
-			log.Printf("error getting all chirps: %s", err)
-			w.WriteHeader(500)
-			return
-		}
-		for _, chirpRow := range allChirps {
-			foundChirp := chirp.DetailedChirp{
-				ID:        chirpRow.ID,
-				CreatedAt: chirpRow.CreatedAt,
-				UpdatedAt: chirpRow.UpdatedAt,
-				Body:      chirpRow.Body,
-				UserID:    chirpRow.UserID,
-			}
+	chirpRows, nextCursor, prevCursor := pagination.Window(chirpRows, limit, cursor, chirpPosition)
 
-			foundChirps = append(foundChirps, foundChirp)
-		}
+	output := chirp.ChirpPage{
+		Chirps:     make([]chirp.DetailedChirp, 0, len(chirpRows)),
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	}
+	for _, chirpRow := range chirpRows {
+		output.Chirps = append(output.Chirps, newDetailedChirp(chirpRow))
+	}
+
+	err = config.addPageEngagement(r.Context(), config.getViewerID(r), output.Chirps)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirp engagement", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writePage(w, r, output, nextCursor, prevCursor)
+}
+
+// getChirpsPage fetches one more chirp than the limit so we know if another page follows.
+// Walking forward through an ascending list, or back through a descending one,
+// reads the chirps after the cursor. Everything else reads the chirps before it
+func (config *ApiConfig) getChirpsPage(ctx context.Context, authorID uuid.NullUUID, cursor *pagination.Cursor, limit int, ascending bool) ([]database.Chirp, error) {
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+
+	if ascending != cursor.IsPrev() {
+		return config.DbQueries.GetChirpsAfterCursor(ctx, database.GetChirpsAfterCursorParams{
+			AuthorID:        authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			PageLimit:       int32(limit + 1),
+		})
+	}
+
+	return config.DbQueries.GetChirpsBeforeCursor(ctx, database.GetChirpsBeforeCursorParams{
+		AuthorID:        authorID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       int32(limit + 1),
+	})
+}
+
+// cursorArgs turns a cursor into query arguments, leaving them NULL on the first page
+func cursorArgs(cursor *pagination.Cursor) (sql.NullTime, uuid.NullUUID) {
+	if cursor == nil {
+		return sql.NullTime{}, uuid.NullUUID{}
+	}
+	return sql.NullTime{Time: cursor.CreatedAt, Valid: true}, uuid.NullUUID{UUID: cursor.ID, Valid: true}
+}
+
+func newDetailedChirp(chirpRow database.Chirp) chirp.DetailedChirp {
+	detailedChirp := chirp.DetailedChirp{
+		ID:        chirpRow.ID,
+		CreatedAt: chirpRow.CreatedAt,
+		UpdatedAt: chirpRow.UpdatedAt,
+		Body:      chirpRow.Body,
+		UserID:    chirpRow.UserID,
+		Deleted:   chirpRow.DeletedAt.Valid,
+	}
+	if chirpRow.InReplyTo.Valid {
+		parentID := chirpRow.InReplyTo.UUID
+		detailedChirp.InReplyTo = &parentID
+	}
+	return detailedChirp
+}
+
+func chirpPosition(chirpRow database.Chirp) (time.Time, uuid.UUID) {
+	return chirpRow.CreatedAt, chirpRow.ID
+}
+
+// parsePageParams reads the limit and cursor query parameters shared by every paginated list
+func parsePageParams(r *http.Request) (int, *pagination.Cursor, error) {
+	limit, err := pagination.ParseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return 0, nil, err
 	}
 
-	// FoundChirps starts off sorted from oldest to latest
+	cursor, err := pagination.ParseCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		return 0, nil, err
+	}
 
-	// this sorts from latest to oldest
-	if customSort == SORT_DESC_KEYWORD {
-		sort.Slice(foundChirps, func(i, j int) bool { return foundChirps[i].CreatedAt.After(foundChirps[j].CreatedAt) })
+	return limit, cursor, nil
+}
+
+// writePageParamsError points at whichever page parameter was wrong
+func writePageParamsError(w http.ResponseWriter, r *http.Request, err error) {
+	field := "cursor"
+	if errors.Is(err, pagination.ErrInvalidLimit) {
+		field = "limit"
 	}
+	writeValidationError(w, r, field, err.Error())
+}
 
-	data, err := json.Marshal(foundChirps)
+// writePage sends a paginated response along with its Link header
+func writePage(w http.ResponseWriter, r *http.Request, page any, nextCursor, prevCursor string) {
+	data, err := json.Marshal(page)
 	if err != nil {
-		log.Printf("error marshalling chirp validity: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error marshalling page", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	w.Write(data)
+	links := pagination.LinkHeader(r.URL, nextCursor, prevCursor)
+	if len(links) > 0 {
+		w.Header().Set("Link", links)
+	}
+
 	w.WriteHeader(200)
+	w.Write(data)
 }
 
 func (config *ApiConfig) GetChirpViaIdHandler(w http.ResponseWriter, r *http.Request) {
 
-	chirpId := r.PathValue("chirp_id")
-
-	var err error
-	chirpUUID := uuid.Must(uuid.MustParse(chirpId), err)
+	chirpUUID, ok := parseIDParam(w, r, "chirp_id")
+	if !ok {
+		return
+	}
 
 	foundChirp, err := config.DbQueries.GetChirpViaID(r.Context(), chirpUUID)
+	if errors.Is(err, sql.ErrNoRows) || foundChirp.DeletedAt.Valid {
+		writeNotFound(w, r, "Chirp does not exist")
+		return
+	}
 	if err != nil {
-		log.Printf("error chirp does not exist: %s", err)
-		w.WriteHeader(404)
+		slog.ErrorContext(r.Context(), "error finding chirp", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	output := chirp.DetailedChirp{
-		ID:        foundChirp.ID,
-		CreatedAt: foundChirp.CreatedAt,
-		UpdatedAt: foundChirp.UpdatedAt,
-		Body:      foundChirp.Body,
-		UserID:    foundChirp.UserID,
+	output := newDetailedChirp(foundChirp)
+	err = config.addEngagement(r.Context(), config.getViewerID(r), &output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirp engagement", "err", err)
+		writeInternalError(w, r)
+		return
 	}
 
 	data, err := json.Marshal(output)
 	if err != nil {
-		log.Printf("error marshalling found chirp: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error marshalling found chirp", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	w.Write(data)
 	w.WriteHeader(200)
+	w.Write(data)
 }
 
 func (config *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
-	decoder := json.NewDecoder(r.Body)
 	params := chirp.UserCredentials{}
 	// correct info will be stored in params
-	err := decoder.Decode(&params)
-	if err != nil {
-		log.Printf("error decoding chirp login data: %s", err)
-		w.WriteHeader(500)
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	attempt, ok := config.beginLoginAttempt(w, r, params.Email)
+	if !ok {
 		return
 	}
 
 	foundUser, err := config.DbQueries.GetUserViaEmail(r.Context(), params.Email)
 	if err != nil {
-		log.Printf("Incorrect email or password: %s", err)
-		w.WriteHeader(401)
+		config.dummyPasswordCheck(params.Password)
+		slog.WarnContext(r.Context(), "Incorrect email or password", "err", err)
+		config.recordLoginFailure(r.Context(), attempt)
+		config.Metrics.ObserveLogin(false)
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "Incorrect email or password")
 		return
 	}
 
 	err = auth.CheckPasswordHash(params.Password, foundUser.HashedPassword)
 	if err != nil {
-		log.Printf("Incorrect email or password: %s", err)
-		w.WriteHeader(401)
+		slog.WarnContext(r.Context(), "Incorrect email or password", "err", err)
+		config.recordLoginFailure(r.Context(), attempt)
+		config.Metrics.ObserveLogin(false)
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "Incorrect email or password")
 		return
 	}
+	config.refundLoginAttempt(r.Context(), attempt)
+	config.upgradePasswordHash(r.Context(), foundUser, params.Password)
 
-	newAccessToken, err := auth.MakeJWT(foundUser.ID, config.SecretToken)
+	// only checked once the password matched, so nobody learns an account is suspended without knowing it
+	if !config.canLogIn(w, r, foundUser) {
+		return
+	}
+
+	mfaEnabled, err := config.hasMFA(r.Context(), foundUser.ID)
 	if err != nil {
-		log.Printf("error creating token: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error checking for MFA", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if mfaEnabled {
+		config.writeMFAChallenge(w, r, foundUser)
 		return
 	}
 
-	newRefreshToken, err := auth.MakeRefreshToken()
+	config.writeLogin(w, r, foundUser)
+}
+
+// canLogIn turns away suspended accounts and accounts that must reset their password first
+func (config *ApiConfig) canLogIn(w http.ResponseWriter, r *http.Request, foundUser database.User) bool {
+	if foundUser.SuspendedAt.Valid {
+		slog.WarnContext(r.Context(), "suspended user tried to log in", "user_id", foundUser.ID)
+		config.Metrics.ObserveLogin(false)
+		writeAccountSuspended(w, r)
+		return false
+	}
+	if foundUser.PasswordResetRequired {
+		slog.InfoContext(r.Context(), "login needs a password reset", "user_id", foundUser.ID)
+		config.Metrics.ObserveLogin(false)
+		writePasswordResetRequired(w, r)
+		return false
+	}
+	return true
+}
+
+// writeLogin hands out a new access token and starts a new refresh token family
+func (config *ApiConfig) writeLogin(w http.ResponseWriter, r *http.Request, foundUser database.User) {
+	newAccessToken, err := auth.MakeJWT(foundUser.ID, foundUser.Role, foundUser.TokenVersion, config.Keys, config.AccessTokenLifetime)
 	if err != nil {
-		log.Printf("error creating refresh token: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error creating token", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	// add new refresh token to Db
-	_, err = config.DbQueries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:     newRefreshToken.Token,
-		UpdatedAt: time.Now(),
-		ExpiresAt: newRefreshToken.ExpiresAt,
-		UserID:    foundUser.ID,
-	})
+	// every login starts a new token family
+	newRefreshToken, err := config.issueRefreshToken(r, foundUser.ID, uuid.New())
 	if err != nil {
-		log.Printf("error adding refresh tokento db: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error creating refresh token", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
+	// only a finished login clears the count, so a stolen password can't reset it between MFA guesses
+	config.clearLoginFailures(r.Context(), foundUser.Email)
+	config.Metrics.ObserveLogin(true)
+	logging.SetUserID(r.Context(), foundUser.ID)
+
 	output := chirp.User{
-		ID:           foundUser.ID,
-		CreatedAt:    foundUser.CreatedAt,
-		UpdatedAt:    foundUser.UpdatedAt,
-		Email:        foundUser.Email,
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken.Token,
-		IsChirpyRed:  foundUser.IsChirpyRed.Bool,
+		ID:            foundUser.ID,
+		CreatedAt:     foundUser.CreatedAt,
+		UpdatedAt:     foundUser.UpdatedAt,
+		Email:         foundUser.Email,
+		AccessToken:   newAccessToken,
+		RefreshToken:  newRefreshToken.Token,
+		IsChirpyRed:   foundUser.IsChirpyRed.Bool,
+		Role:          foundUser.Role,
+		EmailVerified: foundUser.EmailVerifiedAt.Valid,
 	}
 
 	data, err := json.Marshal(output)
 	if err != nil {
-		log.Printf("error marshalling returning user info: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error marshalling returning user info", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	w.Write([]byte(data))
 	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// issueRefreshToken makes a refresh token in the given family and stores its hash,
+// along with the device and address the request came from
+func (config *ApiConfig) issueRefreshToken(r *http.Request, userID, familyID uuid.UUID) (auth.NewRefreshToken, error) {
+	newRefreshToken, err := auth.MakeRefreshToken(config.RefreshTokenLifetime)
+	if err != nil {
+		return auth.NewRefreshToken{}, err
+	}
+
+	_, err = config.DbQueries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		ID:        uuid.New(),
+		TokenHash: newRefreshToken.Hash,
+		FamilyID:  familyID,
+		UpdatedAt: time.Now(),
+		ExpiresAt: newRefreshToken.ExpiresAt,
+		UserID:    userID,
+		UserAgent: userAgent(r),
+		Ip:        clientIP(r),
+	})
+	if err != nil {
+		return auth.NewRefreshToken{}, err
+	}
+
+	return newRefreshToken, nil
+}
+
+// revokeReusedFamily handles a refresh token that was already used or revoked showing up again.
+// Either the client or an attacker holds a stolen copy, so every token in the family stops working
+func (config *ApiConfig) revokeReusedFamily(w http.ResponseWriter, r *http.Request, refreshToken database.RefreshToken) {
+	slog.WarnContext(r.Context(), "revoked refresh token reused, revoking its family", "user_id", refreshToken.UserID, "family_id", refreshToken.FamilyID)
+
+	_, err := config.DbQueries.RevokeRefreshTokenFamily(r.Context(), database.RevokeRefreshTokenFamilyParams{
+		FamilyID: refreshToken.FamilyID,
+		UserID:   refreshToken.UserID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error revoking refresh token family", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	writeAuthError(w, r, nil)
 }
 
+// RefreshHandler swaps a refresh token for a new access token and a new refresh token.
+// The old refresh token is revoked, so each one works exactly once
 func (config *ApiConfig) RefreshHandler(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetTokenBearer(r.Header)
 	if err != nil {
-		log.Printf("error getting token bearer info: %s", err)
-		w.WriteHeader(500)
+		slog.WarnContext(r.Context(), "error getting token bearer info", "err", err)
+		writeAuthError(w, r, err)
 		return
 	}
 
 	// check if token exists
-	foundRefreshToken, err := config.DbQueries.GetUserViaRefreshToken(r.Context(), refreshToken)
+	foundRefreshToken, err := config.DbQueries.GetRefreshTokenViaHash(r.Context(), auth.HashRefreshToken(refreshToken))
 	if err != nil {
-		log.Printf("error token is not valid: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("error: token is not valid"))
+		slog.WarnContext(r.Context(), "error token is not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	logging.SetUserID(r.Context(), foundRefreshToken.UserID)
+
+	if foundRefreshToken.RevokedAt.Valid {
+		config.revokeReusedFamily(w, r, foundRefreshToken)
+		return
+	}
+
+	if foundRefreshToken.ExpiresAt.Compare(time.Now()) <= 0 {
+		slog.WarnContext(r.Context(), "error token has expired")
+		writeAuthError(w, r, auth.ErrTokenExpired)
 		return
 	}
 
-	// if token has already expired or has ever been revoked
-	if foundRefreshToken.ExpiresAt.Compare(time.Now()) <= 0 || foundRefreshToken.RevokedAt.Valid {
-		log.Printf("error token has expired")
-		w.WriteHeader(401)
-		w.Write([]byte("error: token is not valid"))
+	// the role may have changed since login, so read it fresh
+	foundUser, err := config.DbQueries.GetUserViaID(r.Context(), foundRefreshToken.UserID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error finding user of refresh token", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+	if foundUser.SuspendedAt.Valid {
+		slog.WarnContext(r.Context(), "refresh refused for suspended account")
+		writeAuthError(w, r, ErrAccountSuspended)
+		return
+	}
+	if foundUser.PasswordResetRequired {
+		slog.WarnContext(r.Context(), "refresh refused until the password is reset")
+		writeAuthError(w, r, ErrPasswordResetRequired)
 		return
 	}
 
-	newJWTToken, err := auth.MakeJWT(foundRefreshToken.UserID, config.SecretToken)
+	// only one request gets to revoke the token, a second one racing it counts as reuse
+	revokedCount, err := config.DbQueries.RevokeActiveRefreshToken(r.Context(), foundRefreshToken.ID)
 	if err != nil {
-		log.Printf("error creating JWT token: %s", err)
-		w.WriteHeader(401)
+		slog.ErrorContext(r.Context(), "error revoking rotated refresh token", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if revokedCount <= 0 {
+		config.revokeReusedFamily(w, r, foundRefreshToken)
+		return
+	}
+
+	newRefreshToken, err := config.issueRefreshToken(r, foundUser.ID, foundRefreshToken.FamilyID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error creating refresh token", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	newJWTToken, err := auth.MakeJWT(foundUser.ID, foundUser.Role, foundUser.TokenVersion, config.Keys, config.AccessTokenLifetime)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error creating JWT token", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
 	output := auth.NewAccessToken{
-		Token: newJWTToken,
+		Token:        newJWTToken,
+		RefreshToken: newRefreshToken.Token,
 	}
 
 	data, err := json.Marshal(output)
 	if err != nil {
-		log.Printf("error marshalling newly created access token: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error marshalling newly created access token", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	w.Write(data)
 	w.WriteHeader(200)
-
+	w.Write(data)
 }
 
 func (config *ApiConfig) RevokeRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 	// get the token provided
 	refreshToken, err := auth.GetTokenBearer(r.Header)
 	if err != nil {
-		log.Printf("error getting token bearer info: %s", err)
-		w.WriteHeader(500)
+		slog.WarnContext(r.Context(), "error getting token bearer info", "err", err)
+		writeAuthError(w, r, err)
 		return
 	}
 
 	// check if token exists
-	foundRefreshToken, err := config.DbQueries.GetUserViaRefreshToken(r.Context(), refreshToken)
+	foundRefreshToken, err := config.DbQueries.GetRefreshTokenViaHash(r.Context(), auth.HashRefreshToken(refreshToken))
 	if err != nil {
-		log.Printf("error token is not valid: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("error: token is not valid"))
+		slog.WarnContext(r.Context(), "error token is not valid", "err", err)
+		writeAuthError(w, r, err)
 		return
 	}
 
@@ -503,11 +933,11 @@ func (config *ApiConfig) RevokeRefreshTokenHandler(w http.ResponseWriter, r *htt
 			Valid: true,
 		},
 		UpdatedAt: time.Now(),
-		Token:     foundRefreshToken.Token,
+		ID:        foundRefreshToken.ID,
 	})
 	if err != nil {
-		log.Printf("error revoking refresh token provided: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error revoking refresh token provided", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -517,34 +947,34 @@ func (config *ApiConfig) RevokeRefreshTokenHandler(w http.ResponseWriter, r *htt
 func (config *ApiConfig) UpdateCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// grab user access token
-	accessToken, err := auth.GetTokenBearer(r.Header)
+	userID, err := config.getAuthenticatedUserID(r)
 	if err != nil {
-		log.Printf("error getting token bearer info: %s", err)
-		w.WriteHeader(401)
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(accessToken, config.SecretToken)
-	if err != nil {
-		log.Printf("error token not valid: %s", err)
-		w.WriteHeader(401)
+	params := chirp.UserCredentials{}
+	// correct info will be stored in params
+	if !decodeJSON(w, r, &params) {
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := chirp.UserCredentials{}
-	// correct info will be stored in params
-	err = decoder.Decode(&params)
+	err = mailer.ValidateEmail(params.Email)
 	if err != nil {
-		log.Printf("error decoding parameters: %s", err)
-		w.WriteHeader(500)
+		writeValidationError(w, r, "email", err.Error())
 		return
 	}
 
-	newPasswordHash, err := auth.HashPassword(params.Password)
+	newPasswordHash, ok := config.hashPassword(w, r, params.Password, params.Email)
+	if !ok {
+		return
+	}
+
+	previousUser, err := config.DbQueries.GetUserViaID(r.Context(), userID)
 	if err != nil {
-		log.Printf("error hashing password: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error finding user to update", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -553,66 +983,96 @@ func (config *ApiConfig) UpdateCredentialsHandler(w http.ResponseWriter, r *http
 		HashedPassword: newPasswordHash,
 		ID:             userID,
 	})
+	if database.IsUniqueViolation(err) {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "Email is already taken")
+		return
+	}
 	if err != nil {
-		log.Printf("error updating user email and password: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error updating user email and password", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
+	// a new address has to be verified again
+	if previousUser.Email != params.Email {
+		updatedUser := previousUser
+		updatedUser.Email = params.Email
+		err = config.sendVerificationEmail(r.Context(), updatedUser)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error sending verification email", "user_id", userID, "err", err)
+		}
+	}
+
 	newUserCredentials := chirp.UserCredentials{
 		Email: params.Email,
 	}
 
 	data, err := json.Marshal(newUserCredentials)
 	if err != nil {
-		log.Printf("error marshalling found chirp: %s", err)
-		w.WriteHeader(500)
+		slog.ErrorContext(r.Context(), "error marshalling updated credentials", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	w.Write(data)
 	w.WriteHeader(200)
+	w.Write(data)
 }
 
 func (config *ApiConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
 	// grab user access token
-	var err error
-
-	accessToken, err := auth.GetTokenBearer(r.Header)
+	userID, err := config.getAuthenticatedUserID(r)
 	if err != nil {
-		log.Printf("error getting token bearer info: %s", err)
-		w.WriteHeader(401)
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
 		return
 	}
 
-	chirpId := r.PathValue("chirp_id")
+	chirpUUID, ok := parseIDParam(w, r, "chirp_id")
+	if !ok {
+		return
+	}
 
-	chirpUUID := uuid.Must(uuid.MustParse(chirpId), err)
 	foundChirp, err := config.DbQueries.GetChirpViaID(r.Context(), chirpUUID)
-	if err != nil {
-		log.Printf("error chirp does not exist: %s", err)
-		w.WriteHeader(404)
+	if errors.Is(err, sql.ErrNoRows) || foundChirp.DeletedAt.Valid {
+		writeNotFound(w, r, "Chirp does not exist")
 		return
 	}
-
-	userID, err := auth.ValidateJWT(accessToken, config.SecretToken)
 	if err != nil {
-		log.Printf("error token not valid: %s", err)
-		w.WriteHeader(401)
+		slog.ErrorContext(r.Context(), "error finding chirp", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
 	// if the user is not the author of the chirp
 	if foundChirp.UserID != userID {
-		log.Printf("error forbidden access")
-		w.WriteHeader(403)
+		slog.WarnContext(r.Context(), "error forbidden access")
+		writeForbidden(w, r, "Only the author can delete a chirp")
 		return
 	}
 
-	err = config.DbQueries.DeleteChirpPerm(r.Context(), foundChirp.ID)
-	if foundChirp.UserID != userID {
-		log.Printf("error deleting chirp from db: %s", err)
-		w.WriteHeader(500)
+	replyCount, err := config.DbQueries.CountChirpReplies(r.Context(), uuid.NullUUID{UUID: foundChirp.ID, Valid: true})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error counting chirp replies", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if replyCount > 0 {
+		// keep a tombstone so the replies still have a parent in their thread
+		err = config.DbQueries.TombstoneChirp(r.Context(), database.TombstoneChirpParams{
+			DeletedAt: sql.NullTime{
+				Time:  time.Now(),
+				Valid: true,
+			},
+			UpdatedAt: time.Now(),
+			ID:        foundChirp.ID,
+		})
+	} else {
+		err = config.DbQueries.DeleteChirpPerm(r.Context(), foundChirp.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error deleting chirp from db", "err", err)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -624,52 +1084,36 @@ func (config *ApiConfig) UpgradeUserHandler(w http.ResponseWriter, r *http.Reque
 
 	providedApiKey, err := auth.GetAPIKey(r.Header)
 	if err != nil {
-		log.Printf("error getting api key info: %s", err)
-		w.WriteHeader(401)
+		slog.WarnContext(r.Context(), "error getting api key info", "err", err)
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "API key is missing")
 		return
 	}
 
 	if providedApiKey != config.PolkaKey {
-		log.Printf("error invalid key: %s", err)
-		w.WriteHeader(401)
+		slog.WarnContext(r.Context(), "error invalid key")
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "API key is not valid")
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	params := auth.ChirpyEvent{}
 	// correct info will be stored in params
-	err = decoder.Decode(&params)
-	if err != nil {
-		log.Printf("error decoding parameters: %s", err)
-		w.WriteHeader(500)
+	if !decodeJSON(w, r, &params) {
 		return
 	}
 
 	// we only want the upgrade events. Everything else is ignored
 	if params.Event != events.UpgradeUserEvent {
-		log.Printf("error unrecognized event: %s", err)
+		slog.InfoContext(r.Context(), "ignoring unrecognized event", "event", params.Event)
 		w.WriteHeader(204)
 		return
 	}
 
 	err = config.DbQueries.UpgradeToChirpyRedViaID(r.Context(), params.Data.ID)
 	if err != nil {
-		log.Printf("error upgrading user to chirpy red: %s", err)
-		w.WriteHeader(404)
-		return
-	}
-
-	userInfo := chirp.User{
-		IsChirpyRed: true,
-	}
-
-	data, err := json.Marshal(userInfo)
-	if err != nil {
-		log.Printf("error marshalling newly created access token: %s", err)
-		w.WriteHeader(500)
+		slog.WarnContext(r.Context(), "error upgrading user to chirpy red", "err", err)
+		writeNotFound(w, r, "User does not exist")
 		return
 	}
 
 	w.WriteHeader(204)
-	w.Write(data)
 }
@@ -0,0 +1,1895 @@
+package config_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/config"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/logging"
+	"github.com/CzarRamos/chirpy/internal/mailer"
+	"github.com/CzarRamos/chirpy/internal/metrics"
+	"github.com/CzarRamos/chirpy/internal/pagination"
+	"github.com/CzarRamos/chirpy/internal/passwordpolicy"
+	"github.com/CzarRamos/chirpy/internal/ratelimit"
+	"github.com/CzarRamos/chirpy/internal/settings"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSecretToken = "this-is-my-secret-token"
+
+func newTestKeyRing() *auth.KeyRing {
+	keys := auth.NewKeyRing(auth.DEFAULT_ISSUER)
+	keys.Add(auth.NewHMACKey(auth.HMAC_KEY_ID, []byte(testSecretToken)))
+	return keys
+}
+
+func newTestServer() *http.ServeMux {
+	serverMux, _ := newTestServerWithStore()
+	return serverMux
+}
+
+// newTestServerWithStore also returns the store, for tests that need to set things up behind the API's back
+func newTestServerWithStore() (*http.ServeMux, *database.MemoryStore) {
+	store := database.NewMemoryStore()
+	return newTestRoutes(newTestConfig(store)), store
+}
+
+func newTestConfig(store *database.MemoryStore) *config.ApiConfig {
+	userConfig := &config.ApiConfig{
+		DbQueries:   store,
+		Keys:        newTestKeyRing(),
+		PurposeKeys: auth.NewPurposeKeyRing(auth.DEFAULT_ISSUER, auth.DerivePurposeSecret([]byte(testSecretToken))),
+		PolkaKey:    "test-polka-key",
+
+		AccessTokenLifetime:  time.Hour,
+		RefreshTokenLifetime: time.Hour,
+		PasswordHasher:       auth.BcryptHasher{Cost: bcrypt.MinCost},
+		PasswordPolicy:       &passwordpolicy.Policy{MinLength: passwordpolicy.DEFAULT_MIN_LENGTH},
+	}
+	userConfig.WordFilter, userConfig.Moderator, _ = config.LoadModeration(context.Background(), userConfig.DbQueries, "", "")
+	return userConfig
+}
+
+// newTestRoutes wires the handlers up the way main does
+func newTestRoutes(userConfig *config.ApiConfig) *http.ServeMux {
+	serverMux := http.NewServeMux()
+	serverMux.Handle("POST /api/users", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.CreateNewUserHandler)))
+	serverMux.Handle("PUT /api/users", userConfig.MiddlewareRequireAuth(userConfig.UpdateCredentialsHandler))
+	serverMux.HandleFunc("POST /api/users/verify", userConfig.VerifyEmailHandler)
+	serverMux.Handle("POST /api/users/verify/resend", userConfig.MiddlewareRequireAuth(userConfig.ResendVerificationHandler))
+	serverMux.Handle("POST /api/password/forgot", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.ForgotPasswordHandler)))
+	serverMux.Handle("POST /api/password/reset", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.ResetPasswordHandler)))
+	serverMux.Handle("POST /api/login/mfa", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.LoginMFAHandler)))
+	serverMux.Handle("POST /api/mfa/totp/enroll", userConfig.MiddlewareRequireAuth(userConfig.EnrollTOTPHandler))
+	serverMux.Handle("POST /api/mfa/totp/confirm", userConfig.MiddlewareRequireAuth(userConfig.ConfirmTOTPHandler))
+	serverMux.Handle("POST /api/mfa/totp/disable", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, userConfig.MiddlewareRequireAuth(userConfig.DisableTOTPHandler)))
+	serverMux.Handle("POST /api/mfa/recovery-codes/regenerate", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, userConfig.MiddlewareRequireAuth(userConfig.RegenerateRecoveryCodesHandler)))
+	serverMux.HandleFunc("GET /api/chirps", userConfig.GetAllChirpsHandler)
+	serverMux.HandleFunc("GET /api/chirps/{chirp_id}", userConfig.GetChirpViaIdHandler)
+	serverMux.Handle("DELETE /api/chirps/{chirp_id}", userConfig.MiddlewareRequireAuth(userConfig.DeleteChirpHandler))
+	serverMux.Handle("POST /api/chirps", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_CHIRPS, userConfig.MiddlewareRequireAuth(userConfig.NewChirpHandler)))
+	serverMux.Handle("POST /api/login", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.LoginHandler)))
+	serverMux.HandleFunc("POST /api/refresh", userConfig.RefreshHandler)
+	serverMux.HandleFunc("POST /api/revoke", userConfig.RevokeRefreshTokenHandler)
+	serverMux.Handle("GET /api/sessions", userConfig.MiddlewareRequireAuth(userConfig.ListSessionsHandler))
+	serverMux.Handle("DELETE /api/sessions/{session_id}", userConfig.MiddlewareRequireAuth(userConfig.RevokeSessionHandler))
+	serverMux.Handle("POST /api/logout", userConfig.MiddlewareRequireAuth(userConfig.LogoutHandler))
+	serverMux.Handle("POST /api/logout-all", userConfig.MiddlewareRequireAuth(userConfig.LogoutAllHandler))
+	serverMux.Handle("POST /api/users/{user_id}/follow", userConfig.MiddlewareRequireAuth(userConfig.FollowUserHandler))
+	serverMux.Handle("DELETE /api/users/{user_id}/follow", userConfig.MiddlewareRequireAuth(userConfig.UnfollowUserHandler))
+	serverMux.HandleFunc("GET /api/users/{user_id}/followers", userConfig.GetFollowersHandler)
+	serverMux.Handle("GET /api/timeline", userConfig.MiddlewareRequireAuth(userConfig.TimelineHandler))
+	serverMux.HandleFunc("GET /api/chirps/{chirp_id}/thread", userConfig.GetChirpThreadHandler)
+	serverMux.Handle("POST /api/chirps/{chirp_id}/like", userConfig.MiddlewareRequireAuth(userConfig.LikeChirpHandler))
+	serverMux.Handle("DELETE /api/chirps/{chirp_id}/like", userConfig.MiddlewareRequireAuth(userConfig.UnlikeChirpHandler))
+	serverMux.Handle("POST /api/chirps/{chirp_id}/rechirp", userConfig.MiddlewareRequireAuth(userConfig.RechirpHandler))
+	serverMux.Handle("GET /api/chirps/search", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_SEARCH, http.HandlerFunc(userConfig.SearchChirpsHandler)))
+	serverMux.Handle("POST /admin/reset", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.HandlerResetMetrics))
+	serverMux.Handle("GET /admin/users", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.ListUsersHandler))
+	serverMux.Handle("GET /admin/users/{user_id}", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.GetUserDetailHandler))
+	serverMux.Handle("DELETE /admin/users/{user_id}", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.DeleteUserHandler))
+	serverMux.Handle("PUT /admin/users/{user_id}/role", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.SetUserRoleHandler))
+	serverMux.Handle("POST /admin/users/{user_id}/suspend", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.SuspendUserHandler))
+	serverMux.Handle("POST /admin/users/{user_id}/unsuspend", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.UnsuspendUserHandler))
+	serverMux.Handle("POST /admin/users/{user_id}/force-password-reset", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.ForcePasswordResetHandler))
+	serverMux.Handle("GET /admin/lockouts", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.ListLockoutsHandler))
+	serverMux.Handle("DELETE /admin/lockouts/{email}", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.UnlockAccountHandler))
+	serverMux.Handle("PUT /admin/moderation/words/{word}", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.SetBannedWordHandler))
+	serverMux.Handle("DELETE /admin/moderation/words/{word}", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.DeleteBannedWordHandler))
+	serverMux.Handle("GET /admin/moderation/flags", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.ListModerationFlagsHandler))
+	return serverMux
+}
+
+func doRequest(t *testing.T, handler http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			t.Fatalf(`error encoding request body: %v`, err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &reqBody)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// signUpAndLogin registers a user and returns their logged in info
+func signUpAndLogin(t *testing.T, handler http.Handler, email string) chirp.User {
+	t.Helper()
+	credentials := chirp.UserCredentials{
+		Email:    email,
+		Password: "my-super-secure-password",
+	}
+
+	res := doRequest(t, handler, "POST", "/api/users", "", credentials)
+	if res.Code != 201 {
+		t.Fatalf(`creating user returned %d, want 201`, res.Code)
+	}
+
+	res = doRequest(t, handler, "POST", "/api/login", "", credentials)
+	if res.Code != 200 {
+		t.Fatalf(`login returned %d, want 200`, res.Code)
+	}
+
+	loggedInUser := chirp.User{}
+	err := json.Unmarshal(res.Body.Bytes(), &loggedInUser)
+	if err != nil {
+		t.Fatalf(`error decoding login response: %v`, err)
+	}
+	return loggedInUser
+}
+
+// signUpWithRole registers a user, gives them a role and logs them in so their token carries it
+func signUpWithRole(t *testing.T, handler http.Handler, store database.Store, email, role string) chirp.User {
+	t.Helper()
+	newUser := signUpAndLogin(t, handler, email)
+	_, err := store.SetUserRole(context.Background(), database.SetUserRoleParams{Role: role, ID: newUser.ID})
+	if err != nil {
+		t.Fatalf(`error setting role: %v`, err)
+	}
+
+	loggedInUser := logIn(t, handler, email)
+	if loggedInUser.Role != role {
+		t.Fatalf(`login returned the %q role, want %q`, loggedInUser.Role, role)
+	}
+	return loggedInUser
+}
+
+// logIn logs in a user made by signUpAndLogin again, picking up any changes to their role
+func logIn(t *testing.T, handler http.Handler, email string) chirp.User {
+	t.Helper()
+	res := doRequest(t, handler, "POST", "/api/login", "", chirp.UserCredentials{
+		Email:    email,
+		Password: "my-super-secure-password",
+	})
+	if res.Code != 200 {
+		t.Fatalf(`login returned %d, want 200`, res.Code)
+	}
+
+	loggedInUser := chirp.User{}
+	err := json.Unmarshal(res.Body.Bytes(), &loggedInUser)
+	if err != nil {
+		t.Fatalf(`error decoding login response: %v`, err)
+	}
+	return loggedInUser
+}
+
+func postChirp(t *testing.T, handler http.Handler, token, body string) chirp.ShortChirp {
+	t.Helper()
+	return postReply(t, handler, token, body, nil)
+}
+
+func postReply(t *testing.T, handler http.Handler, token, body string, inReplyTo *uuid.UUID) chirp.ShortChirp {
+	t.Helper()
+	res := doRequest(t, handler, "POST", "/api/chirps", token, chirp.ShortChirp{Message: body, InReplyTo: inReplyTo})
+	if res.Code != 201 {
+		t.Fatalf(`creating chirp returned %d, want 201`, res.Code)
+	}
+
+	newChirp := chirp.ShortChirp{}
+	err := json.Unmarshal(res.Body.Bytes(), &newChirp)
+	if err != nil {
+		t.Fatalf(`error decoding chirp response: %v`, err)
+	}
+	return newChirp
+}
+
+func TestChirpLifecycle(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+
+	newChirp := postChirp(t, server, walt.AccessToken, "I am the one who knocks")
+
+	res := doRequest(t, server, "GET", "/api/chirps/"+newChirp.ID.String(), "", nil)
+	if res.Code != 200 {
+		t.Errorf(`getting chirp returned %d, want 200`, res.Code)
+		return
+	}
+
+	// only the author can delete
+	res = doRequest(t, server, "DELETE", "/api/chirps/"+newChirp.ID.String(), jesse.AccessToken, nil)
+	if res.Code != 403 {
+		t.Errorf(`deleting someone else's chirp returned %d, want 403`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "DELETE", "/api/chirps/"+newChirp.ID.String(), walt.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`deleting own chirp returned %d, want 204`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/"+newChirp.ID.String(), "", nil)
+	if res.Code != 404 {
+		t.Errorf(`getting deleted chirp returned %d, want 404`, res.Code)
+	}
+}
+
+func TestDuplicateEmailRejected(t *testing.T) {
+	server := newTestServer()
+	signUpAndLogin(t, server, "walt@breakingbad.com")
+
+	res := doRequest(t, server, "POST", "/api/users", "", chirp.UserCredentials{
+		Email:    "walt@breakingbad.com",
+		Password: "another-password",
+	})
+	if res.Code != 409 {
+		t.Errorf(`creating a user with a taken email returned %d, want 409`, res.Code)
+	}
+}
+
+func TestRevokedRefreshToken(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+
+	res := doRequest(t, server, "POST", "/api/revoke", walt.RefreshToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`revoke returned %d, want 204`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "POST", "/api/refresh", walt.RefreshToken, nil)
+	if res.Code != 401 {
+		t.Errorf(`refresh with a revoked token returned %d, want 401`, res.Code)
+	}
+}
+
+// refresh returns the new tokens, failing the test unless it worked
+func refresh(t *testing.T, handler http.Handler, refreshToken string) auth.NewAccessToken {
+	t.Helper()
+	res := doRequest(t, handler, "POST", "/api/refresh", refreshToken, nil)
+	if res.Code != 200 {
+		t.Fatalf(`refresh returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+
+	tokens := auth.NewAccessToken{}
+	err := json.Unmarshal(res.Body.Bytes(), &tokens)
+	if err != nil {
+		t.Fatalf(`error decoding refresh response: %v`, err)
+	}
+	return tokens
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	server, store := newTestServerWithStore()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	otherDevice := logIn(t, server, "walt@breakingbad.com")
+
+	// only the hash is stored
+	_, err := store.GetRefreshTokenViaHash(context.Background(), walt.RefreshToken)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf(`refresh tokens should not be stored as is: %v`, err)
+		return
+	}
+
+	rotated := refresh(t, server, walt.RefreshToken)
+	if len(rotated.Token) <= 0 || len(rotated.RefreshToken) <= 0 || rotated.RefreshToken == walt.RefreshToken {
+		t.Errorf(`refresh should hand out a new refresh token: %+v`, rotated)
+		return
+	}
+	rotated = refresh(t, server, rotated.RefreshToken)
+
+	// the first token shows up again, so someone copied it and the whole family goes
+	res := doRequest(t, server, "POST", "/api/refresh", walt.RefreshToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	res = doRequest(t, server, "POST", "/api/refresh", rotated.RefreshToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+
+	// a login on another device is its own family
+	refresh(t, server, otherDevice.RefreshToken)
+}
+
+func listSessions(t *testing.T, handler http.Handler, accessToken string) []chirp.Session {
+	t.Helper()
+	res := doRequest(t, handler, "GET", "/api/sessions", accessToken, nil)
+	if res.Code != 200 {
+		t.Fatalf(`listing sessions returned %d, want 200`, res.Code)
+	}
+
+	sessions := []chirp.Session{}
+	err := json.Unmarshal(res.Body.Bytes(), &sessions)
+	if err != nil {
+		t.Fatalf(`error decoding sessions: %v`, err)
+	}
+	return sessions
+}
+
+func TestSessions(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"walt@breakingbad.com","password":"my-super-secure-password"}`))
+	req.Header.Set("User-Agent", "RV Lab/1.0")
+	req.RemoteAddr = "203.0.113.7:41000"
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if res.Code != 200 {
+		t.Fatalf(`login returned %d, want 200`, res.Code)
+	}
+
+	sessions := listSessions(t, server, walt.AccessToken)
+	if len(sessions) != 2 || sessions[0].UserAgent != "RV Lab/1.0" || sessions[0].IP != "203.0.113.7" {
+		t.Errorf(`walt should have two sessions, the newest from the RV: %+v`, sessions)
+		return
+	}
+	oldSession := sessions[1]
+
+	// rotating the refresh token keeps the session
+	refresh(t, server, walt.RefreshToken)
+	sessions = listSessions(t, server, walt.AccessToken)
+	if len(sessions) != 2 || sessions[0].ID != oldSession.ID {
+		t.Errorf(`refreshing should keep the session and move it to the front: %+v`, sessions)
+		return
+	}
+
+	res = doRequest(t, server, "DELETE", "/api/sessions/"+oldSession.ID.String(), jesse.AccessToken, nil)
+	decodeError(t, res, 404, config.ERROR_CODE_NOT_FOUND)
+
+	res = doRequest(t, server, "DELETE", "/api/sessions/"+oldSession.ID.String(), walt.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`revoking a session returned %d, want 204`, res.Code)
+		return
+	}
+	sessions = listSessions(t, server, walt.AccessToken)
+	if len(sessions) != 1 || sessions[0].ID == oldSession.ID {
+		t.Errorf(`the revoked session should be gone: %+v`, sessions)
+		return
+	}
+
+	res = doRequest(t, server, "POST", "/api/logout-all", walt.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`logout-all returned %d, want 204`, res.Code)
+		return
+	}
+	res = doRequest(t, server, "GET", "/api/sessions", walt.AccessToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_TOKEN_REVOKED)
+
+	walt = logIn(t, server, "walt@breakingbad.com")
+	if sessions = listSessions(t, server, walt.AccessToken); len(sessions) != 1 {
+		t.Errorf(`logout-all should end every earlier session: %+v`, sessions)
+	}
+	if sessions = listSessions(t, server, jesse.AccessToken); len(sessions) != 1 {
+		t.Errorf(`logout-all should leave other users alone: %+v`, sessions)
+	}
+}
+
+func TestAccessTokenRevocation(t *testing.T) {
+	server, store := newTestServerWithStore()
+	laptop := signUpAndLogin(t, server, "walt@breakingbad.com")
+	phone := logIn(t, server, "walt@breakingbad.com")
+
+	// logging out kills only the token used
+	res := doRequest(t, server, "POST", "/api/logout", laptop.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`logout returned %d, want 204`, res.Code)
+		return
+	}
+	res = doRequest(t, server, "GET", "/api/timeline", laptop.AccessToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_TOKEN_REVOKED)
+	res = doRequest(t, server, "GET", "/api/timeline", phone.AccessToken, nil)
+	if res.Code != 200 {
+		t.Errorf(`the other device should still be logged in, got %d`, res.Code)
+		return
+	}
+
+	// a password change bumps the token version, killing every access token
+	res = doRequest(t, server, "PUT", "/api/users", phone.AccessToken, chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "say-my-name-heisenberg"})
+	if res.Code != 200 {
+		t.Errorf(`password change returned %d, want 200`, res.Code)
+		return
+	}
+	res = doRequest(t, server, "GET", "/api/timeline", phone.AccessToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_TOKEN_REVOKED)
+
+	// tokens made after the bump carry the new version
+	refreshed := refresh(t, server, phone.RefreshToken)
+	res = doRequest(t, server, "GET", "/api/timeline", refreshed.Token, nil)
+	if res.Code != 200 {
+		t.Errorf(`a refreshed token should work after the password change, got %d`, res.Code)
+		return
+	}
+
+	deletedCount, err := store.DeleteExpiredDeniedAccessTokens(context.Background())
+	if err != nil || deletedCount != 0 {
+		t.Errorf(`cleanup should keep denylisted tokens that haven't expired, deleted %d: %v`, deletedCount, err)
+	}
+}
+
+func getChirpPage(t *testing.T, handler http.Handler, path string) (chirp.ChirpPage, *httptest.ResponseRecorder) {
+	t.Helper()
+	res := doRequest(t, handler, "GET", path, "", nil)
+	if res.Code != 200 {
+		t.Fatalf(`GET %s returned %d, want 200`, path, res.Code)
+	}
+
+	page := chirp.ChirpPage{}
+	err := json.Unmarshal(res.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatalf(`error decoding chirp page: %v`, err)
+	}
+	return page, res
+}
+
+func TestChirpPagination(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+
+	var bodies []string
+	for idx := range 5 {
+		body := fmt.Sprintf("chirp number %d", idx)
+		postChirp(t, server, walt.AccessToken, body)
+		bodies = append(bodies, body)
+	}
+
+	// newest first, two at a time
+	page, res := getChirpPage(t, server, "/api/chirps?sort=desc&limit=2")
+	if len(page.Chirps) != 2 || page.Chirps[0].Body != bodies[4] || page.Chirps[1].Body != bodies[3] {
+		t.Errorf(`first page is wrong: %+v`, page.Chirps)
+		return
+	}
+	if len(page.NextCursor) <= 0 || len(page.PrevCursor) > 0 {
+		t.Errorf(`first page should only have a next cursor: next %q prev %q`, page.NextCursor, page.PrevCursor)
+		return
+	}
+	if !strings.Contains(res.Header().Get("Link"), `rel="next"`) {
+		t.Errorf(`first page is missing a next Link header: %q`, res.Header().Get("Link"))
+		return
+	}
+
+	page, _ = getChirpPage(t, server, "/api/chirps?sort=desc&limit=2&cursor="+page.NextCursor)
+	if len(page.Chirps) != 2 || page.Chirps[0].Body != bodies[2] || page.Chirps[1].Body != bodies[1] {
+		t.Errorf(`second page is wrong: %+v`, page.Chirps)
+		return
+	}
+
+	lastPage, _ := getChirpPage(t, server, "/api/chirps?sort=desc&limit=2&cursor="+page.NextCursor)
+	if len(lastPage.Chirps) != 1 || lastPage.Chirps[0].Body != bodies[0] || len(lastPage.NextCursor) > 0 {
+		t.Errorf(`last page is wrong: %+v`, lastPage)
+		return
+	}
+
+	// walking back lands on the second page again
+	backPage, _ := getChirpPage(t, server, "/api/chirps?sort=desc&limit=2&cursor="+lastPage.PrevCursor)
+	if len(backPage.Chirps) != 2 || backPage.Chirps[0].Body != bodies[2] || backPage.Chirps[1].Body != bodies[1] {
+		t.Errorf(`going back a page is wrong: %+v`, backPage.Chirps)
+		return
+	}
+
+	// oldest first by default
+	page, _ = getChirpPage(t, server, "/api/chirps?limit=3")
+	if len(page.Chirps) != 3 || page.Chirps[0].Body != bodies[0] || page.Chirps[2].Body != bodies[2] {
+		t.Errorf(`ascending page is wrong: %+v`, page.Chirps)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps?cursor=not-a-cursor", "", nil)
+	if res.Code != 400 {
+		t.Errorf(`an invalid cursor returned %d, want 400`, res.Code)
+	}
+}
+
+func TestFollowAndTimeline(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+	hank := signUpAndLogin(t, server, "hank@breakingbad.com")
+
+	postChirp(t, server, walt.AccessToken, "say my name")
+	postChirp(t, server, hank.AccessToken, "minerals, not rocks")
+	postChirp(t, server, walt.AccessToken, "I am the danger")
+
+	res := doRequest(t, server, "POST", "/api/users/"+walt.ID.String()+"/follow", jesse.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`follow returned %d, want 204`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "POST", "/api/users/"+jesse.ID.String()+"/follow", jesse.AccessToken, nil)
+	if res.Code != 400 {
+		t.Errorf(`following yourself returned %d, want 400`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/timeline", "", nil)
+	if res.Code != 401 {
+		t.Errorf(`timeline without a token returned %d, want 401`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/timeline", jesse.AccessToken, nil)
+	if res.Code != 200 {
+		t.Errorf(`timeline returned %d, want 200`, res.Code)
+		return
+	}
+	timeline := chirp.ChirpPage{}
+	json.Unmarshal(res.Body.Bytes(), &timeline)
+	if len(timeline.Chirps) != 2 || timeline.Chirps[0].Body != "I am the danger" || timeline.Chirps[1].Body != "say my name" {
+		t.Errorf(`timeline should only show walt's chirps newest first: %+v`, timeline.Chirps)
+		return
+	}
+
+	followers := chirp.FollowPage{}
+	res = doRequest(t, server, "GET", "/api/users/"+walt.ID.String()+"/followers", "", nil)
+	json.Unmarshal(res.Body.Bytes(), &followers)
+	if len(followers.Users) != 1 || followers.Users[0].UserID != jesse.ID {
+		t.Errorf(`walt's followers are wrong: %+v`, followers.Users)
+		return
+	}
+
+	res = doRequest(t, server, "DELETE", "/api/users/"+walt.ID.String()+"/follow", jesse.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`unfollow returned %d, want 204`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/timeline", jesse.AccessToken, nil)
+	timeline = chirp.ChirpPage{}
+	json.Unmarshal(res.Body.Bytes(), &timeline)
+	if len(timeline.Chirps) != 0 {
+		t.Errorf(`timeline should be empty after unfollowing: %+v`, timeline.Chirps)
+	}
+}
+
+func TestChirpThreadWithTombstone(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+
+	root := postChirp(t, server, walt.AccessToken, "we need to cook")
+	reply := postReply(t, server, jesse.AccessToken, "yeah science", &root.ID)
+	nested := postReply(t, server, walt.AccessToken, "chemistry is the study of change", &reply.ID)
+	secondReply := postReply(t, server, jesse.AccessToken, "wait, where", &root.ID)
+
+	// the root has replies so deleting it leaves a tombstone behind
+	res := doRequest(t, server, "DELETE", "/api/chirps/"+root.ID.String(), walt.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`deleting the root returned %d, want 204`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/"+nested.ID.String()+"/thread", "", nil)
+	if res.Code != 200 {
+		t.Errorf(`thread returned %d, want 200`, res.Code)
+		return
+	}
+	thread := chirp.ChirpThread{}
+	json.Unmarshal(res.Body.Bytes(), &thread)
+	if len(thread.Ancestors) != 2 || thread.Ancestors[0].ID != root.ID || thread.Ancestors[1].ID != reply.ID {
+		t.Errorf(`thread ancestors are wrong: %+v`, thread.Ancestors)
+		return
+	}
+	if !thread.Ancestors[0].Deleted || len(thread.Ancestors[0].Body) > 0 {
+		t.Errorf(`deleted root should be a tombstone: %+v`, thread.Ancestors[0])
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/"+root.ID.String()+"/thread", "", nil)
+	thread = chirp.ChirpThread{}
+	json.Unmarshal(res.Body.Bytes(), &thread)
+	replies := thread.Chirp.Replies
+	if len(replies) != 2 || replies[0].ID != reply.ID || replies[1].ID != secondReply.ID {
+		t.Errorf(`root replies are wrong: %+v`, replies)
+		return
+	}
+	if len(replies[0].Replies) != 1 || replies[0].Replies[0].ID != nested.ID {
+		t.Errorf(`nested replies are wrong: %+v`, replies[0].Replies)
+		return
+	}
+
+	// tombstones stay out of the feed
+	page, _ := getChirpPage(t, server, "/api/chirps")
+	if len(page.Chirps) != 3 {
+		t.Errorf(`feed should only have the 3 live chirps, got %d`, len(page.Chirps))
+		return
+	}
+
+	res = doRequest(t, server, "POST", "/api/chirps", jesse.AccessToken, chirp.ShortChirp{Message: "hello?", InReplyTo: &root.ID})
+	if res.Code != 400 {
+		t.Errorf(`replying to a deleted chirp returned %d, want 400`, res.Code)
+	}
+}
+
+// brokenChirpLookupStore fails every chirp lookup by ID
+type brokenChirpLookupStore struct {
+	*database.MemoryStore
+}
+
+func (store brokenChirpLookupStore) GetChirpViaID(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	return database.Chirp{}, errors.New("database is down")
+}
+
+func TestReplyParentLookupFailure(t *testing.T) {
+	userConfig := newTestConfig(database.NewMemoryStore())
+	userConfig.DbQueries = brokenChirpLookupStore{userConfig.DbQueries.(*database.MemoryStore)}
+	server := newTestRoutes(userConfig)
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	root := postChirp(t, server, walt.AccessToken, "say my name")
+
+	// a database failure isn't the client's fault
+	res := doRequest(t, server, "POST", "/api/chirps", walt.AccessToken, chirp.ShortChirp{Message: "heisenberg", InReplyTo: &root.ID})
+	decodeError(t, res, 500, config.ERROR_CODE_INTERNAL)
+}
+
+func TestLikesAndRechirps(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+
+	newChirp := postChirp(t, server, walt.AccessToken, "say my name")
+	chirpPath := "/api/chirps/" + newChirp.ID.String()
+
+	for _, token := range []string{walt.AccessToken, jesse.AccessToken, jesse.AccessToken} {
+		res := doRequest(t, server, "POST", chirpPath+"/like", token, nil)
+		if res.Code != 204 {
+			t.Errorf(`like returned %d, want 204`, res.Code)
+			return
+		}
+	}
+	res := doRequest(t, server, "POST", chirpPath+"/rechirp", jesse.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`rechirp returned %d, want 204`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "GET", chirpPath, walt.AccessToken, nil)
+	found := chirp.DetailedChirp{}
+	json.Unmarshal(res.Body.Bytes(), &found)
+	if found.LikeCount != 2 || found.RechirpCount != 1 {
+		t.Errorf(`counts are wrong: likes %d rechirps %d`, found.LikeCount, found.RechirpCount)
+		return
+	}
+	if found.LikedByMe == nil || !*found.LikedByMe || found.RechirpedByMe == nil || *found.RechirpedByMe {
+		t.Errorf(`walt's flags are wrong: %+v`, found)
+		return
+	}
+
+	// anonymous callers only get counts
+	page, _ := getChirpPage(t, server, "/api/chirps")
+	if len(page.Chirps) != 1 || page.Chirps[0].LikeCount != 2 || page.Chirps[0].LikedByMe != nil {
+		t.Errorf(`anonymous feed engagement is wrong: %+v`, page.Chirps)
+		return
+	}
+
+	res = doRequest(t, server, "DELETE", chirpPath+"/like", jesse.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`unlike returned %d, want 204`, res.Code)
+		return
+	}
+	page, _ = getChirpPage(t, server, "/api/chirps")
+	if page.Chirps[0].LikeCount != 1 {
+		t.Errorf(`like count after unlike is %d, want 1`, page.Chirps[0].LikeCount)
+		return
+	}
+
+	res = doRequest(t, server, "POST", "/api/chirps/"+uuid.NewString()+"/like", jesse.AccessToken, nil)
+	if res.Code != 404 {
+		t.Errorf(`liking a missing chirp returned %d, want 404`, res.Code)
+	}
+}
+
+func TestSearchChirps(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+
+	postChirp(t, server, walt.AccessToken, "cooking in the desert")
+	postChirp(t, server, jesse.AccessToken, "cook cook cook, yo")
+	postChirp(t, server, walt.AccessToken, "say my name")
+
+	res := doRequest(t, server, "GET", "/api/chirps/search?q=cooks", "", nil)
+	if res.Code != 200 {
+		t.Errorf(`search returned %d, want 200`, res.Code)
+		return
+	}
+	results := chirp.SearchPage{}
+	json.Unmarshal(res.Body.Bytes(), &results)
+	if len(results.Results) != 2 || results.Results[0].UserID != jesse.ID {
+		t.Errorf(`search should find both cooking chirps, jesse's first: %+v`, results.Results)
+		return
+	}
+	if results.Results[1].Snippet != "<mark>cooking</mark> in the desert" {
+		t.Errorf(`snippet is wrong: %q`, results.Results[1].Snippet)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=cook&limit=1&author_id="+walt.ID.String(), "", nil)
+	results = chirp.SearchPage{}
+	json.Unmarshal(res.Body.Bytes(), &results)
+	if len(results.Results) != 1 || results.Results[0].UserID != walt.ID || len(results.NextCursor) > 0 {
+		t.Errorf(`author filtered search is wrong: %+v`, results)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=cook&limit=1", "", nil)
+	results = chirp.SearchPage{}
+	json.Unmarshal(res.Body.Bytes(), &results)
+	if len(results.NextCursor) <= 0 {
+		t.Errorf(`first page of search should have a next cursor`)
+		return
+	}
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=cook&limit=1&cursor="+results.NextCursor, "", nil)
+	results = chirp.SearchPage{}
+	json.Unmarshal(res.Body.Bytes(), &results)
+	if len(results.Results) != 1 || results.Results[0].UserID != walt.ID {
+		t.Errorf(`second page of search is wrong: %+v`, results)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=cook&until=2000-01-01T00:00:00Z", "", nil)
+	results = chirp.SearchPage{}
+	json.Unmarshal(res.Body.Bytes(), &results)
+	if len(results.Results) != 0 {
+		t.Errorf(`nothing was chirped before 2000: %+v`, results.Results)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=", "", nil)
+	if res.Code != 400 {
+		t.Errorf(`empty search returned %d, want 400`, res.Code)
+	}
+
+	// an offset past what Postgres takes is a bad cursor, not a failed query
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=cook&cursor="+pagination.EncodeOffsetCursor(pagination.MAX_OFFSET+1), "", nil)
+	if detail := decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED); len(detail.Details["cursor"]) <= 0 {
+		t.Errorf(`an oversized offset should be reported on the cursor field: %+v`, detail)
+	}
+}
+
+func TestModerationPipeline(t *testing.T) {
+	server, store := newTestServerWithStore()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	hank := signUpWithRole(t, server, store, "hank@dea.gov", auth.ROLE_MODERATOR)
+
+	masked := postChirp(t, server, walt.AccessToken, "What a Kerfuffle! Sharbert.")
+	if masked.Message != "What a ****! ****." {
+		t.Errorf(`default words should be masked: %q`, masked.Message)
+		return
+	}
+
+	res := doRequest(t, server, "PUT", "/admin/moderation/words/Heisenberg", hank.AccessToken, map[string]string{"action": "reject"})
+	if res.Code != 204 {
+		t.Errorf(`banning a word returned %d, want 204`, res.Code)
+		return
+	}
+	res = doRequest(t, server, "POST", "/api/chirps", walt.AccessToken, chirp.ShortChirp{Message: "I am HEISENBERG."})
+	if res.Code != 422 {
+		t.Errorf(`rejected chirp returned %d, want 422`, res.Code)
+		return
+	}
+
+	res = doRequest(t, server, "PUT", "/admin/moderation/words/heisenberg", hank.AccessToken, map[string]string{"action": "flag"})
+	if res.Code != 204 {
+		t.Errorf(`changing a word returned %d, want 204`, res.Code)
+		return
+	}
+	flagged := postChirp(t, server, walt.AccessToken, "I am heisenberg")
+	res = doRequest(t, server, "GET", "/admin/moderation/flags", hank.AccessToken, nil)
+	flags := []chirp.ModerationFlag{}
+	json.Unmarshal(res.Body.Bytes(), &flags)
+	if len(flags) != 1 || flags[0].ChirpID != flagged.ID {
+		t.Errorf(`flagged chirp should be waiting for review: %+v`, flags)
+		return
+	}
+	if flagged.Message != "I am heisenberg" {
+		t.Errorf(`a flagged word should not be masked, moderators need to see it: %q`, flagged.Message)
+		return
+	}
+
+	res = doRequest(t, server, "DELETE", "/admin/moderation/words/kerfuffle", hank.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`unbanning a word returned %d, want 204`, res.Code)
+		return
+	}
+	allowed := postChirp(t, server, walt.AccessToken, "kerfuffle")
+	if allowed.Message != "kerfuffle" {
+		t.Errorf(`unbanned word should not be masked: %q`, allowed.Message)
+		return
+	}
+
+	res = doRequest(t, server, "PUT", "/admin/moderation/words/two%20words", hank.AccessToken, map[string]string{"action": "mask"})
+	if res.Code != 400 {
+		t.Errorf(`banning two words returned %d, want 400`, res.Code)
+	}
+}
+
+// brokenWordStore fails every write to the banned words
+type brokenWordStore struct {
+	*database.MemoryStore
+}
+
+func (store brokenWordStore) UpsertBannedWord(ctx context.Context, arg database.UpsertBannedWordParams) error {
+	return errors.New("database is down")
+}
+
+func (store brokenWordStore) DeleteBannedWord(ctx context.Context, word string) error {
+	return errors.New("database is down")
+}
+
+func TestBannedWordsFollowTheDatabase(t *testing.T) {
+	userConfig := newTestConfig(database.NewMemoryStore())
+	store := brokenWordStore{userConfig.DbQueries.(*database.MemoryStore)}
+	userConfig.DbQueries = store
+	server := newTestRoutes(userConfig)
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	hank := signUpWithRole(t, server, store, "hank@dea.gov", auth.ROLE_MODERATOR)
+
+	// a word that wasn't saved isn't enforced either
+	res := doRequest(t, server, "PUT", "/admin/moderation/words/heisenberg", hank.AccessToken, map[string]string{"action": "reject"})
+	decodeError(t, res, 500, config.ERROR_CODE_INTERNAL)
+	if allowed := postChirp(t, server, walt.AccessToken, "I am heisenberg"); allowed.Message != "I am heisenberg" {
+		t.Errorf(`an unsaved ban should not apply: %q`, allowed.Message)
+	}
+
+	// and a word that wasn't deleted is still enforced
+	res = doRequest(t, server, "DELETE", "/admin/moderation/words/kerfuffle", hank.AccessToken, nil)
+	decodeError(t, res, 500, config.ERROR_CODE_INTERNAL)
+	if masked := postChirp(t, server, walt.AccessToken, "kerfuffle"); masked.Message != "****" {
+		t.Errorf(`a ban that wasn't deleted should still apply: %q`, masked.Message)
+	}
+}
+
+func TestRoleBasedAccess(t *testing.T) {
+	server, store := newTestServerWithStore()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	hank := signUpWithRole(t, server, store, "hank@dea.gov", auth.ROLE_MODERATOR)
+
+	res := doRequest(t, server, "GET", "/admin/moderation/flags", "", nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+
+	res = doRequest(t, server, "GET", "/admin/moderation/flags", walt.AccessToken, nil)
+	decodeError(t, res, 403, config.ERROR_CODE_FORBIDDEN)
+
+	res = doRequest(t, server, "GET", "/admin/moderation/flags", hank.AccessToken, nil)
+	if res.Code != 200 {
+		t.Errorf(`moderator listing flags returned %d, want 200`, res.Code)
+		return
+	}
+
+	// moderators can't hand out roles
+	res = doRequest(t, server, "PUT", "/admin/users/"+walt.ID.String()+"/role", hank.AccessToken, map[string]string{"role": "admin"})
+	decodeError(t, res, 403, config.ERROR_CODE_FORBIDDEN)
+
+	admin, err := config.PromoteFirstAdmin(context.Background(), store, "walt@breakingbad.com")
+	if err != nil || admin.Role != auth.ROLE_ADMIN {
+		t.Errorf(`PromoteFirstAdmin failed: %v %+v`, err, admin)
+		return
+	}
+	_, err = config.PromoteFirstAdmin(context.Background(), store, "hank@dea.gov")
+	if !errors.Is(err, config.ErrAdminExists) {
+		t.Errorf(`PromoteFirstAdmin should refuse once there's an admin, got %v`, err)
+		return
+	}
+
+	// walt's old token still says user, so he logs in again to pick up the role
+	walt = logIn(t, server, "walt@breakingbad.com")
+	res = doRequest(t, server, "PUT", "/admin/users/"+hank.ID.String()+"/role", walt.AccessToken, map[string]string{"role": "superuser"})
+	decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED)
+
+	res = doRequest(t, server, "PUT", "/admin/users/"+hank.ID.String()+"/role", walt.AccessToken, map[string]string{"role": "user"})
+	if res.Code != 200 {
+		t.Errorf(`demoting returned %d, want 200`, res.Code)
+		return
+	}
+
+	// hank's token still claims moderator, but the database has the last word
+	res = doRequest(t, server, "GET", "/admin/moderation/flags", hank.AccessToken, nil)
+	decodeError(t, res, 403, config.ERROR_CODE_FORBIDDEN)
+
+	res = doRequest(t, server, "PUT", "/admin/users/"+uuid.NewString()+"/role", walt.AccessToken, map[string]string{"role": "user"})
+	decodeError(t, res, 404, config.ERROR_CODE_NOT_FOUND)
+}
+
+func TestAdminUserManagement(t *testing.T) {
+	server, store := newTestServerWithStore()
+	gus := signUpWithRole(t, server, store, "gus@lospolloshermanos.com", auth.ROLE_ADMIN)
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+	postChirp(t, server, walt.AccessToken, "Say my name")
+	postChirp(t, server, walt.AccessToken, "I am the danger")
+
+	res := doRequest(t, server, "GET", "/admin/users?email=BREAKINGBAD&limit=1", gus.AccessToken, nil)
+	page := chirp.AdminUserPage{}
+	json.Unmarshal(res.Body.Bytes(), &page)
+	if res.Code != 200 || len(page.Users) != 1 || page.Users[0].ID != jesse.ID || len(page.NextCursor) <= 0 {
+		t.Errorf(`first page of search should be jesse with more to come: %d %+v`, res.Code, page)
+		return
+	}
+	res = doRequest(t, server, "GET", "/admin/users?email=breakingbad&limit=1&cursor="+page.NextCursor, gus.AccessToken, nil)
+	page = chirp.AdminUserPage{}
+	json.Unmarshal(res.Body.Bytes(), &page)
+	if len(page.Users) != 1 || page.Users[0].ID != walt.ID || len(page.NextCursor) > 0 {
+		t.Errorf(`second page of search should be just walt: %+v`, page)
+		return
+	}
+
+	res = doRequest(t, server, "GET", "/admin/users/"+walt.ID.String(), gus.AccessToken, nil)
+	detail := chirp.AdminUserDetail{}
+	json.Unmarshal(res.Body.Bytes(), &detail)
+	if res.Code != 200 || detail.ChirpCount != 2 || len(detail.ActiveRefreshTokens) != 1 || detail.SuspendedAt != nil {
+		t.Errorf(`walt's detail is wrong: %d %+v`, res.Code, detail)
+		return
+	}
+
+	res = doRequest(t, server, "POST", "/admin/users/"+walt.ID.String()+"/suspend", gus.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`suspending returned %d, want 204`, res.Code)
+		return
+	}
+	res = doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "my-super-secure-password"})
+	decodeError(t, res, 403, config.ERROR_CODE_ACCOUNT_SUSPENDED)
+	res = doRequest(t, server, "POST", "/api/refresh", walt.RefreshToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	res = doRequest(t, server, "POST", "/api/chirps", walt.AccessToken, chirp.ShortChirp{Message: "Still here"})
+	decodeError(t, res, 403, config.ERROR_CODE_ACCOUNT_SUSPENDED)
+
+	res = doRequest(t, server, "POST", "/admin/users/"+walt.ID.String()+"/unsuspend", gus.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`unsuspending returned %d, want 204`, res.Code)
+		return
+	}
+	walt = logIn(t, server, "walt@breakingbad.com")
+
+	res = doRequest(t, server, "POST", "/admin/users/"+walt.ID.String()+"/force-password-reset", gus.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`forcing a password reset returned %d, want 204`, res.Code)
+		return
+	}
+	res = doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "my-super-secure-password"})
+	decodeError(t, res, 403, config.ERROR_CODE_PASSWORD_RESET_REQUIRED)
+	res = doRequest(t, server, "POST", "/api/refresh", walt.RefreshToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	// whoever held the session can't pick the new password themselves
+	res = doRequest(t, server, "PUT", "/api/users", walt.AccessToken, chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "i-took-your-account"})
+	decodeError(t, res, 401, config.ERROR_CODE_TOKEN_REVOKED)
+
+	// a token that somehow outlived the flag is still turned away
+	err := store.SetPasswordResetRequired(context.Background(), database.SetPasswordResetRequiredParams{PasswordResetRequired: true, ID: jesse.ID})
+	if err != nil {
+		t.Fatalf(`SetPasswordResetRequired failed: %v`, err)
+	}
+	res = doRequest(t, server, "PUT", "/api/users", jesse.AccessToken, chirp.UserCredentials{Email: "jesse@breakingbad.com", Password: "i-took-your-account"})
+	decodeError(t, res, 403, config.ERROR_CODE_PASSWORD_RESET_REQUIRED)
+
+	// admins can't lock themselves out
+	res = doRequest(t, server, "DELETE", "/admin/users/"+gus.ID.String(), gus.AccessToken, nil)
+	decodeError(t, res, 409, config.ERROR_CODE_CONFLICT)
+
+	res = doRequest(t, server, "DELETE", "/admin/users/"+walt.ID.String(), gus.AccessToken, nil)
+	if res.Code != 204 {
+		t.Errorf(`deleting returned %d, want 204`, res.Code)
+		return
+	}
+	res = doRequest(t, server, "GET", "/admin/users/"+walt.ID.String(), gus.AccessToken, nil)
+	decodeError(t, res, 404, config.ERROR_CODE_NOT_FOUND)
+	res = doRequest(t, server, "GET", "/api/chirps", "", nil)
+	chirpPage := chirp.ChirpPage{}
+	json.Unmarshal(res.Body.Bytes(), &chirpPage)
+	if len(chirpPage.Chirps) != 0 {
+		t.Errorf(`deleting a user should delete their chirps: %+v`, chirpPage.Chirps)
+	}
+}
+
+func TestResetOnlyOnDev(t *testing.T) {
+	store := database.NewMemoryStore()
+	userConfig := newTestConfig(store)
+	userConfig.Platform = settings.PLATFORM_PROD
+	server := newTestRoutes(userConfig)
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	gus := signUpWithRole(t, server, store, "gus@lospolloshermanos.com", auth.ROLE_ADMIN)
+
+	res := doRequest(t, server, "POST", "/admin/reset", gus.AccessToken, nil)
+	decodeError(t, res, 403, config.ERROR_CODE_FORBIDDEN)
+	if _, err := store.GetUserViaEmail(context.Background(), "walt@breakingbad.com"); err != nil {
+		t.Errorf(`reset on prod should not remove users: %v`, err)
+		return
+	}
+
+	// even on dev only an admin can wipe everyone
+	userConfig.Platform = settings.PLATFORM_DEV
+	res = doRequest(t, server, "POST", "/admin/reset", "", nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	res = doRequest(t, server, "POST", "/admin/reset", walt.AccessToken, nil)
+	decodeError(t, res, 403, config.ERROR_CODE_FORBIDDEN)
+	if _, err := store.GetUserViaEmail(context.Background(), "walt@breakingbad.com"); err != nil {
+		t.Errorf(`reset by a non-admin should not remove users: %v`, err)
+		return
+	}
+
+	res = doRequest(t, server, "POST", "/admin/reset", gus.AccessToken, nil)
+	if res.Code != 200 {
+		t.Errorf(`reset on dev returned %d, want 200`, res.Code)
+		return
+	}
+	if _, err := store.GetUserViaEmail(context.Background(), "walt@breakingbad.com"); err == nil {
+		t.Errorf(`reset on dev should remove every user`)
+	}
+}
+
+// decodeError checks a response is an error envelope with the given status and code
+func decodeError(t *testing.T, res *httptest.ResponseRecorder, wantStatus int, wantCode string) chirp.ErrorDetail {
+	t.Helper()
+	if res.Code != wantStatus {
+		t.Fatalf(`response returned %d, want %d: %s`, res.Code, wantStatus, res.Body.String())
+	}
+
+	output := chirp.ChirpError{}
+	err := json.Unmarshal(res.Body.Bytes(), &output)
+	if err != nil {
+		t.Fatalf(`error decoding error response: %v`, err)
+	}
+	if output.Error.Code != wantCode {
+		t.Fatalf(`error code is %q, want %q`, output.Error.Code, wantCode)
+	}
+	if len(output.Error.RequestID) <= 0 || output.Error.RequestID != res.Header().Get("X-Request-ID") {
+		t.Fatalf(`error should carry the request id: %+v`, output.Error)
+	}
+	return output.Error
+}
+
+func TestErrorResponses(t *testing.T) {
+	server := newTestServer()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	jesse := signUpAndLogin(t, server, "jesse@breakingbad.com")
+	waltChirp := postChirp(t, server, walt.AccessToken, "say my name")
+
+	req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(`{"body": `))
+	req.Header.Set("Authorization", "Bearer "+walt.AccessToken)
+	req.Header.Set("X-Request-ID", "my-request")
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if detail := decodeError(t, res, 400, "invalid_json"); detail.RequestID != "my-request" {
+		t.Errorf(`request id should come from the client: %q`, detail.RequestID)
+	}
+
+	res = doRequest(t, server, "GET", "/api/chirps/not-a-uuid", "", nil)
+	if detail := decodeError(t, res, 400, "validation_failed"); len(detail.Details["chirp_id"]) <= 0 {
+		t.Errorf(`validation error should name the field: %+v`, detail)
+	}
+
+	res = doRequest(t, server, "DELETE", "/api/chirps/not-a-uuid", walt.AccessToken, nil)
+	decodeError(t, res, 400, "validation_failed")
+
+	res = doRequest(t, server, "GET", "/api/chirps/"+uuid.NewString(), "", nil)
+	decodeError(t, res, 404, "not_found")
+
+	res = doRequest(t, server, "DELETE", "/api/chirps/"+waltChirp.ID.String(), jesse.AccessToken, nil)
+	decodeError(t, res, 403, "forbidden")
+
+	res = doRequest(t, server, "POST", "/api/chirps", "", chirp.ShortChirp{Message: "no token"})
+	decodeError(t, res, 401, "unauthorized")
+
+	expiredToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		Subject:   walt.ID.String(),
+	})
+	signedToken, err := expiredToken.SignedString([]byte(testSecretToken))
+	if err != nil {
+		t.Fatalf(`error signing token: %v`, err)
+	}
+	res = doRequest(t, server, "POST", "/api/chirps", signedToken, chirp.ShortChirp{Message: "too late"})
+	decodeError(t, res, 401, "token_expired")
+
+	res = doRequest(t, server, "POST", "/api/users", "", chirp.UserCredentials{Email: "skyler@breakingbad.com"})
+	if detail := decodeError(t, res, 400, "validation_failed"); len(detail.Details["password"]) <= 0 {
+		t.Errorf(`empty password should be a password error: %+v`, detail)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	userConfig := &config.ApiConfig{
+		DbQueries:      database.NewMemoryStore(),
+		Keys:           newTestKeyRing(),
+		MaxBodyBytes:   64,
+		PasswordHasher: auth.BcryptHasher{Cost: bcrypt.MinCost},
+	}
+	handler := userConfig.MiddlewareLimitBody(http.HandlerFunc(userConfig.CreateNewUserHandler))
+
+	res := doRequest(t, handler, "POST", "/api/users", "", chirp.UserCredentials{
+		Email:    "walt@breakingbad.com",
+		Password: "pw",
+	})
+	if res.Code != 201 {
+		t.Errorf(`small body returned %d, want 201`, res.Code)
+		return
+	}
+
+	res = doRequest(t, handler, "POST", "/api/users", "", chirp.UserCredentials{
+		Email:    "jesse@breakingbad.com",
+		Password: strings.Repeat("a", 100),
+	})
+	decodeError(t, res, 413, "body_too_large")
+}
+
+func TestAsymmetricKeysAndJWKS(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`error generating key: %v`, err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf(`error marshalling key: %v`, err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "ed-2025.pem")
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	keys, err := config.LoadKeyRing(settings.Settings{
+		JWTIssuer:   auth.DEFAULT_ISSUER,
+		Secret:      testSecretToken,
+		JWTKeyFiles: []settings.JWTKeyFile{{ID: "ed-2025", Path: keyPath}},
+	})
+	if err != nil {
+		t.Fatalf(`LoadKeyRing failed: %v`, err)
+	}
+
+	userConfig := &config.ApiConfig{
+		DbQueries:           database.NewMemoryStore(),
+		Keys:                keys,
+		AccessTokenLifetime: time.Hour,
+		PasswordHasher:      auth.BcryptHasher{Cost: bcrypt.MinCost},
+	}
+	serverMux := http.NewServeMux()
+	serverMux.HandleFunc("GET /.well-known/jwks.json", userConfig.HandlerJWKS)
+	serverMux.HandleFunc("POST /api/users", userConfig.CreateNewUserHandler)
+	serverMux.HandleFunc("POST /api/login", userConfig.LoginHandler)
+	serverMux.Handle("POST /api/chirps", userConfig.MiddlewareRequireAuth(userConfig.NewChirpHandler))
+
+	walt := signUpAndLogin(t, serverMux, "walt@breakingbad.com")
+	parsed, _, err := jwt.NewParser().ParseUnverified(walt.AccessToken, &auth.Claims{})
+	if err != nil || parsed.Method.Alg() != auth.ALGORITHM_EDDSA || parsed.Header["kid"] != "ed-2025" {
+		t.Errorf(`login should sign with the key file, got %v: %v`, parsed.Header, err)
+		return
+	}
+	postChirp(t, serverMux, walt.AccessToken, "signed with ed25519")
+
+	// tokens from the old shared secret keep working until it's retired
+	oldToken, err := auth.MakeJWT(walt.ID, auth.ROLE_USER, 0, newTestKeyRing(), time.Hour)
+	if err != nil {
+		t.Fatalf(`MakeJWT failed: %v`, err)
+	}
+	postChirp(t, serverMux, oldToken, "signed with the secret")
+	keys.Retire(auth.HMAC_KEY_ID)
+	res := doRequest(t, serverMux, "POST", "/api/chirps", oldToken, chirp.ShortChirp{Message: "retired"})
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+
+	res = doRequest(t, serverMux, "GET", "/.well-known/jwks.json", "", nil)
+	keySet := auth.JSONWebKeySet{}
+	err = json.Unmarshal(res.Body.Bytes(), &keySet)
+	if res.Code != 200 || err != nil || len(keySet.Keys) != 1 || keySet.Keys[0].KeyID != "ed-2025" || keySet.Keys[0].KeyType != "OKP" {
+		t.Errorf(`JWKS should publish only the Ed25519 key, got %d %s: %v`, res.Code, res.Body.String(), err)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	userConfig := &config.ApiConfig{
+		DbQueries:           database.NewMemoryStore(),
+		Keys:                newTestKeyRing(),
+		AccessTokenLifetime: time.Hour,
+		PasswordHasher:      auth.BcryptHasher{Cost: bcrypt.MinCost},
+		Metrics:             metrics.New(),
+	}
+
+	serverMux := http.NewServeMux()
+	serverMux.HandleFunc("GET /admin/metrics", userConfig.HandlerMetrics)
+	serverMux.HandleFunc("POST /api/users", userConfig.CreateNewUserHandler)
+	serverMux.HandleFunc("POST /api/login", userConfig.LoginHandler)
+	serverMux.HandleFunc("POST /api/chirps", userConfig.NewChirpHandler)
+	server := userConfig.MiddlewareRequestMetrics(serverMux)
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	postChirp(t, server, walt.AccessToken, "say my name")
+	doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "wrong"})
+	doRequest(t, server, "GET", "/nowhere", "", nil)
+
+	res := doRequest(t, server, "GET", "/admin/metrics", "", nil)
+	if res.Code != 200 || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf(`metrics returned %d %q, want prometheus text`, res.Code, res.Header().Get("Content-Type"))
+	}
+
+	body := res.Body.String()
+	for _, want := range []string{
+		`chirpy_http_requests_total{route="POST /api/users",method="POST",status="2xx"} 1`,
+		`chirpy_http_requests_total{route="POST /api/login",method="POST",status="4xx"} 1`,
+		`chirpy_http_requests_total{route="unmatched",method="GET",status="4xx"} 1`,
+		`chirpy_http_request_duration_seconds_count{route="POST /api/chirps",method="POST"} 1`,
+		`chirpy_logins_total{result="success"} 1`,
+		`chirpy_logins_total{result="failure"} 1`,
+		`chirpy_chirps_created_total 1`,
+		`chirpy_http_requests_in_flight 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics should contain %s, got:\n%s", want, body)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/admin/metrics", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9")
+	htmlRes := httptest.NewRecorder()
+	server.ServeHTTP(htmlRes, req)
+	if !strings.HasPrefix(htmlRes.Header().Get("Content-Type"), "text/html") {
+		t.Errorf(`browsers should get the html page, got %q`, htmlRes.Header().Get("Content-Type"))
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var output bytes.Buffer
+	logger, err := logging.New(&output, slog.LevelInfo, logging.FORMAT_JSON)
+	if err != nil {
+		t.Fatalf(`error making logger: %v`, err)
+	}
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(defaultLogger)
+
+	userConfig := &config.ApiConfig{}
+	serverMux := newTestServer()
+	server := userConfig.MiddlewareRequestID(userConfig.MiddlewareAccessLog(serverMux))
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	output.Reset()
+
+	req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(`{"body": "say my name"}`))
+	req.Header.Set("Authorization", "Bearer "+walt.AccessToken)
+	req.Header.Set("X-Request-ID", "walt-1")
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+
+	if res.Header().Get("X-Request-ID") != "walt-1" {
+		t.Errorf(`request id should be echoed back, got %q`, res.Header().Get("X-Request-ID"))
+	}
+	if strings.Contains(output.String(), walt.AccessToken) {
+		t.Fatalf(`access token leaked into the log: %s`, output.String())
+	}
+
+	record := map[string]any{}
+	err = json.Unmarshal(output.Bytes(), &record)
+	if err != nil {
+		t.Fatalf(`access log is not one JSON line: %v: %s`, err, output.String())
+	}
+	if record["route"] != "POST /api/chirps" || record["status"] != float64(201) ||
+		record["user_id"] != walt.ID.String() || record["request_id"] != "walt-1" {
+		t.Errorf(`access log is wrong: %v`, record)
+	}
+}
+
+// recordingMailer keeps every message so tests can pull the tokens out
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (recorder *recordingMailer) Send(ctx context.Context, message mailer.Message) error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.messages = append(recorder.messages, message)
+	return nil
+}
+
+// gatedMailer holds every email until the gate is closed
+type gatedMailer struct {
+	next mailer.Mailer
+	gate chan struct{}
+}
+
+func (gated *gatedMailer) Send(ctx context.Context, message mailer.Message) error {
+	<-gated.gate
+	return gated.next.Send(ctx, message)
+}
+
+var tokenLinkPattern = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastEmailedToken pulls the token out of the newest email sent to someone
+func (recorder *recordingMailer) lastEmailedToken(t *testing.T, to string) string {
+	t.Helper()
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	for i := len(recorder.messages) - 1; i >= 0; i-- {
+		if recorder.messages[i].To != to {
+			continue
+		}
+		match := tokenLinkPattern.FindStringSubmatch(recorder.messages[i].Body)
+		if match == nil {
+			t.Fatalf(`email has no link: %q`, recorder.messages[i].Body)
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatalf(`error unescaping token: %v`, err)
+		}
+		return token
+	}
+	t.Fatalf(`no email was sent to %s`, to)
+	return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+	store := database.NewMemoryStore()
+	recorder := &recordingMailer{}
+	userConfig := newTestConfig(store)
+	userConfig.Mailer = recorder
+	userConfig.EmailVerificationURL = "https://chirpy.example.com/verify"
+	userConfig.RequireVerifiedEmail = true
+	server := newTestRoutes(userConfig)
+
+	res := doRequest(t, server, "POST", "/api/users", "", chirp.UserCredentials{Email: "Walt <walt@breakingbad.com>", Password: "my-super-secure-password"})
+	if detail := decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED); len(detail.Details["email"]) <= 0 {
+		t.Errorf(`a bad email should be reported on the email field: %+v`, detail)
+	}
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	if walt.EmailVerified {
+		t.Errorf(`a new account should not be verified yet`)
+	}
+	token := recorder.lastEmailedToken(t, "walt@breakingbad.com")
+
+	// unverified users can't chirp
+	res = doRequest(t, server, "POST", "/api/chirps", walt.AccessToken, chirp.ShortChirp{Message: "say my name"})
+	decodeError(t, res, 403, config.ERROR_CODE_EMAIL_NOT_VERIFIED)
+
+	// the signup email counts against the resend limit
+	res = doRequest(t, server, "POST", "/api/users/verify/resend", walt.AccessToken, nil)
+	decodeError(t, res, 429, config.ERROR_CODE_RATE_LIMITED)
+	if retryAfter := res.Header().Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Errorf(`a rate limited resend should say when to retry, got %q`, retryAfter)
+	}
+
+	// an access token is not a verification token
+	res = doRequest(t, server, "POST", "/api/users/verify", "", chirp.EmailVerification{Token: walt.AccessToken})
+	decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED)
+
+	res = doRequest(t, server, "POST", "/api/users/verify", "", chirp.EmailVerification{Token: token})
+	if res.Code != 204 {
+		t.Fatalf(`verifying returned %d, want 204: %s`, res.Code, res.Body.String())
+	}
+	res = doRequest(t, server, "POST", "/api/users/verify", "", chirp.EmailVerification{Token: token})
+	decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED)
+
+	walt = logIn(t, server, "walt@breakingbad.com")
+	if !walt.EmailVerified {
+		t.Errorf(`login should report the email as verified`)
+	}
+	postChirp(t, server, walt.AccessToken, "say my name")
+
+	res = doRequest(t, server, "POST", "/api/users/verify/resend", walt.AccessToken, nil)
+	decodeError(t, res, 409, config.ERROR_CODE_CONFLICT)
+
+	// a new address has to be verified again
+	res = doRequest(t, server, "PUT", "/api/users", walt.AccessToken, chirp.UserCredentials{Email: "heisenberg@breakingbad.com", Password: "my-super-secure-password"})
+	if res.Code != 200 {
+		t.Fatalf(`updating credentials returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	heisenberg, err := store.GetUserViaEmail(context.Background(), "heisenberg@breakingbad.com")
+	if err != nil || heisenberg.EmailVerifiedAt.Valid {
+		t.Fatalf(`changing the email should clear verification: %+v %v`, heisenberg, err)
+	}
+	res = doRequest(t, server, "POST", "/api/users/verify", "", chirp.EmailVerification{Token: recorder.lastEmailedToken(t, "heisenberg@breakingbad.com")})
+	if res.Code != 204 {
+		t.Errorf(`verifying the new email returned %d, want 204: %s`, res.Code, res.Body.String())
+	}
+}
+
+func (recorder *recordingMailer) countSent(to, subject string) int {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	count := 0
+	for _, message := range recorder.messages {
+		if message.To == to && message.Subject == subject {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPasswordReset(t *testing.T) {
+	store := database.NewMemoryStore()
+	recorder := &recordingMailer{}
+	userConfig := newTestConfig(store)
+	userConfig.Mailer = recorder
+	userConfig.PasswordResetURL = "https://chirpy.example.com/reset-password"
+	server := newTestRoutes(userConfig)
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	err := store.SetPasswordResetRequired(context.Background(), database.SetPasswordResetRequiredParams{PasswordResetRequired: true, ID: walt.ID})
+	if err != nil {
+		t.Fatalf(`SetPasswordResetRequired failed: %v`, err)
+	}
+
+	// known and unknown emails get the same answer, and neither waits on the mail going out
+	gate := make(chan struct{})
+	userConfig.Mailer = &gatedMailer{next: recorder, gate: gate}
+	known := doRequest(t, server, "POST", "/api/password/forgot", "", chirp.ForgotPassword{Email: "walt@breakingbad.com"})
+	unknown := doRequest(t, server, "POST", "/api/password/forgot", "", chirp.ForgotPassword{Email: "gus@lospolloshermanos.com"})
+	if known.Code != 202 || unknown.Code != 202 || known.Body.String() != unknown.Body.String() {
+		t.Fatalf(`forgot should answer 202 either way, got %d %q and %d %q`, known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+	if recorder.countSent("walt@breakingbad.com", "Reset your Chirpy password") != 0 {
+		t.Errorf(`the email should still be waiting to go out after the answer`)
+	}
+	close(gate)
+	userConfig.WaitForBackground()
+	if recorder.countSent("gus@lospolloshermanos.com", "Reset your Chirpy password") != 0 {
+		t.Errorf(`nothing should be mailed to an email without an account`)
+	}
+	token := recorder.lastEmailedToken(t, "walt@breakingbad.com")
+
+	resetToken, err := store.UsePasswordResetToken(context.Background(), token)
+	if err == nil {
+		t.Errorf(`the raw token should not be stored, only its hash: %+v`, resetToken)
+	}
+
+	// a rejected password leaves the token usable
+	res := doRequest(t, server, "POST", "/api/password/reset", "", chirp.PasswordReset{Token: token, Password: ""})
+	decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED)
+
+	res = doRequest(t, server, "POST", "/api/password/reset", "", chirp.PasswordReset{Token: token, Password: "my-brand-new-password"})
+	if res.Code != 204 {
+		t.Fatalf(`reset returned %d, want 204: %s`, res.Code, res.Body.String())
+	}
+	res = doRequest(t, server, "POST", "/api/password/reset", "", chirp.PasswordReset{Token: token, Password: "yet-another-password"})
+	if detail := decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED); len(detail.Details["token"]) <= 0 {
+		t.Errorf(`a used token should be reported on the token field: %+v`, detail)
+	}
+
+	// every session from before the reset is gone
+	res = doRequest(t, server, "POST", "/api/refresh", walt.RefreshToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	res = doRequest(t, server, "GET", "/api/sessions", walt.AccessToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_TOKEN_REVOKED)
+
+	res = doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "my-super-secure-password"})
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	res = doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "my-brand-new-password"})
+	if res.Code != 200 {
+		t.Errorf(`login with the new password returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+
+	// asking over and over doesn't flood the inbox
+	for i := 0; i < config.PASSWORD_RESET_MAX_PER_WINDOW+2; i++ {
+		res = doRequest(t, server, "POST", "/api/password/forgot", "", chirp.ForgotPassword{Email: "walt@breakingbad.com"})
+		if res.Code != 202 {
+			t.Fatalf(`forgot returned %d, want 202`, res.Code)
+		}
+		userConfig.WaitForBackground()
+	}
+	if sent := recorder.countSent("walt@breakingbad.com", "Reset your Chirpy password"); sent != config.PASSWORD_RESET_MAX_PER_WINDOW {
+		t.Errorf(`%d reset emails were sent, want %d`, sent, config.PASSWORD_RESET_MAX_PER_WINDOW)
+	}
+}
+
+// logInWithMFA sends the password and expects a challenge back
+func logInWithMFA(t *testing.T, handler http.Handler, email string) chirp.MFAChallenge {
+	t.Helper()
+	res := doRequest(t, handler, "POST", "/api/login", "", chirp.UserCredentials{Email: email, Password: "my-super-secure-password"})
+	if res.Code != 200 {
+		t.Fatalf(`login returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+
+	challenge := chirp.MFAChallenge{}
+	err := json.Unmarshal(res.Body.Bytes(), &challenge)
+	if err != nil || !challenge.MFARequired || len(challenge.MFAToken) <= 0 || strings.Contains(res.Body.String(), `"refresh_token"`) {
+		t.Fatalf(`login should only answer with an MFA challenge, got %s: %v`, res.Body.String(), err)
+	}
+	return challenge
+}
+
+func TestMFA(t *testing.T) {
+	server, store := newTestServerWithStore()
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+
+	res := doRequest(t, server, "POST", "/api/mfa/totp/enroll", walt.AccessToken, nil)
+	if res.Code != 200 {
+		t.Fatalf(`enroll returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	enrollment := chirp.MFAEnrollment{}
+	json.Unmarshal(res.Body.Bytes(), &enrollment)
+	if !strings.HasPrefix(enrollment.OtpauthURI, "otpauth://totp/Chirpy:walt@breakingbad.com?") || !strings.Contains(enrollment.OtpauthURI, enrollment.Secret) {
+		t.Errorf(`enrollment should come with an otpauth URI: %+v`, enrollment)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf(`secret is not base32: %v`, err)
+	}
+
+	// nothing changes until the first code is confirmed
+	logIn(t, server, "walt@breakingbad.com")
+
+	res = doRequest(t, server, "POST", "/api/mfa/totp/confirm", walt.AccessToken, chirp.MFACode{Code: "not-a-code"})
+	decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED)
+
+	confirmedAt := time.Now()
+	firstCode := auth.TOTP(key, confirmedAt, auth.TOTP_DIGITS)
+	res = doRequest(t, server, "POST", "/api/mfa/totp/confirm", walt.AccessToken, chirp.MFACode{Code: firstCode})
+	if res.Code != 200 {
+		t.Fatalf(`confirm returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	recovery := chirp.RecoveryCodes{}
+	json.Unmarshal(res.Body.Bytes(), &recovery)
+	if len(recovery.RecoveryCodes) != auth.RECOVERY_CODE_COUNT {
+		t.Fatalf(`confirming should hand out %d recovery codes, got %v`, auth.RECOVERY_CODE_COUNT, recovery.RecoveryCodes)
+	}
+
+	res = doRequest(t, server, "POST", "/api/mfa/totp/enroll", walt.AccessToken, nil)
+	decodeError(t, res, 409, config.ERROR_CODE_CONFLICT)
+
+	challenge := logInWithMFA(t, server, "walt@breakingbad.com")
+
+	// the code used to confirm can't be replayed, and the challenge is no access token
+	res = doRequest(t, server, "POST", "/api/login/mfa", "", chirp.MFALogin{MFAToken: challenge.MFAToken, MFACode: chirp.MFACode{Code: firstCode}})
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	res = doRequest(t, server, "GET", "/api/sessions", challenge.MFAToken, nil)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	res = doRequest(t, server, "POST", "/api/login/mfa", "", chirp.MFALogin{MFAToken: walt.AccessToken, MFACode: chirp.MFACode{Code: firstCode}})
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+
+	nextCode := auth.TOTP(key, confirmedAt.Add(auth.TOTP_PERIOD), auth.TOTP_DIGITS)
+	res = doRequest(t, server, "POST", "/api/login/mfa", "", chirp.MFALogin{MFAToken: challenge.MFAToken, MFACode: chirp.MFACode{Code: nextCode}})
+	if res.Code != 200 {
+		t.Fatalf(`MFA login returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	loggedIn := chirp.User{}
+	json.Unmarshal(res.Body.Bytes(), &loggedIn)
+	if loggedIn.ID != walt.ID || len(loggedIn.AccessToken) <= 0 || len(loggedIn.RefreshToken) <= 0 {
+		t.Errorf(`MFA login should hand out tokens: %s`, res.Body.String())
+	}
+
+	// recovery codes work once
+	challenge = logInWithMFA(t, server, "walt@breakingbad.com")
+	withRecovery := chirp.MFALogin{MFAToken: challenge.MFAToken, MFACode: chirp.MFACode{RecoveryCode: strings.ToUpper(recovery.RecoveryCodes[0])}}
+	res = doRequest(t, server, "POST", "/api/login/mfa", "", withRecovery)
+	if res.Code != 200 {
+		t.Fatalf(`MFA login with a recovery code returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	res = doRequest(t, server, "POST", "/api/login/mfa", "", withRecovery)
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+
+	// a password change bumps the token version, which voids challenges made before it
+	staleChallenge := logInWithMFA(t, server, "walt@breakingbad.com")
+	_, err = store.BumpTokenVersion(context.Background(), walt.ID)
+	if err != nil {
+		t.Fatalf(`BumpTokenVersion failed: %v`, err)
+	}
+	unusedCode := chirp.MFACode{RecoveryCode: recovery.RecoveryCodes[3]}
+	res = doRequest(t, server, "POST", "/api/login/mfa", "", chirp.MFALogin{MFAToken: staleChallenge.MFAToken, MFACode: unusedCode})
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	challenge = logInWithMFA(t, server, "walt@breakingbad.com")
+	res = doRequest(t, server, "POST", "/api/login/mfa", "", chirp.MFALogin{MFAToken: challenge.MFAToken, MFACode: unusedCode})
+	if res.Code != 200 {
+		t.Fatalf(`MFA login with a fresh challenge returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	loggedIn = chirp.User{}
+	json.Unmarshal(res.Body.Bytes(), &loggedIn)
+
+	// regenerating throws the old codes away
+	res = doRequest(t, server, "POST", "/api/mfa/recovery-codes/regenerate", loggedIn.AccessToken, chirp.MFACode{RecoveryCode: recovery.RecoveryCodes[1]})
+	if res.Code != 200 {
+		t.Fatalf(`regenerate returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	regenerated := chirp.RecoveryCodes{}
+	json.Unmarshal(res.Body.Bytes(), &regenerated)
+	res = doRequest(t, server, "POST", "/api/mfa/totp/disable", loggedIn.AccessToken, chirp.MFACode{RecoveryCode: recovery.RecoveryCodes[2]})
+	decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED)
+
+	// an access token alone can't guess its way past the code
+	for i := 0; i < config.ACCOUNT_FREE_FAILURES && res.Code != 429; i++ {
+		res = doRequest(t, server, "POST", "/api/mfa/totp/disable", loggedIn.AccessToken, chirp.MFACode{Code: "000000"})
+	}
+	decodeError(t, res, 429, config.ERROR_CODE_RATE_LIMITED)
+	res = doRequest(t, server, "POST", "/api/mfa/recovery-codes/regenerate", loggedIn.AccessToken, chirp.MFACode{RecoveryCode: regenerated.RecoveryCodes[0]})
+	decodeError(t, res, 429, config.ERROR_CODE_RATE_LIMITED)
+	_, err = store.ClearLoginAttempts(context.Background(), database.ClearLoginAttemptsParams{Scope: config.LOGIN_SCOPE_ACCOUNT, Subject: "walt@breakingbad.com"})
+	if err != nil {
+		t.Fatalf(`ClearLoginAttempts failed: %v`, err)
+	}
+
+	res = doRequest(t, server, "POST", "/api/mfa/totp/disable", loggedIn.AccessToken, chirp.MFACode{RecoveryCode: regenerated.RecoveryCodes[0]})
+	if res.Code != 204 {
+		t.Fatalf(`disable returned %d, want 204: %s`, res.Code, res.Body.String())
+	}
+	logIn(t, server, "walt@breakingbad.com")
+}
+
+func TestLoginThrottling(t *testing.T) {
+	server, store := newTestServerWithStore()
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	gus := signUpWithRole(t, server, store, "gus@lospolloshermanos.com", auth.ROLE_ADMIN)
+
+	// known and unknown emails back off the same way, so throttling doesn't reveal who has an account
+	for _, email := range []string{"walt@breakingbad.com", "jesse@breakingbad.com"} {
+		for i := 0; i < config.ACCOUNT_FREE_FAILURES; i++ {
+			res := doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: email, Password: "not-my-password"})
+			decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+		}
+		res := doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: email, Password: "my-super-secure-password"})
+		decodeError(t, res, 429, config.ERROR_CODE_RATE_LIMITED)
+		if retryAfter := res.Header().Get("Retry-After"); retryAfter != "1" {
+			t.Errorf(`throttled login for %s should say to retry after 1 second, got %q`, email, retryAfter)
+		}
+	}
+
+	res := doRequest(t, server, "GET", "/admin/lockouts", walt.AccessToken, nil)
+	decodeError(t, res, 403, config.ERROR_CODE_FORBIDDEN)
+	res = doRequest(t, server, "GET", "/admin/lockouts", gus.AccessToken, nil)
+	if res.Code != 200 {
+		t.Fatalf(`listing lockouts returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+	lockouts := []chirp.LoginLockout{}
+	err := json.Unmarshal(res.Body.Bytes(), &lockouts)
+	if err != nil || len(lockouts) != 2 {
+		t.Fatalf(`want both throttled emails listed, got %+v: %v`, lockouts, err)
+	}
+	for _, lockout := range lockouts {
+		if lockout.Failures != config.ACCOUNT_FREE_FAILURES || lockout.LockedOut || !lockout.LockedUntil.After(time.Now()) {
+			t.Errorf(`lockout should be a short backoff after %d failures: %+v`, config.ACCOUNT_FREE_FAILURES, lockout)
+		}
+	}
+
+	// an admin can let the owner straight back in
+	res = doRequest(t, server, "DELETE", "/admin/lockouts/Walt@breakingbad.com", gus.AccessToken, nil)
+	if res.Code != 204 {
+		t.Fatalf(`unlocking returned %d, want 204: %s`, res.Code, res.Body.String())
+	}
+	res = doRequest(t, server, "DELETE", "/admin/lockouts/walt@breakingbad.com", gus.AccessToken, nil)
+	decodeError(t, res, 404, config.ERROR_CODE_NOT_FOUND)
+	logIn(t, server, "walt@breakingbad.com")
+
+	// spraying one password across many accounts blocks the address itself
+	for i := 2 * config.ACCOUNT_FREE_FAILURES; i < config.IP_FREE_FAILURES; i++ {
+		res = doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: fmt.Sprintf("user%d@breakingbad.com", i), Password: "password123"})
+		decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	}
+	res = doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "my-super-secure-password"})
+	decodeError(t, res, 429, config.ERROR_CODE_RATE_LIMITED)
+}
+
+// slowLookupStore takes a while to find users, so parallel logins overlap
+type slowLookupStore struct {
+	*database.MemoryStore
+}
+
+func (store slowLookupStore) GetUserViaEmail(ctx context.Context, email string) (database.User, error) {
+	time.Sleep(50 * time.Millisecond)
+	return store.MemoryStore.GetUserViaEmail(ctx, email)
+}
+
+func TestLoginThrottlingParallelGuesses(t *testing.T) {
+	userConfig := newTestConfig(database.NewMemoryStore())
+	userConfig.DbQueries = slowLookupStore{userConfig.DbQueries.(*database.MemoryStore)}
+	server := newTestRoutes(userConfig)
+	signUpAndLogin(t, server, "walt@breakingbad.com")
+
+	// a burst from many addresses at once still only gets the lockout threshold's worth of guesses
+	const burst = 5 * config.ACCOUNT_LOCKOUT_FAILURES
+	codes := make(chan int, burst)
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "not-my-password"})
+			req := httptest.NewRequest("POST", "/api/login", bytes.NewReader(body))
+			req.RemoteAddr = fmt.Sprintf("198.51.100.%d:4242", i)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			codes <- recorder.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	checked := 0
+	for code := range codes {
+		if code == 401 {
+			checked++
+		} else if code != 429 {
+			t.Errorf(`a guess returned %d, want 401 or 429`, code)
+		}
+	}
+	if checked > config.ACCOUNT_LOCKOUT_FAILURES {
+		t.Errorf(`%d passwords were checked, the lockout should have stopped at %d`, checked, config.ACCOUNT_LOCKOUT_FAILURES)
+	}
+
+	res := doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "my-super-secure-password"})
+	decodeError(t, res, 429, config.ERROR_CODE_RATE_LIMITED)
+}
+
+func TestRateLimiting(t *testing.T) {
+	store := database.NewMemoryStore()
+	userConfig := newTestConfig(store)
+	userConfig.RateLimiter = ratelimit.NewMemoryStore()
+	server := newTestRoutes(userConfig)
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	skyler := signUpAndLogin(t, server, "skyler@breakingbad.com")
+	err := store.UpgradeToChirpyRedViaID(context.Background(), skyler.ID)
+	if err != nil {
+		t.Fatalf(`UpgradeToChirpyRedViaID failed: %v`, err)
+	}
+
+	limit := config.RATE_LIMIT_SEARCH.Limit
+	for i := 0; i < limit.Burst; i++ {
+		res := doRequest(t, server, "GET", "/api/chirps/search?q=blue", walt.AccessToken, nil)
+		if res.Code != 200 {
+			t.Fatalf(`search %d returned %d, want 200: %s`, i, res.Code, res.Body.String())
+		}
+		if remaining := res.Header().Get("X-RateLimit-Remaining"); remaining != strconv.Itoa(limit.Burst-i-1) {
+			t.Errorf(`search %d should leave %d requests, got %q`, i, limit.Burst-i-1, remaining)
+		}
+	}
+	res := doRequest(t, server, "GET", "/api/chirps/search?q=blue", walt.AccessToken, nil)
+	decodeError(t, res, 429, config.ERROR_CODE_RATE_LIMITED)
+	if res.Header().Get("Retry-After") != "2" || res.Header().Get("X-RateLimit-Limit") != strconv.Itoa(limit.Burst) ||
+		res.Header().Get("X-RateLimit-Remaining") != "0" || res.Header().Get("X-RateLimit-Reset") != "60" {
+		t.Errorf(`throttled search sent the wrong headers: %v`, res.Header())
+	}
+
+	// each user has their own bucket, and Chirpy Red gets a bigger one
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=blue", skyler.AccessToken, nil)
+	if res.Code != 200 || res.Header().Get("X-RateLimit-Limit") != strconv.Itoa(config.RATE_LIMIT_SEARCH.ChirpyRed.Burst) {
+		t.Errorf(`Chirpy Red search returned %d with limit %q, want 200 and %d`, res.Code, res.Header().Get("X-RateLimit-Limit"), config.RATE_LIMIT_SEARCH.ChirpyRed.Burst)
+	}
+
+	// anonymous requests count against the address, apart from the users behind it
+	res = doRequest(t, server, "GET", "/api/chirps/search?q=blue", "", nil)
+	if res.Code != 200 || res.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(limit.Burst-1) {
+		t.Errorf(`anonymous search returned %d with %q left, want 200 and %d`, res.Code, res.Header().Get("X-RateLimit-Remaining"), limit.Burst-1)
+	}
+
+	// routes outside a policy aren't limited
+	res = doRequest(t, server, "GET", "/api/chirps", walt.AccessToken, nil)
+	if res.Code != 200 || len(res.Header().Get("X-RateLimit-Limit")) > 0 {
+		t.Errorf(`listing chirps returned %d with limit %q, want 200 and no limit`, res.Code, res.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestPasswordRehashOnLogin(t *testing.T) {
+	store := database.NewMemoryStore()
+	userConfig := newTestConfig(store)
+	server := newTestRoutes(userConfig)
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+	oldUser, err := store.GetUserViaID(context.Background(), walt.ID)
+	if err != nil || !strings.HasPrefix(oldUser.HashedPassword, "$2a$04$") {
+		t.Fatalf(`the password should start out as a cost 4 bcrypt hash, got %q: %v`, oldUser.HashedPassword, err)
+	}
+
+	// switching algorithms leaves old hashes alone until their owner logs in
+	userConfig.PasswordHasher = auth.Argon2idHasher{Memory: 1024, Time: 1, Threads: 1}
+	res := doRequest(t, server, "POST", "/api/login", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: "not-my-password"})
+	decodeError(t, res, 401, config.ERROR_CODE_UNAUTHORIZED)
+	unchangedUser, _ := store.GetUserViaID(context.Background(), walt.ID)
+	if unchangedUser.HashedPassword != oldUser.HashedPassword {
+		t.Errorf(`a failed login should not touch the hash`)
+	}
+
+	logIn(t, server, "walt@breakingbad.com")
+	newUser, _ := store.GetUserViaID(context.Background(), walt.ID)
+	if !strings.HasPrefix(newUser.HashedPassword, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf(`login should have upgraded the hash to argon2id, got %q`, newUser.HashedPassword)
+	}
+	if !newUser.UpdatedAt.Equal(oldUser.UpdatedAt) || newUser.TokenVersion != oldUser.TokenVersion {
+		t.Errorf(`rehashing isn't a change the user made, it should leave updated_at and sessions alone`)
+	}
+
+	logIn(t, server, "walt@breakingbad.com")
+	sameUser, _ := store.GetUserViaID(context.Background(), walt.ID)
+	if sameUser.HashedPassword != newUser.HashedPassword {
+		t.Errorf(`a hash that's already current should not be redone`)
+	}
+
+	// going back to a higher bcrypt cost upgrades again
+	userConfig.PasswordHasher = auth.BcryptHasher{Cost: bcrypt.MinCost + 1}
+	logIn(t, server, "walt@breakingbad.com")
+	bcryptUser, _ := store.GetUserViaID(context.Background(), walt.ID)
+	if !strings.HasPrefix(bcryptUser.HashedPassword, "$2a$05$") {
+		t.Errorf(`login should have rehashed with bcrypt cost 5, got %q`, bcryptUser.HashedPassword)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breachedFile := filepath.Join(t.TempDir(), "breached.txt")
+	os.WriteFile(breachedFile, []byte("# leaked passwords\nF3BA381B6BAEF526BF70FF220B1DA4906989224B:1210934\n"), 0o644)
+
+	store := database.NewMemoryStore()
+	userConfig := newTestConfig(store)
+	policy, err := config.LoadPasswordPolicy(settings.Settings{PasswordMinLength: 10, BreachedPasswordsFile: breachedFile})
+	if err != nil {
+		t.Fatalf(`LoadPasswordPolicy failed: %v`, err)
+	}
+	userConfig.PasswordPolicy = policy
+	server := newTestRoutes(userConfig)
+
+	// "qwerty123456" is the sha-1 above
+	for _, password := range []string{"short-pw", "aaaaaaaaaaaaaaaa", "1234567890123", "walt-the-chemistry-teacher", "qwerty123456"} {
+		res := doRequest(t, server, "POST", "/api/users", "", chirp.UserCredentials{Email: "walt@breakingbad.com", Password: password})
+		if detail := decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED); len(detail.Details["password"]) <= 0 {
+			t.Errorf(`signing up with %q should be refused on the password field: %+v`, password, detail)
+		}
+	}
+
+	walt := signUpAndLogin(t, server, "walt@breakingbad.com")
+
+	// changing email counts the new one
+	res := doRequest(t, server, "PUT", "/api/users", walt.AccessToken, chirp.UserCredentials{Email: "heisenberg@breakingbad.com", Password: "i-am-heisenberg-now"})
+	if detail := decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED); !strings.Contains(detail.Details["password"], "email") {
+		t.Errorf(`a password with the new email in it should be refused: %+v`, detail)
+	}
+	res = doRequest(t, server, "PUT", "/api/users", walt.AccessToken, chirp.UserCredentials{Email: "heisenberg@breakingbad.com", Password: "say-my-name-walter"})
+	if res.Code != 200 {
+		t.Errorf(`update returned %d, want 200: %s`, res.Code, res.Body.String())
+	}
+
+	_, err = store.CreatePasswordResetToken(context.Background(), database.CreatePasswordResetTokenParams{
+		ID:        uuid.New(),
+		TokenHash: auth.HashRefreshToken("reset-token"),
+		UserID:    walt.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf(`CreatePasswordResetToken failed: %v`, err)
+	}
+	res = doRequest(t, server, "POST", "/api/password/reset", "", chirp.PasswordReset{Token: "reset-token", Password: "qwerty123456"})
+	if detail := decodeError(t, res, 400, config.ERROR_CODE_VALIDATION_FAILED); len(detail.Details["password"]) <= 0 {
+		t.Errorf(`resetting to a breached password should be refused on the password field: %+v`, detail)
+	}
+	res = doRequest(t, server, "POST", "/api/password/reset", "", chirp.PasswordReset{Token: "reset-token", Password: "my-brand-new-password"})
+	if res.Code != 204 {
+		t.Errorf(`the token should survive a refused password, reset returned %d: %s`, res.Code, res.Body.String())
+	}
+}
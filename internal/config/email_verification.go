@@ -0,0 +1,194 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/mailer"
+	"github.com/CzarRamos/chirpy/internal/settings"
+	"github.com/google/uuid"
+)
+
+// a user can ask for another verification email once a minute, and five times an hour
+const VERIFICATION_RESEND_INTERVAL = time.Minute
+const VERIFICATION_RESEND_WINDOW = time.Hour
+const VERIFICATION_MAX_SENDS_PER_WINDOW = 5
+
+// LoadMailer picks how emails go out, the settings have already checked the mailer's options
+func LoadMailer(appSettings settings.Settings) mailer.Mailer {
+	switch appSettings.Mailer {
+	case mailer.MAILER_FILE:
+		return &mailer.FileMailer{From: appSettings.MailFrom, Path: appSettings.MailFile}
+	case mailer.MAILER_SMTP:
+		return &mailer.SMTPMailer{
+			Addr:     appSettings.SMTPAddr,
+			From:     appSettings.MailFrom,
+			Username: appSettings.SMTPUsername,
+			Password: appSettings.SMTPPassword,
+		}
+	}
+	return &mailer.LogMailer{}
+}
+
+// sendVerificationEmail stores a single use token for the user's current email and mails it.
+// Without a mailer nothing is sent
+func (config *ApiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	if config.Mailer == nil {
+		return nil
+	}
+
+	lifetime := config.EmailVerificationLifetime
+	if lifetime <= 0 {
+		lifetime = settings.DEFAULT_EMAIL_VERIFICATION_LIFETIME
+	}
+
+	tokenRow, err := config.DbQueries.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(lifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakePurposeToken(auth.PURPOSE_EMAIL_VERIFICATION, user.ID, tokenRow.ID, user.TokenVersion, config.PurposeKeys, lifetime)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Confirm your email address by %s\n\nIt works once and expires in %s.\nIf you didn't sign up for Chirpy, ignore this email.\n",
+		tokenInstructions(config.EmailVerificationURL, token), lifetime)
+
+	return config.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your Chirpy email address",
+		Body:    body,
+	})
+}
+
+// tokenInstructions tells the reader how to use an emailed token, as a link when the site has a page for it
+func tokenInstructions(baseURL, token string) string {
+	if len(baseURL) <= 0 {
+		return "using this token:\n\n" + token
+	}
+	return "opening this link:\n\n" + baseURL + "?token=" + url.QueryEscape(token)
+}
+
+// VerifyEmailHandler marks the user's email as verified. The token is used up even if
+// the email changed since it was sent, in which case the new address stays unverified
+func (config *ApiConfig) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	params := chirp.EmailVerification{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	parsed, err := auth.ParsePurposeToken(params.Token, auth.PURPOSE_EMAIL_VERIFICATION, config.PurposeKeys)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error parsing email verification token", "err", err)
+		writeValidationError(w, r, "token", "Verification token is invalid or expired")
+		return
+	}
+
+	tokenRow, err := config.DbQueries.UseEmailVerificationToken(r.Context(), parsed.TokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.WarnContext(r.Context(), "email verification token already used or expired", "user_id", parsed.UserID)
+		writeValidationError(w, r, "token", "Verification token was already used or has expired")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error using email verification token", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if tokenRow.UserID != parsed.UserID {
+		slog.WarnContext(r.Context(), "email verification token belongs to someone else", "user_id", parsed.UserID)
+		writeValidationError(w, r, "token", "Verification token is invalid or expired")
+		return
+	}
+
+	verified, err := config.DbQueries.SetUserEmailVerified(r.Context(), database.SetUserEmailVerifiedParams{
+		ID:    tokenRow.UserID,
+		Email: tokenRow.Email,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marking email verified", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if verified <= 0 {
+		writeValidationError(w, r, "token", "Email has changed since this token was sent")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "email verified", "user_id", tokenRow.UserID)
+	w.WriteHeader(204)
+}
+
+// ResendVerificationHandler mails a fresh verification token to the logged in user
+func (config *ApiConfig) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	foundUser, err := config.DbQueries.GetUserViaID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding user to verify", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if foundUser.EmailVerifiedAt.Valid {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "Email is already verified")
+		return
+	}
+
+	retryAfter, err := config.verificationRetryAfter(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error counting verification emails", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if retryAfter > 0 {
+		writeRateLimited(w, r, retryAfter)
+		return
+	}
+
+	err = config.sendVerificationEmail(r.Context(), foundUser)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error sending verification email", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(202)
+}
+
+// verificationRetryAfter is how long the user has to wait before another verification email, or zero
+func (config *ApiConfig) verificationRetryAfter(ctx context.Context, userID uuid.UUID) (time.Duration, error) {
+	now := time.Now()
+	sent, err := config.DbQueries.ListEmailVerificationTokensSince(ctx, database.ListEmailVerificationTokensSinceParams{
+		UserID:    userID,
+		CreatedAt: now.Add(-VERIFICATION_RESEND_WINDOW),
+	})
+	if err != nil || len(sent) <= 0 {
+		return 0, err
+	}
+
+	// newest first
+	retryAfter := sent[0].CreatedAt.Add(VERIFICATION_RESEND_INTERVAL).Sub(now)
+	if len(sent) >= VERIFICATION_MAX_SENDS_PER_WINDOW {
+		retryAfter = max(retryAfter, sent[len(sent)-1].CreatedAt.Add(VERIFICATION_RESEND_WINDOW).Sub(now))
+	}
+	return max(retryAfter, 0), nil
+}
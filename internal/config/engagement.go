@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func (config *ApiConfig) LikeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	config.handleEngagement(w, r, func(ctx context.Context, userID, chirpID uuid.UUID) error {
+		return config.DbQueries.CreateChirpLike(ctx, database.CreateChirpLikeParams{UserID: userID, ChirpID: chirpID})
+	})
+}
+
+func (config *ApiConfig) UnlikeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	config.handleEngagement(w, r, func(ctx context.Context, userID, chirpID uuid.UUID) error {
+		return config.DbQueries.DeleteChirpLike(ctx, database.DeleteChirpLikeParams{UserID: userID, ChirpID: chirpID})
+	})
+}
+
+func (config *ApiConfig) RechirpHandler(w http.ResponseWriter, r *http.Request) {
+	config.handleEngagement(w, r, func(ctx context.Context, userID, chirpID uuid.UUID) error {
+		return config.DbQueries.CreateRechirp(ctx, database.CreateRechirpParams{UserID: userID, ChirpID: chirpID})
+	})
+}
+
+func (config *ApiConfig) UndoRechirpHandler(w http.ResponseWriter, r *http.Request) {
+	config.handleEngagement(w, r, func(ctx context.Context, userID, chirpID uuid.UUID) error {
+		return config.DbQueries.DeleteRechirp(ctx, database.DeleteRechirpParams{UserID: userID, ChirpID: chirpID})
+	})
+}
+
+// handleEngagement checks the caller and the chirp before running a like or rechirp change.
+// Liking twice or unliking something never liked are both fine
+func (config *ApiConfig) handleEngagement(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, userID, chirpID uuid.UUID) error) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	chirpUUID, ok := parseIDParam(w, r, "chirp_id")
+	if !ok {
+		return
+	}
+
+	foundChirp, err := config.DbQueries.GetChirpViaID(r.Context(), chirpUUID)
+	if errors.Is(err, sql.ErrNoRows) || foundChirp.DeletedAt.Valid {
+		writeNotFound(w, r, "Chirp does not exist")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding chirp", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	err = action(r.Context(), userID, foundChirp.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error updating chirp engagement", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// getViewerID returns the caller if they sent a valid access token.
+// Anonymous callers still see chirps, just without the *_by_me flags
+func (config *ApiConfig) getViewerID(r *http.Request) uuid.NullUUID {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: userID, Valid: true}
+}
+
+// addEngagement fills in like and rechirp counts for every chirp in one query
+func (config *ApiConfig) addEngagement(ctx context.Context, viewerID uuid.NullUUID, chirps ...*chirp.DetailedChirp) error {
+	if len(chirps) <= 0 {
+		return nil
+	}
+
+	chirpIDs := make([]uuid.UUID, 0, len(chirps))
+	for _, detailedChirp := range chirps {
+		chirpIDs = append(chirpIDs, detailedChirp.ID)
+	}
+
+	rows, err := config.DbQueries.GetChirpEngagement(ctx, database.GetChirpEngagementParams{
+		ViewerID: viewerID,
+		ChirpIds: chirpIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	engagementByChirp := make(map[uuid.UUID]database.GetChirpEngagementRow, len(rows))
+	for _, row := range rows {
+		engagementByChirp[row.ChirpID] = row
+	}
+
+	for _, detailedChirp := range chirps {
+		engagement := engagementByChirp[detailedChirp.ID]
+		detailedChirp.LikeCount = engagement.LikeCount
+		detailedChirp.RechirpCount = engagement.RechirpCount
+		if viewerID.Valid {
+			detailedChirp.LikedByMe = &engagement.LikedByMe
+			detailedChirp.RechirpedByMe = &engagement.RechirpedByMe
+		}
+	}
+
+	return nil
+}
+
+// addPageEngagement is addEngagement for a whole page of chirps
+func (config *ApiConfig) addPageEngagement(ctx context.Context, viewerID uuid.NullUUID, chirps []chirp.DetailedChirp) error {
+	chirpPointers := make([]*chirp.DetailedChirp, 0, len(chirps))
+	for idx := range chirps {
+		chirpPointers = append(chirpPointers, &chirps[idx])
+	}
+	return config.addEngagement(ctx, viewerID, chirpPointers...)
+}
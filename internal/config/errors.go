@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/logging"
+	"github.com/google/uuid"
+)
+
+// error codes clients can rely on, unlike the messages next to them
+const (
+	ERROR_CODE_INVALID_JSON      = "invalid_json"
+	ERROR_CODE_VALIDATION_FAILED = "validation_failed"
+	ERROR_CODE_UNAUTHORIZED      = "unauthorized"
+	ERROR_CODE_TOKEN_EXPIRED     = "token_expired"
+	ERROR_CODE_TOKEN_REVOKED     = "token_revoked"
+	ERROR_CODE_FORBIDDEN         = "forbidden"
+	ERROR_CODE_NOT_FOUND         = "not_found"
+	ERROR_CODE_CONFLICT          = "conflict"
+	ERROR_CODE_CONTENT_REJECTED  = "content_rejected"
+	ERROR_CODE_BODY_TOO_LARGE    = "body_too_large"
+	ERROR_CODE_INTERNAL          = "internal_error"
+
+	ERROR_CODE_ACCOUNT_SUSPENDED       = "account_suspended"
+	ERROR_CODE_PASSWORD_RESET_REQUIRED = "password_reset_required"
+	ERROR_CODE_EMAIL_NOT_VERIFIED      = "email_not_verified"
+	ERROR_CODE_RATE_LIMITED            = "rate_limited"
+)
+
+var ErrAccountSuspended = errors.New("error: account is suspended")
+var ErrPasswordResetRequired = errors.New("error: password must be reset")
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// writeError sends the error envelope every handler uses
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeErrorDetails(w, r, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]string) {
+	output := chirp.ChirpError{
+		Error: chirp.ErrorDetail{
+			Code:      code,
+			Message:   message,
+			RequestID: requestID(w, r),
+			Details:   details,
+		},
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling error response", "err", err)
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// writeValidationError reports a single bad field
+func writeValidationError(w http.ResponseWriter, r *http.Request, field, message string) {
+	writeErrorDetails(w, r, 400, ERROR_CODE_VALIDATION_FAILED, "Request is not valid", map[string]string{field: message})
+}
+
+// writeInternalError hides what went wrong from the client, the log has the details
+func writeInternalError(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, 500, ERROR_CODE_INTERNAL, "Something went wrong on our end")
+}
+
+// writeAuthError tells clients whether to refresh their token or log in again
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrAccountSuspended) {
+		writeAccountSuspended(w, r)
+		return
+	}
+	if errors.Is(err, ErrPasswordResetRequired) {
+		writePasswordResetRequired(w, r)
+		return
+	}
+	if errors.Is(err, auth.ErrTokenExpired) {
+		writeError(w, r, 401, ERROR_CODE_TOKEN_EXPIRED, "Token has expired")
+		return
+	}
+	if errors.Is(err, auth.ErrTokenRevoked) {
+		writeError(w, r, 401, ERROR_CODE_TOKEN_REVOKED, "Token has been revoked")
+		return
+	}
+	writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "Token is missing or not valid")
+}
+
+func writeAccountSuspended(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, 403, ERROR_CODE_ACCOUNT_SUSPENDED, "Account is suspended")
+}
+
+func writePasswordResetRequired(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, 403, ERROR_CODE_PASSWORD_RESET_REQUIRED, "Password must be reset before logging in")
+}
+
+// writeRateLimited answers 429, telling the client in whole seconds when to try again
+func writeRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(max(ceilSeconds(retryAfter), 1), 10))
+	writeError(w, r, 429, ERROR_CODE_RATE_LIMITED, "Too many requests, try again later")
+}
+
+// ceilSeconds rounds up, so a client that waits that long is never early
+func ceilSeconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
+
+func writeNotFound(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, 404, ERROR_CODE_NOT_FOUND, message)
+}
+
+func writeForbidden(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, 403, ERROR_CODE_FORBIDDEN, message)
+}
+
+// decodeJSON reads the request body into params, answering 400 when it isn't JSON
+func decodeJSON(w http.ResponseWriter, r *http.Request, params any) bool {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(params)
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, r, 413, ERROR_CODE_BODY_TOO_LARGE, fmt.Sprintf("Request body must be at most %d bytes", maxBytesErr.Limit))
+		return false
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "error decoding parameters", "err", err)
+		writeError(w, r, 400, ERROR_CODE_INVALID_JSON, "Request body must be valid JSON")
+		return false
+	}
+	return true
+}
+
+// parseIDParam reads a uuid path value, answering 400 when it isn't one
+func parseIDParam(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue(name))
+	if err != nil {
+		writeValidationError(w, r, name, "must be a valid id")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// requestID returns the id MiddlewareRequestID gave the request.
+// Handlers used without the middleware get one made up on the spot
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	id := logging.RequestID(r.Context())
+	if len(id) <= 0 {
+		id = logging.CleanRequestID(r.Header.Get(REQUEST_ID_HEADER))
+	}
+	w.Header().Set(REQUEST_ID_HEADER, id)
+	return id
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/pagination"
+	"github.com/google/uuid"
+)
+
+func (config *ApiConfig) FollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	followerID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	followeeID, ok := parseIDParam(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	if followerID == followeeID {
+		writeValidationError(w, r, "user_id", "You cannot follow yourself")
+		return
+	}
+
+	_, err = config.DbQueries.GetUserViaID(r.Context(), followeeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeNotFound(w, r, "User does not exist")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding user to follow", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// following someone twice is not an error
+	err = config.DbQueries.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error following user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (config *ApiConfig) UnfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	followerID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	followeeID, ok := parseIDParam(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	err = config.DbQueries.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error unfollowing user", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (config *ApiConfig) GetFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	config.listFollows(w, r, func(userID uuid.UUID, cursorCreatedAt sql.NullTime, cursorID uuid.NullUUID, pageLimit int32) ([]chirp.FollowEntry, error) {
+		rows, err := config.DbQueries.GetFollowers(r.Context(), database.GetFollowersParams{
+			UserID:          userID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			PageLimit:       pageLimit,
+		})
+
+		entries := make([]chirp.FollowEntry, 0, len(rows))
+		for _, row := range rows {
+			entries = append(entries, chirp.FollowEntry{UserID: row.UserID, FollowedAt: row.FollowedAt})
+		}
+		return entries, err
+	})
+}
+
+func (config *ApiConfig) GetFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	config.listFollows(w, r, func(userID uuid.UUID, cursorCreatedAt sql.NullTime, cursorID uuid.NullUUID, pageLimit int32) ([]chirp.FollowEntry, error) {
+		rows, err := config.DbQueries.GetFollowing(r.Context(), database.GetFollowingParams{
+			UserID:          userID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			PageLimit:       pageLimit,
+		})
+
+		entries := make([]chirp.FollowEntry, 0, len(rows))
+		for _, row := range rows {
+			entries = append(entries, chirp.FollowEntry{UserID: row.UserID, FollowedAt: row.FollowedAt})
+		}
+		return entries, err
+	})
+}
+
+// listFollows pages through one side of a user's follow graph, most recent follows first
+func (config *ApiConfig) listFollows(w http.ResponseWriter, r *http.Request, query func(uuid.UUID, sql.NullTime, uuid.NullUUID, int32) ([]chirp.FollowEntry, error)) {
+	userID, ok := parseIDParam(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	limit, cursor, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		writePageParamsError(w, r, err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	entries, err := query(userID, cursorCreatedAt, cursorID, int32(limit+1))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error listing follows", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	entries, nextCursor, _ := pagination.Window(entries, limit, cursor, func(entry chirp.FollowEntry) (time.Time, uuid.UUID) {
+		return entry.FollowedAt, entry.UserID
+	})
+
+	writePage(w, r, chirp.FollowPage{
+		Users:      entries,
+		NextCursor: nextCursor,
+	}, nextCursor, "")
+}
+
+// TimelineHandler shows the chirps of everyone the caller follows, newest first
+func (config *ApiConfig) TimelineHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	limit, cursor, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		writePageParamsError(w, r, err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	chirpRows, err := config.DbQueries.GetTimelineChirps(r.Context(), database.GetTimelineChirpsParams{
+		FollowerID:      userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting timeline", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	chirpRows, nextCursor, _ := pagination.Window(chirpRows, limit, cursor, chirpPosition)
+
+	output := chirp.ChirpPage{
+		Chirps:     make([]chirp.DetailedChirp, 0, len(chirpRows)),
+		NextCursor: nextCursor,
+	}
+	for _, chirpRow := range chirpRows {
+		output.Chirps = append(output.Chirps, newDetailedChirp(chirpRow))
+	}
+
+	err = config.addPageEngagement(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, output.Chirps)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirp engagement", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writePage(w, r, output, nextCursor, "")
+}
+
+// parseNewestFirstPageParams is parsePageParams for lists that can only be walked forward
+func parseNewestFirstPageParams(r *http.Request) (int, *pagination.Cursor, error) {
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if cursor.IsPrev() {
+		return 0, nil, pagination.ErrInvalidCursor
+	}
+
+	return limit, cursor, nil
+}
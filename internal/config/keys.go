@@ -0,0 +1,76 @@
+package config
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/settings"
+)
+
+// JWKS_CACHE_CONTROL lets other services cache our public keys for a while, short enough to pick up a rotation
+const JWKS_CACHE_CONTROL = "public, max-age=300"
+
+// LoadKeyRing builds the access token key ring from the settings,
+// which have already checked that the kids line up
+func LoadKeyRing(appSettings settings.Settings) (*auth.KeyRing, error) {
+	keys := auth.NewKeyRing(appSettings.JWTIssuer)
+
+	for _, keyFile := range appSettings.JWTKeyFiles {
+		key, err := auth.LoadPrivateKeyFile(keyFile.ID, keyFile.Path)
+		if err != nil {
+			return nil, err
+		}
+		err = keys.Add(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(appSettings.Secret) > 0 {
+		err := keys.Add(auth.NewHMACKey(auth.HMAC_KEY_ID, []byte(appSettings.Secret)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := keys.SetActive(appSettings.ActiveJWTKey())
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range appSettings.JWTRetiredKeys {
+		err = keys.Retire(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slog.Info("loaded access token keys", "active_kid", keys.ActiveKeyID(), "retired", len(appSettings.JWTRetiredKeys))
+	return keys, nil
+}
+
+// LoadPurposeKeyRing builds the ring verification links and MFA challenges are signed with,
+// deriving its secret from the access token secret when the settings don't have one
+func LoadPurposeKeyRing(appSettings settings.Settings) *auth.KeyRing {
+	secret := []byte(appSettings.PurposeSecret)
+	if len(secret) <= 0 {
+		secret = auth.DerivePurposeSecret([]byte(appSettings.Secret))
+	}
+	return auth.NewPurposeKeyRing(appSettings.JWTIssuer, secret)
+}
+
+// HandlerJWKS publishes the public keys other services need to check our access tokens
+func (config *ApiConfig) HandlerJWKS(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(config.Keys.JWKS())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling JWKS", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", JWKS_CACHE_CONTROL)
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/logging"
+	"github.com/google/uuid"
+)
+
+// MiddlewareRequestID gives every request an id, reusing the client's X-Request-ID when it sent a sensible one.
+// The id is echoed back, shows up in every log line for the request and in error responses.
+// It goes outermost: the middlewares inside it share its request, so they all see the route the ServeMux picks
+func (config *ApiConfig) MiddlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logging.CleanRequestID(r.Header.Get(REQUEST_ID_HEADER))
+		w.Header().Set(REQUEST_ID_HEADER, id)
+
+		ctx := logging.WithRequestID(r.Context(), id)
+		ctx = logging.WithRequestInfo(ctx, &logging.RequestInfo{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// MiddlewareAccessLog writes one line per request once it's done.
+// Like MiddlewareRequestMetrics it has to wrap the ServeMux to see the route
+func (config *ApiConfig) MiddlewareAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: 200}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		attrs := []any{
+			slog.String("method", r.Method),
+			slog.String("route", r.Pattern),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int("bytes", recorder.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if userID := logging.GetRequestInfo(r.Context()).UserID(); userID != uuid.Nil {
+			attrs = append(attrs, slog.String("user_id", userID.String()))
+		}
+		if slog.Default().Enabled(r.Context(), slog.LevelDebug) {
+			attrs = append(attrs, logging.Headers(r.Header))
+		}
+
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "request", attrs...)
+	})
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+)
+
+// failed logins are counted per client IP and per account, so one address can't spray
+// passwords across accounts and many addresses can't hammer one account
+const LOGIN_SCOPE_IP = "ip"
+const LOGIN_SCOPE_ACCOUNT = "account"
+
+// LOGIN_FAILURE_WINDOW is how long a failure counts, a quiet window starts the count over
+const LOGIN_FAILURE_WINDOW = time.Hour
+
+// LOGIN_BACKOFF_BASE is the wait after the first failure past the free ones, doubling with each failure after
+const LOGIN_BACKOFF_BASE = time.Second
+
+// LOGIN_LOCKOUT is how long an IP or account stays locked once it reaches its lockout threshold
+const LOGIN_LOCKOUT = 15 * time.Minute
+
+// LOGIN_ATTEMPT_CLEANUP_INTERVAL is how often failure counts that went quiet are dropped
+const LOGIN_ATTEMPT_CLEANUP_INTERVAL = 10 * time.Minute
+
+// ACCOUNT_FREE_FAILURES allows for typos before backoff starts
+const ACCOUNT_FREE_FAILURES = 3
+const ACCOUNT_LOCKOUT_FAILURES = 10
+
+// an IP gets more room than an account since many people can share one address
+const IP_FREE_FAILURES = 10
+const IP_LOCKOUT_FAILURES = 30
+
+type loginPolicy struct {
+	scope           string
+	freeFailures    int32
+	lockoutFailures int32
+}
+
+var ipLoginPolicy = loginPolicy{scope: LOGIN_SCOPE_IP, freeFailures: IP_FREE_FAILURES, lockoutFailures: IP_LOCKOUT_FAILURES}
+var accountLoginPolicy = loginPolicy{scope: LOGIN_SCOPE_ACCOUNT, freeFailures: ACCOUNT_FREE_FAILURES, lockoutFailures: ACCOUNT_LOCKOUT_FAILURES}
+
+// backoff is how long to wait after this many failures in a row
+func (policy loginPolicy) backoff(failures int32) time.Duration {
+	if failures < policy.freeFailures {
+		return 0
+	}
+	if failures >= policy.lockoutFailures {
+		return LOGIN_LOCKOUT
+	}
+	return min(LOGIN_BACKOFF_BASE<<(failures-policy.freeFailures), LOGIN_LOCKOUT)
+}
+
+// loginSubject is the account key for an email. Unknown emails are counted too,
+// so a lockout gives away nothing about whether an account exists
+func loginSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginAttempt is a password or code check that has already been counted against the client's IP
+// and the account. Counting before checking means a burst of parallel guesses each take their own
+// place under the lockout threshold, instead of all getting past the block before any of them fails
+type loginAttempt struct {
+	reserved []reservedLoginAttempt
+}
+
+type reservedLoginAttempt struct {
+	policy  loginPolicy
+	attempt database.LoginAttempt
+}
+
+// reserveLoginAttempt counts an attempt against the IP and the account, or returns how long
+// the one that's blocked still has to wait. Reaching the lockout threshold blocks in the same statement
+func (config *ApiConfig) reserveLoginAttempt(ctx context.Context, ip, email string) (*loginAttempt, time.Duration, error) {
+	keys := []struct {
+		policy  loginPolicy
+		subject string
+	}{
+		{ipLoginPolicy, ip},
+		{accountLoginPolicy, loginSubject(email)},
+	}
+
+	attempt := &loginAttempt{}
+	for _, key := range keys {
+		policy, subject := key.policy, key.subject
+		reserved, err := config.DbQueries.ReserveLoginAttempt(ctx, database.ReserveLoginAttemptParams{
+			Scope:           policy.scope,
+			Subject:         subject,
+			WindowStart:     time.Now().Add(-LOGIN_FAILURE_WINDOW),
+			LockoutFailures: policy.lockoutFailures,
+			LockoutUntil:    time.Now().Add(LOGIN_LOCKOUT),
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			// a refused attempt checks nothing, so it gives back what it took from the other key
+			config.refundLoginAttempt(ctx, attempt)
+			retryAfter, err := config.loginRetryAfter(ctx, policy.scope, subject)
+			return nil, retryAfter, err
+		}
+		if err != nil {
+			config.refundLoginAttempt(ctx, attempt)
+			return nil, 0, err
+		}
+
+		if reserved.Failures == policy.lockoutFailures {
+			slog.WarnContext(ctx, "locked out after repeated failed logins", "scope", policy.scope, "failures", reserved.Failures)
+		}
+		attempt.reserved = append(attempt.reserved, reservedLoginAttempt{policy: policy, attempt: reserved})
+	}
+	return attempt, 0, nil
+}
+
+// loginRetryAfter is how long a blocked IP or account still has to wait
+func (config *ApiConfig) loginRetryAfter(ctx context.Context, scope, subject string) (time.Duration, error) {
+	attempt, err := config.DbQueries.GetLoginAttempt(ctx, database.GetLoginAttemptParams{Scope: scope, Subject: subject})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(attempt.BlockedUntil.Time), nil
+}
+
+// recordLoginFailure keeps the attempt counted and blocks whichever key has earned a backoff
+func (config *ApiConfig) recordLoginFailure(ctx context.Context, attempt *loginAttempt) {
+	for _, reserved := range attempt.reserved {
+		policy := reserved.policy
+		backoff := policy.backoff(reserved.attempt.Failures)
+		// a lockout was already set when the attempt was counted
+		if backoff <= 0 || reserved.attempt.BlockedUntil.Valid {
+			continue
+		}
+		err := config.DbQueries.SetLoginBlockedUntil(ctx, database.SetLoginBlockedUntilParams{
+			Scope:        policy.scope,
+			Subject:      reserved.attempt.Subject,
+			BlockedUntil: sql.NullTime{Time: time.Now().Add(backoff), Valid: true},
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "error blocking failed logins", "scope", policy.scope, "err", err)
+		}
+	}
+}
+
+// refundLoginAttempt uncounts an attempt whose password or code was right, or that never got checked,
+// lifting the lockout it set if nothing has replaced it since
+func (config *ApiConfig) refundLoginAttempt(ctx context.Context, attempt *loginAttempt) {
+	for _, reserved := range attempt.reserved {
+		err := config.DbQueries.RefundLoginAttempt(ctx, database.RefundLoginAttemptParams{
+			ReservedBlockedUntil: reserved.attempt.BlockedUntil,
+			Scope:                reserved.policy.scope,
+			Subject:              reserved.attempt.Subject,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "error refunding login attempt", "scope", reserved.policy.scope, "err", err)
+		}
+	}
+}
+
+// clearLoginFailures forgets an account's failures once its owner proves who they are.
+// The IP keeps its count, one good password shouldn't hide a spray of bad ones
+func (config *ApiConfig) clearLoginFailures(ctx context.Context, email string) {
+	_, err := config.DbQueries.ClearLoginAttempts(ctx, database.ClearLoginAttemptsParams{
+		Scope:   LOGIN_SCOPE_ACCOUNT,
+		Subject: loginSubject(email),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error clearing failed logins", "err", err)
+	}
+}
+
+// beginLoginAttempt counts an attempt before its password or code is checked,
+// answering 429 when the IP or account must wait before trying again
+func (config *ApiConfig) beginLoginAttempt(w http.ResponseWriter, r *http.Request, email string) (*loginAttempt, bool) {
+	attempt, retryAfter, err := config.reserveLoginAttempt(r.Context(), clientIP(r), email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error checking failed logins", "err", err)
+		writeInternalError(w, r)
+		return nil, false
+	}
+	if attempt == nil {
+		slog.WarnContext(r.Context(), "login throttled", "retry_after", retryAfter)
+		config.Metrics.ObserveLogin(false)
+		writeRateLimited(w, r, retryAfter)
+		return nil, false
+	}
+	return attempt, true
+}
+
+// dummyPasswordCheck compares against a throwaway hash when there's no account,
+// so an unknown email takes as long to turn away as a wrong password
+func (config *ApiConfig) dummyPasswordCheck(password string) {
+	config.dummyHashOnce.Do(func() {
+		config.dummyHash, _ = config.passwordHasher().Hash("not-anyones-password")
+	})
+	auth.CheckPasswordHash(password, config.dummyHash)
+}
+
+// ListLockoutsHandler shows the accounts that currently can't log in because of failed attempts
+func (config *ApiConfig) ListLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	attempts, err := config.DbQueries.ListBlockedLoginAttempts(r.Context(), LOGIN_SCOPE_ACCOUNT)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error listing locked accounts", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	output := make([]chirp.LoginLockout, 0, len(attempts))
+	for _, attempt := range attempts {
+		output = append(output, chirp.LoginLockout{
+			Email:        attempt.Subject,
+			Failures:     attempt.Failures,
+			LastFailedAt: attempt.LastFailedAt,
+			LockedUntil:  attempt.BlockedUntil.Time,
+			LockedOut:    attempt.Failures >= ACCOUNT_LOCKOUT_FAILURES,
+		})
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling locked accounts", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// UnlockAccountHandler forgets an account's failed logins so its owner can try again right away
+func (config *ApiConfig) UnlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	email := r.PathValue("email")
+	clearedCount, err := config.DbQueries.ClearLoginAttempts(r.Context(), database.ClearLoginAttemptsParams{
+		Scope:   LOGIN_SCOPE_ACCOUNT,
+		Subject: loginSubject(email),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error unlocking account", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if clearedCount <= 0 {
+		writeNotFound(w, r, "No failed logins for that email")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "account unlocked")
+	w.WriteHeader(204)
+}
+
+// RunLoginAttemptCleanup drops failure counts nobody has added to for a whole window,
+// every interval until ctx is done
+func (config *ApiConfig) RunLoginAttemptCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deletedCount, err := config.DbQueries.DeleteStaleLoginAttempts(ctx, time.Now().Add(-LOGIN_FAILURE_WINDOW))
+			if err != nil {
+				slog.ErrorContext(ctx, "error cleaning up failed logins", "err", err)
+				continue
+			}
+			if deletedCount > 0 {
+				slog.DebugContext(ctx, "cleaned up failed logins", "deleted", deletedCount)
+			}
+		}
+	}
+}
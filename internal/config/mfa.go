@@ -0,0 +1,387 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/logging"
+	"github.com/google/uuid"
+)
+
+// MFA_ISSUER is the name authenticator apps show next to the code
+const MFA_ISSUER = "Chirpy"
+
+// MFA_CHALLENGE_LIFETIME is how long a user has to type their code after the password
+const MFA_CHALLENGE_LIFETIME = 5 * time.Minute
+
+// hasMFA reports whether the user confirmed an authenticator, a pending enrollment doesn't count
+func (config *ApiConfig) hasMFA(ctx context.Context, userID uuid.UUID) (bool, error) {
+	userMfa, err := config.DbQueries.GetUserMFA(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return userMfa.EnabledAt.Valid, nil
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code, using either up
+func (config *ApiConfig) checkSecondFactor(ctx context.Context, userID uuid.UUID, params chirp.MFACode) (bool, error) {
+	userMfa, err := config.DbQueries.GetUserMFA(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil || !userMfa.EnabledAt.Valid {
+		return false, err
+	}
+
+	if len(params.RecoveryCode) > 0 {
+		used, err := config.DbQueries.UseMFARecoveryCode(ctx, database.UseMFARecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(params.RecoveryCode),
+		})
+		return used > 0, err
+	}
+
+	step, ok, err := auth.ValidateTOTP(userMfa.TotpSecret, params.Code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// a step can only be used once, so a code read over someone's shoulder is already spent
+	used, err := config.DbQueries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		UserID:   userID,
+		LastStep: step,
+	})
+	return used > 0, err
+}
+
+// issueRecoveryCodes replaces every recovery code the user had. Only the hashes are kept
+func (config *ApiConfig) issueRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.DbQueries.DeleteMFARecoveryCodesOfUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		err = config.DbQueries.CreateMFARecoveryCode(ctx, database.CreateMFARecoveryCodeParams{
+			ID:       uuid.New(),
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(code),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, r *http.Request, codes []string) {
+	data, err := json.Marshal(chirp.RecoveryCodes{RecoveryCodes: codes})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling recovery codes", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// writeMFAChallenge answers a correct password when the user has MFA. The challenge token
+// only works on /api/login/mfa, together with a code
+func (config *ApiConfig) writeMFAChallenge(w http.ResponseWriter, r *http.Request, foundUser database.User) {
+	challengeToken, err := auth.MakePurposeToken(auth.PURPOSE_MFA_CHALLENGE, foundUser.ID, uuid.New(), foundUser.TokenVersion, config.PurposeKeys, MFA_CHALLENGE_LIFETIME)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error creating MFA challenge", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	data, err := json.Marshal(chirp.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    challengeToken,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling MFA challenge", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	logging.SetUserID(r.Context(), foundUser.ID)
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// LoginMFAHandler finishes a login that was answered with a challenge
+func (config *ApiConfig) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	params := chirp.MFALogin{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	challenge, err := auth.ParsePurposeToken(params.MFAToken, auth.PURPOSE_MFA_CHALLENGE, config.PurposeKeys)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error parsing MFA challenge", "err", err)
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "MFA challenge is invalid or expired, log in again")
+		return
+	}
+
+	foundUser, err := config.DbQueries.GetUserViaID(r.Context(), challenge.UserID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error finding user for MFA challenge", "err", err)
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "MFA challenge is invalid or expired, log in again")
+		return
+	}
+	// a password change since the challenge was made voids it
+	if challenge.Version != foundUser.TokenVersion {
+		slog.WarnContext(r.Context(), "MFA challenge is from an older token version", "user_id", foundUser.ID)
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "MFA challenge is invalid or expired, log in again")
+		return
+	}
+	// the account may have changed since the password was checked
+	if !config.canLogIn(w, r, foundUser) {
+		return
+	}
+	// codes are short, so guessing them counts against the account like guessing passwords
+	attempt, ok := config.beginLoginAttempt(w, r, foundUser.Email)
+	if !ok {
+		return
+	}
+
+	ok, err = config.checkSecondFactor(r.Context(), foundUser.ID, params.MFACode)
+	if err != nil {
+		config.refundLoginAttempt(r.Context(), attempt)
+		slog.ErrorContext(r.Context(), "error checking second factor", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if !ok {
+		slog.WarnContext(r.Context(), "incorrect MFA code", "user_id", foundUser.ID)
+		config.recordLoginFailure(r.Context(), attempt)
+		config.Metrics.ObserveLogin(false)
+		writeError(w, r, 401, ERROR_CODE_UNAUTHORIZED, "Incorrect code")
+		return
+	}
+	config.refundLoginAttempt(r.Context(), attempt)
+
+	config.writeLogin(w, r, foundUser)
+}
+
+// EnrollTOTPHandler makes a new secret for the user to scan. MFA stays off until
+// the first code is confirmed, starting over replaces a secret that was never confirmed
+func (config *ApiConfig) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	foundUser, err := config.DbQueries.GetUserViaID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding user to enroll", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error creating TOTP secret", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	_, err = config.DbQueries.StartMFAEnrollment(r.Context(), database.StartMFAEnrollmentParams{
+		UserID:     userID,
+		TotpSecret: secret,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "MFA is already enabled, disable it first")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error starting MFA enrollment", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	data, err := json.Marshal(chirp.MFAEnrollment{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI(MFA_ISSUER, foundUser.Email, secret),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling MFA enrollment", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// ConfirmTOTPHandler turns MFA on once the user proves their app makes the right codes,
+// and hands out the recovery codes. They're never shown again
+func (config *ApiConfig) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	params := chirp.MFACode{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	userMfa, err := config.DbQueries.GetUserMFA(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "Start enrolling before confirming")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding MFA enrollment", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if userMfa.EnabledAt.Valid {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "MFA is already enabled")
+		return
+	}
+
+	step, ok, err := auth.ValidateTOTP(userMfa.TotpSecret, params.Code, time.Now())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error validating TOTP code", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if !ok {
+		writeValidationError(w, r, "code", "Code doesn't match, check the time on your device")
+		return
+	}
+
+	enabled, err := config.DbQueries.EnableMFA(r.Context(), database.EnableMFAParams{
+		UserID:   userID,
+		LastStep: step,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error enabling MFA", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if enabled <= 0 {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "MFA is already enabled")
+		return
+	}
+
+	codes, err := config.issueRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error creating recovery codes", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "MFA enabled", "user_id", userID)
+	writeRecoveryCodes(w, r, codes)
+}
+
+// DisableTOTPHandler turns MFA off, which takes a code or a recovery code
+func (config *ApiConfig) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := config.requireSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	err := config.DbQueries.DeleteUserMFA(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error disabling MFA", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	err = config.DbQueries.DeleteMFARecoveryCodesOfUser(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error deleting recovery codes", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "MFA disabled", "user_id", userID)
+	w.WriteHeader(204)
+}
+
+// RegenerateRecoveryCodesHandler swaps every recovery code for a new set, which takes a code or a recovery code
+func (config *ApiConfig) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := config.requireSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := config.issueRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error creating recovery codes", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeRecoveryCodes(w, r, codes)
+}
+
+// requireSecondFactor authenticates the request and checks the code in its body,
+// so a stolen access token alone can't turn MFA off. Wrong codes count as failed logins,
+// so they can't be guessed here any faster than at /api/login/mfa
+func (config *ApiConfig) requireSecondFactor(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	foundUser, _, err := config.authenticate(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return uuid.Nil, false
+	}
+	userID := foundUser.ID
+
+	params := chirp.MFACode{}
+	if !decodeJSON(w, r, &params) {
+		return uuid.Nil, false
+	}
+
+	mfaEnabled, err := config.hasMFA(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error checking for MFA", "err", err)
+		writeInternalError(w, r)
+		return uuid.Nil, false
+	}
+	if !mfaEnabled {
+		writeError(w, r, 409, ERROR_CODE_CONFLICT, "MFA is not enabled")
+		return uuid.Nil, false
+	}
+
+	attempt, ok := config.beginLoginAttempt(w, r, foundUser.Email)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	ok, err = config.checkSecondFactor(r.Context(), userID, params)
+	if err != nil {
+		config.refundLoginAttempt(r.Context(), attempt)
+		slog.ErrorContext(r.Context(), "error checking second factor", "err", err)
+		writeInternalError(w, r)
+		return uuid.Nil, false
+	}
+	if !ok {
+		config.recordLoginFailure(r.Context(), attempt)
+		writeValidationError(w, r, "code", "Code is incorrect or was already used")
+		return uuid.Nil, false
+	}
+	config.refundLoginAttempt(r.Context(), attempt)
+	return userID, true
+}
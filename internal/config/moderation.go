@@ -0,0 +1,201 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/moderation"
+)
+
+// LoadModeration builds the moderation chain: the banned_words table first, then any regex patterns.
+// Words in wordsFile are saved into banned_words so admins can edit them like any other word
+func LoadModeration(ctx context.Context, store database.Store, wordsFile, patternsFile string) (*moderation.WordListFilter, moderation.Filter, error) {
+	if len(wordsFile) > 0 {
+		fileWords, err := moderation.LoadWordList(wordsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for word, action := range fileWords {
+			err = store.UpsertBannedWord(ctx, database.UpsertBannedWordParams{Word: word, Action: string(action)})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	bannedWords, err := store.ListBannedWords(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	words := make(map[string]moderation.Action, len(bannedWords))
+	for _, bannedWord := range bannedWords {
+		action, err := moderation.ParseAction(bannedWord.Action)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping banned word", "word", bannedWord.Word, "err", err)
+			continue
+		}
+		words[bannedWord.Word] = action
+	}
+
+	wordFilter := moderation.NewWordListFilter(words)
+	filters := []moderation.Filter{wordFilter}
+
+	if len(patternsFile) > 0 {
+		patternFilters, err := moderation.LoadPatterns(patternsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, patternFilters...)
+	}
+
+	return wordFilter, moderation.NewChain(filters...), nil
+}
+
+func (config *ApiConfig) moderateChirp(body string) moderation.Verdict {
+	if config.Moderator == nil {
+		return moderation.Verdict{Action: moderation.ACTION_ALLOW, Body: body}
+	}
+	return config.Moderator.Check(body)
+}
+
+func (config *ApiConfig) ListBannedWordsHandler(w http.ResponseWriter, r *http.Request) {
+	output := make([]chirp.BannedWord, 0)
+	if config.WordFilter != nil {
+		words := config.WordFilter.Words()
+		for _, word := range moderation.SortedWords(words) {
+			output = append(output, chirp.BannedWord{Word: word, Action: string(words[word])})
+		}
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling banned words", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// SetBannedWordHandler adds a word to the list, or changes what happens when it's used
+func (config *ApiConfig) SetBannedWordHandler(w http.ResponseWriter, r *http.Request) {
+	if config.WordFilter == nil {
+		writeNotFound(w, r, "Word list moderation is not enabled")
+		return
+	}
+
+	params := chirp.BannedWord{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	action, err := moderation.ParseAction(params.Action)
+	if err != nil {
+		writeValidationError(w, r, "action", err.Error())
+		return
+	}
+
+	word, err := moderation.ParseWord(r.PathValue("word"))
+	if err != nil {
+		writeValidationError(w, r, "word", err.Error())
+		return
+	}
+
+	// saved first, so the live list never holds a word that's gone after a restart
+	err = config.DbQueries.UpsertBannedWord(r.Context(), database.UpsertBannedWordParams{
+		Word:   word,
+		Action: string(action),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error saving banned word", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	_, err = config.WordFilter.Set(word, action)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error adding saved banned word to the filter", "word", word, "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (config *ApiConfig) DeleteBannedWordHandler(w http.ResponseWriter, r *http.Request) {
+	if config.WordFilter == nil {
+		writeNotFound(w, r, "Word list moderation is not enabled")
+		return
+	}
+
+	word := moderation.NormalizeWord(strings.TrimSpace(r.PathValue("word")))
+	err := config.DbQueries.DeleteBannedWord(r.Context(), word)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error deleting banned word", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	config.WordFilter.Remove(word)
+
+	w.WriteHeader(204)
+}
+
+func (config *ApiConfig) ListModerationFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	flags, err := config.DbQueries.ListOpenModerationFlags(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error listing moderation flags", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	output := make([]chirp.ModerationFlag, 0, len(flags))
+	for _, flag := range flags {
+		output = append(output, chirp.ModerationFlag{
+			ID:        flag.ID,
+			ChirpID:   flag.ChirpID,
+			Reason:    flag.Reason,
+			CreatedAt: flag.CreatedAt,
+		})
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling moderation flags", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (config *ApiConfig) ResolveModerationFlagHandler(w http.ResponseWriter, r *http.Request) {
+	flagID, ok := parseIDParam(w, r, "flag_id")
+	if !ok {
+		return
+	}
+
+	err := config.DbQueries.ResolveModerationFlag(r.Context(), database.ResolveModerationFlagParams{
+		ResolvedAt: sql.NullTime{
+			Time:  time.Now(),
+			Valid: true,
+		},
+		ID: flagID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error resolving moderation flag", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/CzarRamos/chirpy/internal/passwordpolicy"
+	"github.com/CzarRamos/chirpy/internal/settings"
+)
+
+// LoadPasswordPolicy builds the rules new passwords are held to,
+// reading the breached password list once so signups don't wait on it
+func LoadPasswordPolicy(appSettings settings.Settings) (*passwordpolicy.Policy, error) {
+	policy := &passwordpolicy.Policy{MinLength: appSettings.PasswordMinLength}
+	if len(appSettings.BreachedPasswordsFile) <= 0 {
+		return policy, nil
+	}
+
+	breached, err := passwordpolicy.LoadBreachedList(appSettings.BreachedPasswordsFile)
+	if err != nil {
+		return nil, err
+	}
+	policy.Breached = breached
+	return policy, nil
+}
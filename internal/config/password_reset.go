@@ -0,0 +1,189 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/mailer"
+	"github.com/CzarRamos/chirpy/internal/settings"
+	"github.com/google/uuid"
+)
+
+// an account gets at most three reset emails an hour, further requests are dropped quietly
+const PASSWORD_RESET_WINDOW = time.Hour
+const PASSWORD_RESET_MAX_PER_WINDOW = 3
+
+// PASSWORD_RESET_SEND_TIMEOUT bounds the lookup and email that happen after a forgot request is answered
+const PASSWORD_RESET_SEND_TIMEOUT = 30 * time.Second
+
+// ForgotPasswordHandler mails a reset link if the email has an account.
+// The answer is the same either way, so nobody can use it to find out who signed up
+func (config *ApiConfig) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	params := chirp.ForgotPassword{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	err := mailer.ValidateEmail(params.Email)
+	if err != nil {
+		writeValidationError(w, r, "email", err.Error())
+		return
+	}
+
+	// answered before the account is even looked up, so the time it takes to send
+	// an email doesn't give away that there was someone to send it to
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), PASSWORD_RESET_SEND_TIMEOUT)
+	config.runInBackground(func() {
+		defer cancel()
+		err := config.sendPasswordReset(ctx, params.Email)
+		if err != nil {
+			slog.ErrorContext(ctx, "error sending password reset", "err", err)
+		}
+	})
+
+	w.WriteHeader(202)
+}
+
+// sendPasswordReset stores a hashed reset token and mails the token itself.
+// Unknown emails, suspended accounts and accounts over the limit get nothing
+func (config *ApiConfig) sendPasswordReset(ctx context.Context, email string) error {
+	if config.Mailer == nil {
+		return nil
+	}
+
+	foundUser, err := config.DbQueries.GetUserViaEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.InfoContext(ctx, "password reset asked for an email without an account")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if foundUser.SuspendedAt.Valid {
+		slog.WarnContext(ctx, "password reset asked for a suspended account", "user_id", foundUser.ID)
+		return nil
+	}
+
+	sent, err := config.DbQueries.ListPasswordResetTokensSince(ctx, database.ListPasswordResetTokensSinceParams{
+		UserID:    foundUser.ID,
+		CreatedAt: time.Now().Add(-PASSWORD_RESET_WINDOW),
+	})
+	if err != nil {
+		return err
+	}
+	if len(sent) >= PASSWORD_RESET_MAX_PER_WINDOW {
+		slog.WarnContext(ctx, "too many password resets asked for", "user_id", foundUser.ID)
+		return nil
+	}
+
+	lifetime := config.PasswordResetLifetime
+	if lifetime <= 0 {
+		lifetime = settings.DEFAULT_PASSWORD_RESET_LIFETIME
+	}
+
+	// reset tokens are made and stored just like refresh tokens
+	newToken, err := auth.MakeRefreshToken(lifetime)
+	if err != nil {
+		return err
+	}
+	_, err = config.DbQueries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		ID:        uuid.New(),
+		TokenHash: newToken.Hash,
+		UserID:    foundUser.ID,
+		ExpiresAt: newToken.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Choose a new Chirpy password by %s\n\nIt works once and expires in %s.\nIf you didn't ask for this, ignore this email, your password stays the same.\n",
+		tokenInstructions(config.PasswordResetURL, newToken.Token), lifetime)
+
+	return config.Mailer.Send(ctx, mailer.Message{
+		To:      foundUser.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    body,
+	})
+}
+
+// ResetPasswordHandler sets a new password with an emailed token, then signs every device out
+func (config *ApiConfig) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	params := chirp.PasswordReset{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	// the token is looked at first for whose password this is, and only spent once the
+	// new password checks out, so a rejected password doesn't use it up
+	tokenHash := auth.HashRefreshToken(params.Token)
+	resetToken, err := config.DbQueries.GetUsablePasswordResetToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.WarnContext(r.Context(), "password reset token is unknown, used or expired")
+		writeValidationError(w, r, "token", "Reset token is invalid, already used or expired")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding password reset token", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	foundUser, err := config.DbQueries.GetUserViaID(r.Context(), resetToken.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding user to reset", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	newPasswordHash, ok := config.hashPassword(w, r, params.Password, foundUser.Email)
+	if !ok {
+		return
+	}
+
+	// another request may have spent the token while the password was hashing
+	resetToken, err = config.DbQueries.UsePasswordResetToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.WarnContext(r.Context(), "password reset token is unknown, used or expired")
+		writeValidationError(w, r, "token", "Reset token is invalid, already used or expired")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error using password reset token", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	err = config.DbQueries.ResetUserPassword(r.Context(), database.ResetUserPasswordParams{
+		HashedPassword: newPasswordHash,
+		ID:             resetToken.UserID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error resetting password", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// whoever knew the old password, or holds another reset link, is shut out
+	err = config.DbQueries.ExpirePasswordResetTokensOfUser(r.Context(), resetToken.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error expiring other password reset tokens", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	err = config.DbQueries.RevokeAllRefreshTokensOfUser(r.Context(), resetToken.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error revoking refresh tokens after password reset", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "password reset", "user_id", resetToken.UserID)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/ratelimit"
+)
+
+// RATE_LIMIT_PRUNE_INTERVAL is how often buckets that filled back up are forgotten
+const RATE_LIMIT_PRUNE_INTERVAL = 10 * time.Minute
+
+// RateLimitPolicy is how often one client may call a group of routes.
+// Chirpy Red members get the ChirpyRed limit instead
+type RateLimitPolicy struct {
+	Name      string
+	Limit     ratelimit.Limit
+	ChirpyRed ratelimit.Limit
+}
+
+// logging in and account recovery are anonymous, so these are counted per IP.
+// Failed logins are also throttled on their own, per account
+var RATE_LIMIT_AUTH = RateLimitPolicy{
+	Name:      "auth",
+	Limit:     ratelimit.Limit{Burst: 10, Period: time.Minute},
+	ChirpyRed: ratelimit.Limit{Burst: 10, Period: time.Minute},
+}
+
+var RATE_LIMIT_CHIRPS = RateLimitPolicy{
+	Name:      "chirps",
+	Limit:     ratelimit.Limit{Burst: 20, Period: time.Minute},
+	ChirpyRed: ratelimit.Limit{Burst: 60, Period: time.Minute},
+}
+
+var RATE_LIMIT_SEARCH = RateLimitPolicy{
+	Name:      "search",
+	Limit:     ratelimit.Limit{Burst: 30, Period: time.Minute},
+	ChirpyRed: ratelimit.Limit{Burst: 120, Period: time.Minute},
+}
+
+// MiddlewareRateLimit holds each client to the policy, counting signed in users by their id
+// and everyone else by IP. Without a RateLimiter every request goes through
+func (config *ApiConfig) MiddlewareRateLimit(policy RateLimitPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.RateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, limit := config.rateLimitClient(r, policy)
+		result, err := config.RateLimiter.Take(r.Context(), policy.Name+":"+client, limit)
+		if err != nil {
+			// a broken limiter shouldn't take the API down with it
+			slog.ErrorContext(r.Context(), "error checking rate limit", "policy", policy.Name, "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.ResetAfter), 10))
+		if !result.Allowed {
+			slog.WarnContext(r.Context(), "rate limited", "policy", policy.Name, "client", client)
+			writeRateLimited(w, r, result.RetryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitClient names who a request counts against and which limit they get.
+// A missing or bad token falls back to the IP, the route itself decides whether that's allowed
+func (config *ApiConfig) rateLimitClient(r *http.Request, policy RateLimitPolicy) (string, ratelimit.Limit) {
+	foundUser, _, err := config.authenticate(r)
+	if err != nil {
+		return "ip:" + clientIP(r), policy.Limit
+	}
+	if foundUser.IsChirpyRed.Bool {
+		return "user:" + foundUser.ID.String(), policy.ChirpyRed
+	}
+	return "user:" + foundUser.ID.String(), policy.Limit
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/CzarRamos/chirpy/internal/auth"
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+)
+
+var ErrAdminExists = errors.New("error: an admin already exists, ask them to promote you")
+
+// MiddlewareRequireRole only lets a request through when its access token carries at least role.
+// The role is checked against the database too, so demoting someone takes effect before their token expires
+func (config *ApiConfig) MiddlewareRequireRole(role string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foundUser, claims, err := config.authenticate(r)
+		if err != nil {
+			slog.WarnContext(r.Context(), "error validating access token", "err", err)
+			writeAuthError(w, r, err)
+			return
+		}
+
+		if !auth.HasRole(claims.Role, role) {
+			slog.WarnContext(r.Context(), "role too low", "role", claims.Role, "required", role)
+			writeForbidden(w, r, fmt.Sprintf("Only a %s can do this", role))
+			return
+		}
+		if !auth.HasRole(foundUser.Role, role) {
+			slog.WarnContext(r.Context(), "role was taken away", "role", foundUser.Role, "required", role)
+			writeForbidden(w, r, fmt.Sprintf("Only a %s can do this", role))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithAccessClaims(r.Context(), claims)))
+	})
+}
+
+// SetUserRoleHandler promotes or demotes a user, admins only
+func (config *ApiConfig) SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseIDParam(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	params := chirp.UserRole{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		writeValidationError(w, r, "role", err.Error())
+		return
+	}
+
+	updatedUser, err := config.DbQueries.SetUserRole(r.Context(), database.SetUserRoleParams{
+		Role: role,
+		ID:   userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeNotFound(w, r, "User not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error setting user role", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "user role changed", "target_user_id", updatedUser.ID, "role", updatedUser.Role)
+
+	output := chirp.UserRole{
+		ID:    updatedUser.ID,
+		Email: updatedUser.Email,
+		Role:  updatedUser.Role,
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling user role", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// PromoteFirstAdmin makes the user with this email an admin, but only while there are no admins yet.
+// Every admin after the first is promoted by another admin
+func PromoteFirstAdmin(ctx context.Context, store database.Store, email string) (database.User, error) {
+	adminCount, err := store.CountUsersWithRole(ctx, auth.ROLE_ADMIN)
+	if err != nil {
+		return database.User{}, err
+	}
+	if adminCount > 0 {
+		return database.User{}, ErrAdminExists
+	}
+
+	foundUser, err := store.GetUserViaEmail(ctx, email)
+	if err != nil {
+		return database.User{}, fmt.Errorf("error: unable to find user %s: %w", email, err)
+	}
+
+	return store.SetUserRole(ctx, database.SetUserRoleParams{
+		Role: auth.ROLE_ADMIN,
+		ID:   foundUser.ID,
+	})
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/pagination"
+	"github.com/CzarRamos/chirpy/internal/search"
+	"github.com/google/uuid"
+)
+
+// SearchChirpsHandler finds chirps matching the q parameter, best match first.
+// author_id, since and until (RFC 3339) narrow the results down
+func (config *ApiConfig) SearchChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+
+	searchQuery := queryParams.Get("q")
+	if search.ParseQuery(searchQuery).IsEmpty() {
+		writeValidationError(w, r, "q", "must contain at least one searchable word")
+		return
+	}
+
+	limit, err := pagination.ParseLimit(queryParams.Get("limit"))
+	if err != nil {
+		writeValidationError(w, r, "limit", err.Error())
+		return
+	}
+
+	offset, err := pagination.ParseOffsetCursor(queryParams.Get("cursor"))
+	if err != nil {
+		writeValidationError(w, r, "cursor", err.Error())
+		return
+	}
+
+	params := database.SearchChirpsParams{
+		Query:      searchQuery,
+		PageLimit:  int32(limit + 1),
+		PageOffset: int32(offset),
+	}
+
+	if authorID := queryParams.Get("author_id"); len(authorID) > 0 {
+		authorUUID, err := uuid.Parse(authorID)
+		if err != nil {
+			writeValidationError(w, r, "author_id", "must be a valid id")
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorUUID, Valid: true}
+	}
+
+	params.Since, err = parseTimeParam(queryParams.Get("since"))
+	if err != nil {
+		writeValidationError(w, r, "since", "must be an RFC 3339 timestamp")
+		return
+	}
+
+	params.Until, err = parseTimeParam(queryParams.Get("until"))
+	if err != nil {
+		writeValidationError(w, r, "until", "must be an RFC 3339 timestamp")
+		return
+	}
+
+	rows, err := config.DbQueries.SearchChirps(r.Context(), params)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error searching chirps", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	nextCursor := ""
+	if len(rows) > limit {
+		rows = rows[:limit]
+		nextCursor = pagination.EncodeOffsetCursor(offset + limit)
+	}
+
+	output := chirp.SearchPage{
+		Results:    make([]chirp.SearchResult, 0, len(rows)),
+		NextCursor: nextCursor,
+	}
+	for _, row := range rows {
+		output.Results = append(output.Results, chirp.SearchResult{
+			DetailedChirp: newDetailedChirp(database.Chirp{
+				ID:        row.ID,
+				CreatedAt: row.CreatedAt,
+				UpdatedAt: row.UpdatedAt,
+				Body:      row.Body,
+				UserID:    row.UserID,
+				InReplyTo: row.InReplyTo,
+				DeletedAt: row.DeletedAt,
+			}),
+			Rank:    row.Rank,
+			Snippet: row.Snippet,
+		})
+	}
+
+	resultChirps := make([]*chirp.DetailedChirp, 0, len(output.Results))
+	for idx := range output.Results {
+		resultChirps = append(resultChirps, &output.Results[idx].DetailedChirp)
+	}
+	err = config.addEngagement(r.Context(), config.getViewerID(r), resultChirps...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirp engagement", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writePage(w, r, output, nextCursor, "")
+}
+
+// parseTimeParam reads an optional RFC 3339 query parameter as UTC,
+// the zone chirp timestamps are stored in
+func parseTimeParam(raw string) (sql.NullTime, error) {
+	if len(raw) <= 0 {
+		return sql.NullTime{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: parsed.UTC(), Valid: true}, nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+)
+
+// MAX_USER_AGENT_LENGTH keeps clients from storing essays in every refresh token
+const MAX_USER_AGENT_LENGTH = 512
+
+// clientIP is the address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func userAgent(r *http.Request) string {
+	agent := r.UserAgent()
+	if len(agent) > MAX_USER_AGENT_LENGTH {
+		return agent[:MAX_USER_AGENT_LENGTH]
+	}
+	return agent
+}
+
+// ListSessionsHandler shows every device the user is signed in on, most recently refreshed first
+func (config *ApiConfig) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	refreshTokens, err := config.DbQueries.ListActiveRefreshTokensOfUser(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error listing sessions", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// rotation leaves one active token per family, and the family is the session
+	output := make([]chirp.Session, 0, len(refreshTokens))
+	for _, refreshToken := range refreshTokens {
+		output = append(output, newSession(refreshToken))
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling sessions", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func newSession(refreshToken database.RefreshToken) chirp.Session {
+	lastUsedAt := refreshToken.CreatedAt
+	if refreshToken.LastUsedAt.Valid {
+		lastUsedAt = refreshToken.LastUsedAt.Time
+	}
+	return chirp.Session{
+		ID:         refreshToken.FamilyID,
+		UserAgent:  refreshToken.UserAgent,
+		IP:         refreshToken.Ip,
+		LastUsedAt: lastUsedAt,
+		ExpiresAt:  refreshToken.ExpiresAt,
+	}
+}
+
+// RevokeSessionHandler signs one of the user's devices out
+func (config *ApiConfig) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	sessionID, ok := parseIDParam(w, r, "session_id")
+	if !ok {
+		return
+	}
+
+	// matching on the user too means nobody can end someone else's session
+	revokedCount, err := config.DbQueries.RevokeRefreshTokenFamily(r.Context(), database.RevokeRefreshTokenFamilyParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error revoking session", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	if revokedCount <= 0 {
+		writeNotFound(w, r, "Session not found")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// LogoutAllHandler signs the user out on every device.
+// Bumping the token version kills the access tokens too, including the one used here
+func (config *ApiConfig) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := config.getAuthenticatedUserID(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "error token not valid", "err", err)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	if !config.revokeAllRefreshTokens(w, r, userID) {
+		return
+	}
+
+	_, err = config.DbQueries.BumpTokenVersion(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error bumping token version", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "logged out of every session")
+	w.WriteHeader(204)
+}
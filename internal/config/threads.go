@@ -0,0 +1,102 @@
+package config
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/CzarRamos/chirpy/internal/chirp"
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// GetChirpThreadHandler shows the conversation around a chirp:
+// the chain of chirps it replies to, oldest first, and every reply beneath it
+func (config *ApiConfig) GetChirpThreadHandler(w http.ResponseWriter, r *http.Request) {
+	chirpUUID, ok := parseIDParam(w, r, "chirp_id")
+	if !ok {
+		return
+	}
+
+	foundChirp, err := config.DbQueries.GetChirpViaID(r.Context(), chirpUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeNotFound(w, r, "Chirp does not exist")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error finding chirp", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	ancestors, err := config.DbQueries.GetChirpAncestors(r.Context(), foundChirp.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirp ancestors", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	descendants, err := config.DbQueries.GetChirpDescendants(r.Context(), uuid.NullUUID{UUID: foundChirp.ID, Valid: true})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirp replies", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// descendants come oldest first, so each chirp's replies stay in order
+	repliesByParent := make(map[uuid.UUID][]database.Chirp)
+	for _, reply := range descendants {
+		repliesByParent[reply.InReplyTo.UUID] = append(repliesByParent[reply.InReplyTo.UUID], reply)
+	}
+
+	output := chirp.ChirpThread{
+		Ancestors: make([]chirp.DetailedChirp, 0, len(ancestors)),
+		Chirp:     newThreadNode(foundChirp, repliesByParent),
+	}
+	for _, ancestor := range ancestors {
+		output.Ancestors = append(output.Ancestors, newDetailedChirp(ancestor))
+	}
+
+	threadChirps := collectThreadChirps(&output.Chirp, nil)
+	for idx := range output.Ancestors {
+		threadChirps = append(threadChirps, &output.Ancestors[idx])
+	}
+	err = config.addEngagement(r.Context(), config.getViewerID(r), threadChirps...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error getting chirp engagement", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error marshalling chirp thread", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func newThreadNode(chirpRow database.Chirp, repliesByParent map[uuid.UUID][]database.Chirp) chirp.ThreadNode {
+	node := chirp.ThreadNode{
+		DetailedChirp: newDetailedChirp(chirpRow),
+		Replies:       make([]chirp.ThreadNode, 0, len(repliesByParent[chirpRow.ID])),
+	}
+	for _, reply := range repliesByParent[chirpRow.ID] {
+		node.Replies = append(node.Replies, newThreadNode(reply, repliesByParent))
+	}
+	return node
+}
+
+// collectThreadChirps gathers every chirp in a reply tree so they can be filled in together
+func collectThreadChirps(node *chirp.ThreadNode, collected []*chirp.DetailedChirp) []*chirp.DetailedChirp {
+	collected = append(collected, &node.DetailedChirp)
+	for idx := range node.Replies {
+		collected = collectThreadChirps(&node.Replies[idx], collected)
+	}
+	return collected
+}
@@ -7,21 +7,23 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES(
     $1,
     NOW(),
     $2,
     $3, 
-    $4
+    $4,
+    $5
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at
 `
 
 type CreateChirpParams struct {
@@ -29,6 +31,7 @@ type CreateChirpParams struct {
 	UpdatedAt time.Time
 	Body      string
 	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
@@ -37,6 +40,7 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		arg.UpdatedAt,
 		arg.Body,
 		arg.UserID,
+		arg.InReplyTo,
 	)
 	var i Chirp
 	err := row.Scan(
@@ -45,10 +49,38 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const countChirpReplies = `-- name: CountChirpReplies :one
+SELECT COUNT(*)
+FROM chirps
+WHERE in_reply_to = $1
+`
+
+func (q *Queries) CountChirpReplies(ctx context.Context, inReplyTo uuid.NullUUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpReplies, inReplyTo)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countChirpsOfUser = `-- name: CountChirpsOfUser :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) CountChirpsOfUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsOfUser, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const deleteChirpPerm = `-- name: DeleteChirpPerm :exec
 DELETE from chirps
 WHERE id = $1
@@ -60,9 +92,9 @@ func (q *Queries) DeleteChirpPerm(ctx context.Context, id uuid.UUID) error {
 }
 
 const getAllChirpsOfUserID = `-- name: GetAllChirpsOfUserID :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at
 FROM chirps
-WHERE user_id = $1
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
@@ -81,6 +113,8 @@ func (q *Queries) GetAllChirpsOfUserID(ctx context.Context, userID uuid.UUID) ([
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -96,9 +130,9 @@ func (q *Queries) GetAllChirpsOfUserID(ctx context.Context, userID uuid.UUID) ([
 }
 
 const getAllChirpsSinceCreation = `-- name: GetAllChirpsSinceCreation :many
-SELECT id, created_at, updated_at, body, user_id 
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at 
 FROM chirps
-WHERE id IS NOT NULL
+WHERE id IS NOT NULL AND deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
@@ -117,6 +151,100 @@ func (q *Queries) GetAllChirpsSinceCreation(ctx context.Context) ([]Chirp, error
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.created_at, parent.updated_at, parent.body, parent.user_id, parent.in_reply_to, parent.deleted_at
+    FROM chirps AS parent
+    WHERE parent.id = (SELECT child.in_reply_to FROM chirps AS child WHERE child.id = $1)
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at
+FROM ancestors
+ORDER BY created_at ASC, id ASC
+`
+
+func (q *Queries) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at
+    FROM chirps
+    WHERE chirps.in_reply_to = $1
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at
+    FROM chirps
+    JOIN descendants ON chirps.in_reply_to = descendants.id
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at
+FROM descendants
+ORDER BY created_at ASC, id ASC
+`
+
+func (q *Queries) GetChirpDescendants(ctx context.Context, inReplyTo uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants, inReplyTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -132,7 +260,7 @@ func (q *Queries) GetAllChirpsSinceCreation(ctx context.Context) ([]Chirp, error
 }
 
 const getChirpViaID = `-- name: GetChirpViaID :one
-SELECT id, created_at, updated_at, body, user_id 
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at 
 FROM chirps
 WHERE id = $1
 `
@@ -146,6 +274,131 @@ func (q *Queries) GetChirpViaID(ctx context.Context, id uuid.UUID) (Chirp, error
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
 	)
 	return i, err
 }
+
+const getChirpsAfterCursor = `-- name: GetChirpsAfterCursor :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at
+FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1::uuid)
+AND ($2::timestamp IS NULL OR (created_at, id) > ($2::timestamp, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type GetChirpsAfterCursorParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsAfterCursor(ctx context.Context, arg GetChirpsAfterCursorParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsAfterCursor,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsBeforeCursor = `-- name: GetChirpsBeforeCursor :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at
+FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1::uuid)
+AND ($2::timestamp IS NULL OR (created_at, id) < ($2::timestamp, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type GetChirpsBeforeCursorParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsBeforeCursor(ctx context.Context, arg GetChirpsBeforeCursorParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsBeforeCursor,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = $1, updated_at = $2
+WHERE id = $3
+`
+
+type TombstoneChirpParams struct {
+	DeletedAt sql.NullTime
+	UpdatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (q *Queries) TombstoneChirp(ctx context.Context, arg TombstoneChirpParams) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, arg.DeletedAt, arg.UpdatedAt, arg.ID)
+	return err
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const UNIQUE_VIOLATION_CODE = "23505"
+
+// IsUniqueViolation reports whether a store rejected a row because a unique column was already taken
+func IsUniqueViolation(err error) bool {
+	if errors.Is(err, ErrUniqueViolation) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == UNIQUE_VIOLATION_CODE
+}
@@ -0,0 +1,1536 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/search"
+	"github.com/google/uuid"
+)
+
+var ErrUniqueViolation = errors.New("error: duplicate key violates unique constraint")
+var ErrForeignKeyViolation = errors.New("error: insert or update violates foreign key constraint")
+var ErrCheckViolation = errors.New("error: row violates check constraint")
+
+// MemoryStore keeps every table in maps so the API can run without Postgres.
+// It follows the same rules as the schema in sql/schema: emails are unique,
+// and deleting a user deletes everything that belongs to them
+type MemoryStore struct {
+	mu            sync.RWMutex
+	users         map[uuid.UUID]User
+	chirps        map[uuid.UUID]Chirp
+	refreshTokens map[uuid.UUID]RefreshToken
+	follows       map[followKey]Follow
+	likes         map[engagementKey]ChirpLike
+	rechirps      map[engagementKey]Rechirp
+	bannedWords   map[string]BannedWord
+	flags         map[uuid.UUID]ModerationFlag
+	deniedTokens  map[string]RevokedAccessToken
+	emailTokens   map[uuid.UUID]EmailVerificationToken
+	resetTokens   map[uuid.UUID]PasswordResetToken
+	mfa           map[uuid.UUID]UserMfa
+	recoveryCodes map[uuid.UUID]MfaRecoveryCode
+	loginAttempts map[loginAttemptKey]LoginAttempt
+}
+
+type followKey struct {
+	followerID uuid.UUID
+	followeeID uuid.UUID
+}
+
+type loginAttemptKey struct {
+	scope   string
+	subject string
+}
+
+type engagementKey struct {
+	userID  uuid.UUID
+	chirpID uuid.UUID
+}
+
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		users:         make(map[uuid.UUID]User),
+		chirps:        make(map[uuid.UUID]Chirp),
+		refreshTokens: make(map[uuid.UUID]RefreshToken),
+		follows:       make(map[followKey]Follow),
+		likes:         make(map[engagementKey]ChirpLike),
+		rechirps:      make(map[engagementKey]Rechirp),
+		bannedWords:   make(map[string]BannedWord),
+		flags:         make(map[uuid.UUID]ModerationFlag),
+		deniedTokens:  make(map[string]RevokedAccessToken),
+		emailTokens:   make(map[uuid.UUID]EmailVerificationToken),
+		resetTokens:   make(map[uuid.UUID]PasswordResetToken),
+		mfa:           make(map[uuid.UUID]UserMfa),
+		recoveryCodes: make(map[uuid.UUID]MfaRecoveryCode),
+		loginAttempts: make(map[loginAttemptKey]LoginAttempt),
+	}
+
+	// the same words 010_moderation.sql starts the table with
+	for _, word := range []string{"kerfuffle", "sharbert", "fornax"} {
+		store.bannedWords[word] = BannedWord{Word: word, Action: "mask", CreatedAt: now()}
+	}
+
+	return store
+}
+
+// now matches what NOW() stores in a postgres TIMESTAMP column
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func (m *MemoryStore) emailTaken(email string, exceptID uuid.UUID) bool {
+	for _, user := range m.users {
+		if user.Email == email && user.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// sortChirpsByCreation orders chirps from oldest to latest
+func sortChirpsByCreation(chirps []Chirp) {
+	sort.SliceStable(chirps, func(i, j int) bool { return chirps[i].CreatedAt.Before(chirps[j].CreatedAt) })
+}
+
+// comparePosition orders rows the same way as a (created_at, id) row comparison in postgres
+func comparePosition(createdAt time.Time, id uuid.UUID, otherCreatedAt time.Time, otherID uuid.UUID) int {
+	if cmp := createdAt.Compare(otherCreatedAt); cmp != 0 {
+		return cmp
+	}
+	return bytes.Compare(id[:], otherID[:])
+}
+
+func compareChirpPosition(chirp Chirp, createdAt time.Time, id uuid.UUID) int {
+	return comparePosition(chirp.CreatedAt, chirp.ID, createdAt, id)
+}
+
+// sortChirpsByPosition orders chirps by (created_at, id), oldest first
+func sortChirpsByPosition(chirps []Chirp) {
+	sort.Slice(chirps, func(i, j int) bool { return compareChirpPosition(chirps[i], chirps[j].CreatedAt, chirps[j].ID) < 0 })
+}
+
+// isBeforeCursor reports whether a row belongs on a newest first page that starts at the cursor
+func isBeforeCursor(createdAt time.Time, id uuid.UUID, cursorCreatedAt sql.NullTime, cursorID uuid.NullUUID) bool {
+	return !cursorCreatedAt.Valid || comparePosition(createdAt, id, cursorCreatedAt.Time, cursorID.UUID) < 0
+}
+
+// chirpsPage filters live chirps by author and cursor.
+// keep reports whether a chirp's position relative to the cursor belongs on the page
+func (m *MemoryStore) chirpsPage(authorID uuid.NullUUID, cursorCreatedAt sql.NullTime, cursorID uuid.NullUUID, keep func(cmp int) bool) []Chirp {
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if chirp.DeletedAt.Valid {
+			continue
+		}
+		if authorID.Valid && chirp.UserID != authorID.UUID {
+			continue
+		}
+		if cursorCreatedAt.Valid && !keep(compareChirpPosition(chirp, cursorCreatedAt.Time, cursorID.UUID)) {
+			continue
+		}
+		items = append(items, chirp)
+	}
+	return items
+}
+
+func limitChirps(chirps []Chirp, pageLimit int32) []Chirp {
+	if pageLimit >= 0 && len(chirps) > int(pageLimit) {
+		return chirps[:pageLimit]
+	}
+	return chirps
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[arg.ID]; exists {
+		return User{}, ErrUniqueViolation
+	}
+	if m.emailTaken(arg.Email, uuid.Nil) {
+		return User{}, ErrUniqueViolation
+	}
+
+	newUser := User{
+		ID:             arg.ID,
+		HashedPassword: arg.HashedPassword,
+		CreatedAt:      now(),
+		UpdatedAt:      arg.UpdatedAt,
+		Email:          arg.Email,
+		IsChirpyRed:    sql.NullBool{Bool: false, Valid: true},
+		Role:           "user",
+	}
+	m.users[newUser.ID] = newUser
+
+	return newUser, nil
+}
+
+func (m *MemoryStore) GetUserViaID(ctx context.Context, id uuid.UUID) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, exists := m.users[id]
+	if !exists {
+		return User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *MemoryStore) GetUserViaEmail(ctx context.Context, email string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) RemoveAllUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// every other table cascades from users
+	m.users = make(map[uuid.UUID]User)
+	m.chirps = make(map[uuid.UUID]Chirp)
+	m.refreshTokens = make(map[uuid.UUID]RefreshToken)
+	m.follows = make(map[followKey]Follow)
+	m.likes = make(map[engagementKey]ChirpLike)
+	m.rechirps = make(map[engagementKey]Rechirp)
+	m.flags = make(map[uuid.UUID]ModerationFlag)
+	m.emailTokens = make(map[uuid.UUID]EmailVerificationToken)
+	m.resetTokens = make(map[uuid.UUID]PasswordResetToken)
+	m.mfa = make(map[uuid.UUID]UserMfa)
+	m.recoveryCodes = make(map[uuid.UUID]MfaRecoveryCode)
+	return nil
+}
+
+func (m *MemoryStore) UpdateUserCredentials(ctx context.Context, arg UpdateUserCredentialsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[arg.ID]
+	if !exists {
+		return nil
+	}
+	if m.emailTaken(arg.Email, arg.ID) {
+		return ErrUniqueViolation
+	}
+
+	if user.Email != arg.Email {
+		user.EmailVerifiedAt = sql.NullTime{}
+	}
+	user.Email = arg.Email
+	user.HashedPassword = arg.HashedPassword
+	user.PasswordResetRequired = false
+	user.TokenVersion++
+	m.users[arg.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) UpgradeToChirpyRedViaID(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[id]
+	if !exists {
+		return nil
+	}
+
+	user.IsChirpyRed = sql.NullBool{Bool: true, Valid: true}
+	m.users[id] = user
+	return nil
+}
+
+func (m *MemoryStore) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []User
+	for _, user := range m.users {
+		if arg.EmailSearch.Valid && !strings.Contains(strings.ToLower(user.Email), strings.ToLower(arg.EmailSearch.String)) {
+			continue
+		}
+		if isBeforeCursor(user.CreatedAt, user.ID, arg.CursorCreatedAt, arg.CursorID) {
+			items = append(items, user)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return comparePosition(items[i].CreatedAt, items[i].ID, items[j].CreatedAt, items[j].ID) > 0
+	})
+	if len(items) > int(arg.PageLimit) {
+		items = items[:arg.PageLimit]
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) SetUserSuspended(ctx context.Context, arg SetUserSuspendedParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[arg.ID]
+	if !exists {
+		return nil
+	}
+
+	user.SuspendedAt = arg.SuspendedAt
+	user.UpdatedAt = now()
+	m.users[arg.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) SetPasswordResetRequired(ctx context.Context, arg SetPasswordResetRequiredParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[arg.ID]
+	if !exists {
+		return nil
+	}
+
+	user.PasswordResetRequired = arg.PasswordResetRequired
+	user.UpdatedAt = now()
+	m.users[arg.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[arg.ID]
+	if !exists || user.HashedPassword != arg.OldHashedPassword {
+		return 0, nil
+	}
+
+	user.HashedPassword = arg.NewHashedPassword
+	m.users[arg.ID] = user
+	return 1, nil
+}
+
+// DeleteUser removes a user and everything ON DELETE CASCADE would take with them
+func (m *MemoryStore) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[id]; !exists {
+		return 0, nil
+	}
+	delete(m.users, id)
+
+	for chirpID, chirp := range m.chirps {
+		if chirp.UserID == id {
+			m.deleteChirp(chirpID)
+		}
+	}
+	for tokenID, refreshToken := range m.refreshTokens {
+		if refreshToken.UserID == id {
+			delete(m.refreshTokens, tokenID)
+		}
+	}
+	for key := range m.follows {
+		if key.followerID == id || key.followeeID == id {
+			delete(m.follows, key)
+		}
+	}
+	for key := range m.likes {
+		if key.userID == id {
+			delete(m.likes, key)
+		}
+	}
+	for key := range m.rechirps {
+		if key.userID == id {
+			delete(m.rechirps, key)
+		}
+	}
+	for tokenID, emailToken := range m.emailTokens {
+		if emailToken.UserID == id {
+			delete(m.emailTokens, tokenID)
+		}
+	}
+	for tokenID, resetToken := range m.resetTokens {
+		if resetToken.UserID == id {
+			delete(m.resetTokens, tokenID)
+		}
+	}
+	delete(m.mfa, id)
+	for codeID, recoveryCode := range m.recoveryCodes {
+		if recoveryCode.UserID == id {
+			delete(m.recoveryCodes, codeID)
+		}
+	}
+	return 1, nil
+}
+
+// userRoles mirrors users_role_check
+var userRoles = map[string]bool{
+	"user":      true,
+	"moderator": true,
+	"admin":     true,
+}
+
+func (m *MemoryStore) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !userRoles[arg.Role] {
+		return User{}, ErrCheckViolation
+	}
+
+	user, exists := m.users[arg.ID]
+	if !exists {
+		return User{}, sql.ErrNoRows
+	}
+
+	user.Role = arg.Role
+	user.UpdatedAt = now()
+	m.users[arg.ID] = user
+	return user, nil
+}
+
+func (m *MemoryStore) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int64
+	for _, user := range m.users {
+		if user.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) BumpTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[id]
+	if !exists {
+		return 0, sql.ErrNoRows
+	}
+
+	user.TokenVersion++
+	user.UpdatedAt = now()
+	m.users[id] = user
+	return user.TokenVersion, nil
+}
+
+func (m *MemoryStore) SetUserEmailVerified(ctx context.Context, arg SetUserEmailVerifiedParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[arg.ID]
+	if !exists || user.Email != arg.Email {
+		return 0, nil
+	}
+
+	verifiedAt := now()
+	user.EmailVerifiedAt = sql.NullTime{Time: verifiedAt, Valid: true}
+	user.UpdatedAt = verifiedAt
+	m.users[arg.ID] = user
+	return 1, nil
+}
+
+func (m *MemoryStore) CountChirpsOfUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int64
+	for _, chirp := range m.chirps {
+		if chirp.UserID == userID && !chirp.DeletedAt.Valid {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.chirps[arg.ID]; exists {
+		return Chirp{}, ErrUniqueViolation
+	}
+	if _, exists := m.users[arg.UserID]; !exists {
+		return Chirp{}, ErrForeignKeyViolation
+	}
+	if _, exists := m.chirps[arg.InReplyTo.UUID]; arg.InReplyTo.Valid && !exists {
+		return Chirp{}, ErrForeignKeyViolation
+	}
+
+	newChirp := Chirp{
+		ID:        arg.ID,
+		CreatedAt: now(),
+		UpdatedAt: arg.UpdatedAt,
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+		InReplyTo: arg.InReplyTo,
+	}
+	m.chirps[newChirp.ID] = newChirp
+
+	return newChirp, nil
+}
+
+func (m *MemoryStore) DeleteChirpPerm(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteChirp(id)
+	return nil
+}
+
+// deleteChirp removes a chirp and cascades like the schema does, m.mu must be held
+func (m *MemoryStore) deleteChirp(id uuid.UUID) {
+	delete(m.chirps, id)
+
+	for key := range m.likes {
+		if key.chirpID == id {
+			delete(m.likes, key)
+		}
+	}
+	for key := range m.rechirps {
+		if key.chirpID == id {
+			delete(m.rechirps, key)
+		}
+	}
+	for flagID, flag := range m.flags {
+		if flag.ChirpID == id {
+			delete(m.flags, flagID)
+		}
+	}
+
+	// replies lose their parent, same as ON DELETE SET NULL
+	for replyID, reply := range m.chirps {
+		if reply.InReplyTo.Valid && reply.InReplyTo.UUID == id {
+			reply.InReplyTo = uuid.NullUUID{}
+			m.chirps[replyID] = reply
+		}
+	}
+}
+
+func (m *MemoryStore) GetAllChirpsOfUserID(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if chirp.UserID == userID && !chirp.DeletedAt.Valid {
+			items = append(items, chirp)
+		}
+	}
+	sortChirpsByCreation(items)
+	return items, nil
+}
+
+func (m *MemoryStore) GetAllChirpsSinceCreation(ctx context.Context) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if !chirp.DeletedAt.Valid {
+			items = append(items, chirp)
+		}
+	}
+	sortChirpsByCreation(items)
+	return items, nil
+}
+
+func (m *MemoryStore) GetChirpViaID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirp, exists := m.chirps[id]
+	if !exists {
+		return Chirp{}, sql.ErrNoRows
+	}
+	return chirp, nil
+}
+
+func (m *MemoryStore) GetChirpsAfterCursor(ctx context.Context, arg GetChirpsAfterCursorParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := m.chirpsPage(arg.AuthorID, arg.CursorCreatedAt, arg.CursorID, func(cmp int) bool { return cmp > 0 })
+	sortChirpsByPosition(items)
+	return limitChirps(items, arg.PageLimit), nil
+}
+
+func (m *MemoryStore) GetChirpsBeforeCursor(ctx context.Context, arg GetChirpsBeforeCursorParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := m.chirpsPage(arg.AuthorID, arg.CursorCreatedAt, arg.CursorID, func(cmp int) bool { return cmp < 0 })
+	sort.Slice(items, func(i, j int) bool { return compareChirpPosition(items[i], items[j].CreatedAt, items[j].ID) > 0 })
+	return limitChirps(items, arg.PageLimit), nil
+}
+
+func (m *MemoryStore) CountChirpReplies(ctx context.Context, inReplyTo uuid.NullUUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int64
+	for _, chirp := range m.chirps {
+		if inReplyTo.Valid && chirp.InReplyTo == inReplyTo {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) TombstoneChirp(ctx context.Context, arg TombstoneChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, exists := m.chirps[arg.ID]
+	if !exists {
+		return nil
+	}
+
+	chirp.Body = ""
+	chirp.DeletedAt = arg.DeletedAt
+	chirp.UpdatedAt = arg.UpdatedAt
+	m.chirps[arg.ID] = chirp
+	return nil
+}
+
+func (m *MemoryStore) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	current, exists := m.chirps[id]
+	for exists && current.InReplyTo.Valid {
+		current, exists = m.chirps[current.InReplyTo.UUID]
+		if exists {
+			items = append(items, current)
+		}
+	}
+	sortChirpsByPosition(items)
+	return items, nil
+}
+
+func (m *MemoryStore) GetChirpDescendants(ctx context.Context, inReplyTo uuid.NullUUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !inReplyTo.Valid {
+		return nil, nil
+	}
+
+	var items []Chirp
+	parents := []uuid.UUID{inReplyTo.UUID}
+	for len(parents) > 0 {
+		var children []uuid.UUID
+		for _, chirp := range m.chirps {
+			if chirp.InReplyTo.Valid && slices.Contains(parents, chirp.InReplyTo.UUID) {
+				items = append(items, chirp)
+				children = append(children, chirp.ID)
+			}
+		}
+		parents = children
+	}
+	sortChirpsByPosition(items)
+	return items, nil
+}
+
+// SearchChirps runs the query through the search package's tokenizer
+// in place of postgres full text search
+func (m *MemoryStore) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query := search.ParseQuery(arg.Query)
+
+	var items []SearchChirpsRow
+	for _, chirp := range m.chirps {
+		if chirp.DeletedAt.Valid || (arg.AuthorID.Valid && chirp.UserID != arg.AuthorID.UUID) {
+			continue
+		}
+		if arg.Since.Valid && chirp.CreatedAt.Before(arg.Since.Time) {
+			continue
+		}
+		if arg.Until.Valid && !chirp.CreatedAt.Before(arg.Until.Time) {
+			continue
+		}
+
+		isMatch, rank := query.Match(chirp.Body)
+		if !isMatch {
+			continue
+		}
+
+		items = append(items, SearchChirpsRow{
+			ID:        chirp.ID,
+			CreatedAt: chirp.CreatedAt,
+			UpdatedAt: chirp.UpdatedAt,
+			Body:      chirp.Body,
+			UserID:    chirp.UserID,
+			InReplyTo: chirp.InReplyTo,
+			DeletedAt: chirp.DeletedAt,
+			Rank:      rank,
+			Snippet:   query.Highlight(chirp.Body),
+		})
+	}
+
+	// best match first, newest first between equal matches
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Rank != items[j].Rank {
+			return items[i].Rank > items[j].Rank
+		}
+		return comparePosition(items[i].CreatedAt, items[i].ID, items[j].CreatedAt, items[j].ID) > 0
+	})
+
+	if int(arg.PageOffset) >= len(items) {
+		return nil, nil
+	}
+	items = items[arg.PageOffset:]
+	if len(items) > int(arg.PageLimit) {
+		items = items[:arg.PageLimit]
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) FollowUser(ctx context.Context, arg FollowUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if arg.FollowerID == arg.FolloweeID {
+		return ErrCheckViolation
+	}
+	if _, exists := m.users[arg.FollowerID]; !exists {
+		return ErrForeignKeyViolation
+	}
+	if _, exists := m.users[arg.FolloweeID]; !exists {
+		return ErrForeignKeyViolation
+	}
+
+	key := followKey{followerID: arg.FollowerID, followeeID: arg.FolloweeID}
+	if _, exists := m.follows[key]; exists {
+		return nil
+	}
+
+	m.follows[key] = Follow{
+		FollowerID: arg.FollowerID,
+		FolloweeID: arg.FolloweeID,
+		CreatedAt:  now(),
+	}
+	return nil
+}
+
+func (m *MemoryStore) UnfollowUser(ctx context.Context, arg UnfollowUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.follows, followKey{followerID: arg.FollowerID, followeeID: arg.FolloweeID})
+	return nil
+}
+
+func (m *MemoryStore) GetFollowers(ctx context.Context, arg GetFollowersParams) ([]GetFollowersRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []GetFollowersRow
+	for _, follow := range m.follows {
+		if follow.FolloweeID == arg.UserID && isBeforeCursor(follow.CreatedAt, follow.FollowerID, arg.CursorCreatedAt, arg.CursorID) {
+			items = append(items, GetFollowersRow{UserID: follow.FollowerID, FollowedAt: follow.CreatedAt})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return comparePosition(items[i].FollowedAt, items[i].UserID, items[j].FollowedAt, items[j].UserID) > 0
+	})
+	if len(items) > int(arg.PageLimit) {
+		items = items[:arg.PageLimit]
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) GetFollowing(ctx context.Context, arg GetFollowingParams) ([]GetFollowingRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []GetFollowingRow
+	for _, follow := range m.follows {
+		if follow.FollowerID == arg.UserID && isBeforeCursor(follow.CreatedAt, follow.FolloweeID, arg.CursorCreatedAt, arg.CursorID) {
+			items = append(items, GetFollowingRow{UserID: follow.FolloweeID, FollowedAt: follow.CreatedAt})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return comparePosition(items[i].FollowedAt, items[i].UserID, items[j].FollowedAt, items[j].UserID) > 0
+	})
+	if len(items) > int(arg.PageLimit) {
+		items = items[:arg.PageLimit]
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) GetTimelineChirps(ctx context.Context, arg GetTimelineChirpsParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if chirp.DeletedAt.Valid {
+			continue
+		}
+		if _, follows := m.follows[followKey{followerID: arg.FollowerID, followeeID: chirp.UserID}]; !follows {
+			continue
+		}
+		if isBeforeCursor(chirp.CreatedAt, chirp.ID, arg.CursorCreatedAt, arg.CursorID) {
+			items = append(items, chirp)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return compareChirpPosition(items[i], items[j].CreatedAt, items[j].ID) > 0 })
+	return limitChirps(items, arg.PageLimit), nil
+}
+
+// engagementTargetsExist checks the foreign keys shared by likes and rechirps
+func (m *MemoryStore) engagementTargetsExist(userID, chirpID uuid.UUID) bool {
+	_, userExists := m.users[userID]
+	_, chirpExists := m.chirps[chirpID]
+	return userExists && chirpExists
+}
+
+func (m *MemoryStore) CreateChirpLike(ctx context.Context, arg CreateChirpLikeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.engagementTargetsExist(arg.UserID, arg.ChirpID) {
+		return ErrForeignKeyViolation
+	}
+
+	key := engagementKey{userID: arg.UserID, chirpID: arg.ChirpID}
+	if _, exists := m.likes[key]; !exists {
+		m.likes[key] = ChirpLike{UserID: arg.UserID, ChirpID: arg.ChirpID, CreatedAt: now()}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteChirpLike(ctx context.Context, arg DeleteChirpLikeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.likes, engagementKey{userID: arg.UserID, chirpID: arg.ChirpID})
+	return nil
+}
+
+func (m *MemoryStore) CreateRechirp(ctx context.Context, arg CreateRechirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.engagementTargetsExist(arg.UserID, arg.ChirpID) {
+		return ErrForeignKeyViolation
+	}
+
+	key := engagementKey{userID: arg.UserID, chirpID: arg.ChirpID}
+	if _, exists := m.rechirps[key]; !exists {
+		m.rechirps[key] = Rechirp{UserID: arg.UserID, ChirpID: arg.ChirpID, CreatedAt: now()}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteRechirp(ctx context.Context, arg DeleteRechirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.rechirps, engagementKey{userID: arg.UserID, chirpID: arg.ChirpID})
+	return nil
+}
+
+func (m *MemoryStore) GetChirpEngagement(ctx context.Context, arg GetChirpEngagementParams) ([]GetChirpEngagementRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []GetChirpEngagementRow
+	for _, chirpID := range arg.ChirpIds {
+		if _, exists := m.chirps[chirpID]; !exists {
+			continue
+		}
+
+		row := GetChirpEngagementRow{ChirpID: chirpID}
+		for key := range m.likes {
+			if key.chirpID == chirpID {
+				row.LikeCount++
+				row.LikedByMe = row.LikedByMe || (arg.ViewerID.Valid && key.userID == arg.ViewerID.UUID)
+			}
+		}
+		for key := range m.rechirps {
+			if key.chirpID == chirpID {
+				row.RechirpCount++
+				row.RechirpedByMe = row.RechirpedByMe || (arg.ViewerID.Valid && key.userID == arg.ViewerID.UUID)
+			}
+		}
+		items = append(items, row)
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) ListBannedWords(ctx context.Context) ([]BannedWord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []BannedWord
+	for _, word := range m.bannedWords {
+		items = append(items, word)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Word < items[j].Word })
+	return items, nil
+}
+
+func (m *MemoryStore) UpsertBannedWord(ctx context.Context, arg UpsertBannedWordParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	word, exists := m.bannedWords[arg.Word]
+	if !exists {
+		word = BannedWord{Word: arg.Word, CreatedAt: now()}
+	}
+	word.Action = arg.Action
+	m.bannedWords[arg.Word] = word
+	return nil
+}
+
+func (m *MemoryStore) DeleteBannedWord(ctx context.Context, word string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bannedWords, word)
+	return nil
+}
+
+func (m *MemoryStore) CreateModerationFlag(ctx context.Context, arg CreateModerationFlagParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.flags[arg.ID]; exists {
+		return ErrUniqueViolation
+	}
+	if _, exists := m.chirps[arg.ChirpID]; !exists {
+		return ErrForeignKeyViolation
+	}
+
+	m.flags[arg.ID] = ModerationFlag{
+		ID:        arg.ID,
+		ChirpID:   arg.ChirpID,
+		Reason:    arg.Reason,
+		CreatedAt: now(),
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListOpenModerationFlags(ctx context.Context) ([]ModerationFlag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []ModerationFlag
+	for _, flag := range m.flags {
+		if !flag.ResolvedAt.Valid {
+			items = append(items, flag)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items, nil
+}
+
+func (m *MemoryStore) ResolveModerationFlag(ctx context.Context, arg ResolveModerationFlagParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	flag, exists := m.flags[arg.ID]
+	if !exists {
+		return nil
+	}
+	flag.ResolvedAt = arg.ResolvedAt
+	m.flags[arg.ID] = flag
+	return nil
+}
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.refreshTokens[arg.ID]; exists {
+		return RefreshToken{}, ErrUniqueViolation
+	}
+	for _, refreshToken := range m.refreshTokens {
+		if refreshToken.TokenHash == arg.TokenHash {
+			return RefreshToken{}, ErrUniqueViolation
+		}
+	}
+	if _, exists := m.users[arg.UserID]; !exists {
+		return RefreshToken{}, ErrForeignKeyViolation
+	}
+
+	newToken := RefreshToken{
+		CreatedAt: now(),
+		UpdatedAt: arg.UpdatedAt,
+		ExpiresAt: arg.ExpiresAt,
+		RevokedAt: arg.RevokedAt,
+		UserID:    arg.UserID,
+		ID:        arg.ID,
+		FamilyID:  arg.FamilyID,
+		TokenHash: arg.TokenHash,
+		UserAgent: arg.UserAgent,
+		Ip:        arg.Ip,
+		LastUsedAt: sql.NullTime{
+			Time:  now(),
+			Valid: true,
+		},
+	}
+	m.refreshTokens[newToken.ID] = newToken
+
+	return newToken, nil
+}
+
+func (m *MemoryStore) GetRefreshTokenViaHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, refreshToken := range m.refreshTokens {
+		if refreshToken.TokenHash == tokenHash {
+			return refreshToken, nil
+		}
+	}
+	return RefreshToken{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) SetRefreshTokenRevoked(ctx context.Context, arg SetRefreshTokenRevokedParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	foundToken, exists := m.refreshTokens[arg.ID]
+	if !exists {
+		return nil
+	}
+
+	foundToken.RevokedAt = arg.RevokedAt
+	foundToken.UpdatedAt = arg.UpdatedAt
+	m.refreshTokens[arg.ID] = foundToken
+	return nil
+}
+
+func (m *MemoryStore) RevokeActiveRefreshToken(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	foundToken, exists := m.refreshTokens[id]
+	if !exists || foundToken.RevokedAt.Valid {
+		return 0, nil
+	}
+
+	revokedAt := now()
+	foundToken.RevokedAt = sql.NullTime{Time: revokedAt, Valid: true}
+	foundToken.UpdatedAt = revokedAt
+	m.refreshTokens[id] = foundToken
+	return 1, nil
+}
+
+func (m *MemoryStore) RevokeRefreshTokenFamily(ctx context.Context, arg RevokeRefreshTokenFamilyParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revokedCount := m.revokeRefreshTokensWhere(func(refreshToken RefreshToken) bool {
+		return refreshToken.FamilyID == arg.FamilyID && refreshToken.UserID == arg.UserID
+	})
+	return revokedCount, nil
+}
+
+func (m *MemoryStore) ListActiveRefreshTokensOfUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []RefreshToken
+	for _, refreshToken := range m.refreshTokens {
+		if refreshToken.UserID == userID && !refreshToken.RevokedAt.Valid && refreshToken.ExpiresAt.After(now()) {
+			items = append(items, refreshToken)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.After(items[j].CreatedAt)
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) RevokeAllRefreshTokensOfUser(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeRefreshTokensWhere(func(refreshToken RefreshToken) bool {
+		return refreshToken.UserID == userID
+	})
+	return nil
+}
+
+// revokeRefreshTokensWhere revokes every active token matching and counts them, m.mu must be held
+func (m *MemoryStore) revokeRefreshTokensWhere(matches func(RefreshToken) bool) int64 {
+	var revokedCount int64
+	revokedAt := now()
+	for id, refreshToken := range m.refreshTokens {
+		if matches(refreshToken) && !refreshToken.RevokedAt.Valid {
+			refreshToken.RevokedAt = sql.NullTime{Time: revokedAt, Valid: true}
+			refreshToken.UpdatedAt = revokedAt
+			m.refreshTokens[id] = refreshToken
+			revokedCount++
+		}
+	}
+	return revokedCount
+}
+
+func (m *MemoryStore) CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) (EmailVerificationToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[arg.UserID]; !exists {
+		return EmailVerificationToken{}, ErrForeignKeyViolation
+	}
+	if _, exists := m.emailTokens[arg.ID]; exists {
+		return EmailVerificationToken{}, ErrUniqueViolation
+	}
+
+	emailToken := EmailVerificationToken{
+		ID:        arg.ID,
+		UserID:    arg.UserID,
+		Email:     arg.Email,
+		CreatedAt: now(),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	m.emailTokens[arg.ID] = emailToken
+	return emailToken, nil
+}
+
+func (m *MemoryStore) UseEmailVerificationToken(ctx context.Context, id uuid.UUID) (EmailVerificationToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usedAt := now()
+	emailToken, exists := m.emailTokens[id]
+	if !exists || emailToken.UsedAt.Valid || !emailToken.ExpiresAt.After(usedAt) {
+		return EmailVerificationToken{}, sql.ErrNoRows
+	}
+
+	emailToken.UsedAt = sql.NullTime{Time: usedAt, Valid: true}
+	m.emailTokens[id] = emailToken
+	return emailToken, nil
+}
+
+func (m *MemoryStore) ListEmailVerificationTokensSince(ctx context.Context, arg ListEmailVerificationTokensSinceParams) ([]EmailVerificationToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []EmailVerificationToken
+	for _, emailToken := range m.emailTokens {
+		if emailToken.UserID == arg.UserID && emailToken.CreatedAt.After(arg.CreatedAt) {
+			items = append(items, emailToken)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.After(items[j].CreatedAt)
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) (PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[arg.UserID]; !exists {
+		return PasswordResetToken{}, ErrForeignKeyViolation
+	}
+	if _, exists := m.resetTokens[arg.ID]; exists {
+		return PasswordResetToken{}, ErrUniqueViolation
+	}
+	for _, resetToken := range m.resetTokens {
+		if resetToken.TokenHash == arg.TokenHash {
+			return PasswordResetToken{}, ErrUniqueViolation
+		}
+	}
+
+	resetToken := PasswordResetToken{
+		ID:        arg.ID,
+		TokenHash: arg.TokenHash,
+		UserID:    arg.UserID,
+		CreatedAt: now(),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	m.resetTokens[arg.ID] = resetToken
+	return resetToken, nil
+}
+
+func (m *MemoryStore) GetUsablePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	checkedAt := now()
+	for _, resetToken := range m.resetTokens {
+		if resetToken.TokenHash != tokenHash {
+			continue
+		}
+		if resetToken.UsedAt.Valid || !resetToken.ExpiresAt.After(checkedAt) {
+			return PasswordResetToken{}, sql.ErrNoRows
+		}
+		return resetToken, nil
+	}
+	return PasswordResetToken{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) UsePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usedAt := now()
+	for id, resetToken := range m.resetTokens {
+		if resetToken.TokenHash != tokenHash {
+			continue
+		}
+		if resetToken.UsedAt.Valid || !resetToken.ExpiresAt.After(usedAt) {
+			return PasswordResetToken{}, sql.ErrNoRows
+		}
+		resetToken.UsedAt = sql.NullTime{Time: usedAt, Valid: true}
+		m.resetTokens[id] = resetToken
+		return resetToken, nil
+	}
+	return PasswordResetToken{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) ExpirePasswordResetTokensOfUser(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usedAt := now()
+	for id, resetToken := range m.resetTokens {
+		if resetToken.UserID == userID && !resetToken.UsedAt.Valid {
+			resetToken.UsedAt = sql.NullTime{Time: usedAt, Valid: true}
+			m.resetTokens[id] = resetToken
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListPasswordResetTokensSince(ctx context.Context, arg ListPasswordResetTokensSinceParams) ([]PasswordResetToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []PasswordResetToken
+	for _, resetToken := range m.resetTokens {
+		if resetToken.UserID == arg.UserID && resetToken.CreatedAt.After(arg.CreatedAt) {
+			items = append(items, resetToken)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.After(items[j].CreatedAt)
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) ResetUserPassword(ctx context.Context, arg ResetUserPasswordParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[arg.ID]
+	if !exists {
+		return nil
+	}
+
+	user.HashedPassword = arg.HashedPassword
+	user.PasswordResetRequired = false
+	user.TokenVersion++
+	user.UpdatedAt = now()
+	m.users[arg.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) StartMFAEnrollment(ctx context.Context, arg StartMFAEnrollmentParams) (UserMfa, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[arg.UserID]; !exists {
+		return UserMfa{}, ErrForeignKeyViolation
+	}
+	// an enabled row is left alone, like the WHERE on the upsert
+	if existing, exists := m.mfa[arg.UserID]; exists && existing.EnabledAt.Valid {
+		return UserMfa{}, sql.ErrNoRows
+	}
+
+	userMfa := UserMfa{
+		UserID:     arg.UserID,
+		TotpSecret: arg.TotpSecret,
+		CreatedAt:  now(),
+	}
+	m.mfa[arg.UserID] = userMfa
+	return userMfa, nil
+}
+
+func (m *MemoryStore) GetUserMFA(ctx context.Context, userID uuid.UUID) (UserMfa, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userMfa, exists := m.mfa[userID]
+	if !exists {
+		return UserMfa{}, sql.ErrNoRows
+	}
+	return userMfa, nil
+}
+
+func (m *MemoryStore) EnableMFA(ctx context.Context, arg EnableMFAParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMfa, exists := m.mfa[arg.UserID]
+	if !exists || userMfa.EnabledAt.Valid {
+		return 0, nil
+	}
+	userMfa.EnabledAt = sql.NullTime{Time: now(), Valid: true}
+	userMfa.LastStep = arg.LastStep
+	m.mfa[arg.UserID] = userMfa
+	return 1, nil
+}
+
+func (m *MemoryStore) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMfa, exists := m.mfa[arg.UserID]
+	if !exists || userMfa.LastStep >= arg.LastStep {
+		return 0, nil
+	}
+	userMfa.LastStep = arg.LastStep
+	m.mfa[arg.UserID] = userMfa
+	return 1, nil
+}
+
+func (m *MemoryStore) DeleteUserMFA(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mfa, userID)
+	return nil
+}
+
+func (m *MemoryStore) CreateMFARecoveryCode(ctx context.Context, arg CreateMFARecoveryCodeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[arg.UserID]; !exists {
+		return ErrForeignKeyViolation
+	}
+	if _, exists := m.recoveryCodes[arg.ID]; exists {
+		return ErrUniqueViolation
+	}
+	for _, recoveryCode := range m.recoveryCodes {
+		if recoveryCode.UserID == arg.UserID && recoveryCode.CodeHash == arg.CodeHash {
+			return ErrUniqueViolation
+		}
+	}
+
+	m.recoveryCodes[arg.ID] = MfaRecoveryCode{
+		ID:        arg.ID,
+		UserID:    arg.UserID,
+		CodeHash:  arg.CodeHash,
+		CreatedAt: now(),
+	}
+	return nil
+}
+
+func (m *MemoryStore) UseMFARecoveryCode(ctx context.Context, arg UseMFARecoveryCodeParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, recoveryCode := range m.recoveryCodes {
+		if recoveryCode.UserID == arg.UserID && recoveryCode.CodeHash == arg.CodeHash && !recoveryCode.UsedAt.Valid {
+			recoveryCode.UsedAt = sql.NullTime{Time: now(), Valid: true}
+			m.recoveryCodes[id] = recoveryCode
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) CountUnusedMFARecoveryCodes(ctx context.Context, userID uuid.UUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int64
+	for _, recoveryCode := range m.recoveryCodes {
+		if recoveryCode.UserID == userID && !recoveryCode.UsedAt.Valid {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) DeleteMFARecoveryCodesOfUser(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, recoveryCode := range m.recoveryCodes {
+		if recoveryCode.UserID == userID {
+			delete(m.recoveryCodes, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ReserveLoginAttempt(ctx context.Context, arg ReserveLoginAttemptParams) (LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservedAt := now()
+	key := loginAttemptKey{scope: arg.Scope, subject: arg.Subject}
+	attempt, exists := m.loginAttempts[key]
+	if !exists {
+		attempt = LoginAttempt{Scope: arg.Scope, Subject: arg.Subject}
+	}
+	if attempt.BlockedUntil.Valid && attempt.BlockedUntil.Time.After(reservedAt) {
+		return LoginAttempt{}, sql.ErrNoRows
+	}
+
+	inWindow := exists && !attempt.LastFailedAt.Before(arg.WindowStart)
+	if !inWindow {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = reservedAt
+	attempt.BlockedUntil = sql.NullTime{}
+	if inWindow && attempt.Failures >= arg.LockoutFailures {
+		attempt.BlockedUntil = sql.NullTime{Time: arg.LockoutUntil, Valid: true}
+	}
+	m.loginAttempts[key] = attempt
+	return attempt, nil
+}
+
+func (m *MemoryStore) RefundLoginAttempt(ctx context.Context, arg RefundLoginAttemptParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginAttemptKey{scope: arg.Scope, subject: arg.Subject}
+	attempt, exists := m.loginAttempts[key]
+	if !exists {
+		return nil
+	}
+	attempt.Failures = max(attempt.Failures-1, 0)
+	if arg.ReservedBlockedUntil.Valid && attempt.BlockedUntil.Valid && attempt.BlockedUntil.Time.Equal(arg.ReservedBlockedUntil.Time) {
+		attempt.BlockedUntil = sql.NullTime{}
+	}
+	m.loginAttempts[key] = attempt
+	return nil
+}
+
+func (m *MemoryStore) SetLoginBlockedUntil(ctx context.Context, arg SetLoginBlockedUntilParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginAttemptKey{scope: arg.Scope, subject: arg.Subject}
+	attempt, exists := m.loginAttempts[key]
+	if !exists || !arg.BlockedUntil.Valid {
+		return nil
+	}
+	// GREATEST ignores NULL, so a block only ever gets longer
+	if !attempt.BlockedUntil.Valid || arg.BlockedUntil.Time.After(attempt.BlockedUntil.Time) {
+		attempt.BlockedUntil = arg.BlockedUntil
+	}
+	m.loginAttempts[key] = attempt
+	return nil
+}
+
+func (m *MemoryStore) GetLoginAttempt(ctx context.Context, arg GetLoginAttemptParams) (LoginAttempt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	attempt, exists := m.loginAttempts[loginAttemptKey{scope: arg.Scope, subject: arg.Subject}]
+	if !exists {
+		return LoginAttempt{}, sql.ErrNoRows
+	}
+	return attempt, nil
+}
+
+func (m *MemoryStore) ClearLoginAttempts(ctx context.Context, arg ClearLoginAttemptsParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginAttemptKey{scope: arg.Scope, subject: arg.Subject}
+	if _, exists := m.loginAttempts[key]; !exists {
+		return 0, nil
+	}
+	delete(m.loginAttempts, key)
+	return 1, nil
+}
+
+func (m *MemoryStore) ListBlockedLoginAttempts(ctx context.Context, scope string) ([]LoginAttempt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []LoginAttempt
+	cutoff := now()
+	for _, attempt := range m.loginAttempts {
+		if attempt.Scope == scope && attempt.BlockedUntil.Valid && attempt.BlockedUntil.Time.After(cutoff) {
+			items = append(items, attempt)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].BlockedUntil.Time.Equal(items[j].BlockedUntil.Time) {
+			return items[i].BlockedUntil.Time.After(items[j].BlockedUntil.Time)
+		}
+		return items[i].Subject < items[j].Subject
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) DeleteStaleLoginAttempts(ctx context.Context, lastFailedAt time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deletedCount int64
+	cutoff := now()
+	for key, attempt := range m.loginAttempts {
+		stillBlocked := attempt.BlockedUntil.Valid && attempt.BlockedUntil.Time.After(cutoff)
+		if attempt.LastFailedAt.Before(lastFailedAt) && !stillBlocked {
+			delete(m.loginAttempts, key)
+			deletedCount++
+		}
+	}
+	return deletedCount, nil
+}
+
+func (m *MemoryStore) DenyAccessToken(ctx context.Context, arg DenyAccessTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.deniedTokens[arg.Jti]; exists {
+		return nil
+	}
+	m.deniedTokens[arg.Jti] = RevokedAccessToken{Jti: arg.Jti, ExpiresAt: arg.ExpiresAt}
+	return nil
+}
+
+func (m *MemoryStore) IsAccessTokenDenied(ctx context.Context, jti string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, exists := m.deniedTokens[jti]
+	return exists, nil
+}
+
+func (m *MemoryStore) DeleteExpiredDeniedAccessTokens(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deletedCount int64
+	cutoff := now()
+	for jti, deniedToken := range m.deniedTokens {
+		if !deniedToken.ExpiresAt.After(cutoff) {
+			delete(m.deniedTokens, jti)
+			deletedCount++
+		}
+	}
+	return deletedCount, nil
+}
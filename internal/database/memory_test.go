@@ -0,0 +1,146 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func createTestUser(t *testing.T, store *database.MemoryStore, email string) database.User {
+	t.Helper()
+	newUser, err := store.CreateUser(context.Background(), database.CreateUserParams{
+		ID:             uuid.New(),
+		HashedPassword: "hashed",
+		UpdatedAt:      time.Now(),
+		Email:          email,
+	})
+	if err != nil {
+		t.Fatalf(`CreateUser failed: %v`, err)
+	}
+	return newUser
+}
+
+func TestMemoryUniqueEmail(t *testing.T) {
+	store := database.NewMemoryStore()
+	createTestUser(t, store, "walt@breakingbad.com")
+
+	_, err := store.CreateUser(context.Background(), database.CreateUserParams{
+		ID:        uuid.New(),
+		UpdatedAt: time.Now(),
+		Email:     "walt@breakingbad.com",
+	})
+	if !errors.Is(err, database.ErrUniqueViolation) {
+		t.Errorf(`CreateUser should have rejected a duplicate email: got %v`, err)
+		return
+	}
+
+	// updating to someone else's email is rejected too
+	other := createTestUser(t, store, "jesse@breakingbad.com")
+	err = store.UpdateUserCredentials(context.Background(), database.UpdateUserCredentialsParams{
+		Email: "walt@breakingbad.com",
+		ID:    other.ID,
+	})
+	if !errors.Is(err, database.ErrUniqueViolation) {
+		t.Errorf(`UpdateUserCredentials should have rejected a duplicate email: got %v`, err)
+	}
+}
+
+func TestMemoryChirpsOrderedAndMissing(t *testing.T) {
+	store := database.NewMemoryStore()
+	user := createTestUser(t, store, "walt@breakingbad.com")
+
+	var createdIDs []uuid.UUID
+	for _, body := range []string{"first", "second", "third"} {
+		newChirp, err := store.CreateChirp(context.Background(), database.CreateChirpParams{
+			ID:        uuid.New(),
+			UpdatedAt: time.Now(),
+			Body:      body,
+			UserID:    user.ID,
+		})
+		if err != nil {
+			t.Fatalf(`CreateChirp failed: %v`, err)
+		}
+		createdIDs = append(createdIDs, newChirp.ID)
+		time.Sleep(time.Millisecond)
+	}
+
+	allChirps, err := store.GetAllChirpsSinceCreation(context.Background())
+	if err != nil {
+		t.Fatalf(`GetAllChirpsSinceCreation failed: %v`, err)
+	}
+	if len(allChirps) != len(createdIDs) {
+		t.Fatalf(`GetAllChirpsSinceCreation returned %d chirps, want %d`, len(allChirps), len(createdIDs))
+	}
+	for idx, foundChirp := range allChirps {
+		if foundChirp.ID != createdIDs[idx] {
+			t.Errorf(`chirp %d out of order: got %v, want %v`, idx, foundChirp.ID, createdIDs[idx])
+		}
+	}
+
+	_, err = store.GetChirpViaID(context.Background(), uuid.New())
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf(`GetChirpViaID should return sql.ErrNoRows for an unknown chirp: got %v`, err)
+	}
+
+	// chirps need an existing author
+	_, err = store.CreateChirp(context.Background(), database.CreateChirpParams{
+		ID:     uuid.New(),
+		Body:   "orphan",
+		UserID: uuid.New(),
+	})
+	if !errors.Is(err, database.ErrForeignKeyViolation) {
+		t.Errorf(`CreateChirp should have rejected an unknown author: got %v`, err)
+	}
+}
+
+func TestMemoryRemoveAllUsersCascades(t *testing.T) {
+	store := database.NewMemoryStore()
+	user := createTestUser(t, store, "walt@breakingbad.com")
+
+	_, err := store.CreateChirp(context.Background(), database.CreateChirpParams{
+		ID:     uuid.New(),
+		Body:   "say my name",
+		UserID: user.ID,
+	})
+	if err != nil {
+		t.Fatalf(`CreateChirp failed: %v`, err)
+	}
+	_, err = store.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		ID:        uuid.New(),
+		TokenHash: "some-refresh-token-hash",
+		FamilyID:  uuid.New(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		UserID:    user.ID,
+	})
+	if err != nil {
+		t.Fatalf(`CreateRefreshToken failed: %v`, err)
+	}
+
+	err = store.RemoveAllUsers(context.Background())
+	if err != nil {
+		t.Fatalf(`RemoveAllUsers failed: %v`, err)
+	}
+
+	allChirps, _ := store.GetAllChirpsSinceCreation(context.Background())
+	if len(allChirps) != 0 {
+		t.Errorf(`chirps should have been deleted with their author, found %d`, len(allChirps))
+	}
+	_, err = store.GetRefreshTokenViaHash(context.Background(), "some-refresh-token-hash")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf(`refresh tokens should have been deleted with their user: got %v`, err)
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	if name := database.QueryName("-- name: GetUserViaEmail :one\nSELECT 1"); name != "GetUserViaEmail" {
+		t.Errorf(`QueryName returned %q, want GetUserViaEmail`, name)
+	}
+	if name := database.QueryName("SELECT 1"); name != database.UNNAMED_QUERY {
+		t.Errorf(`QueryName returned %q for a query without a name`, name)
+	}
+}
@@ -11,28 +11,119 @@ import (
 	"github.com/google/uuid"
 )
 
+type BannedWord struct {
+	Word      string
+	Action    string
+	CreatedAt time.Time
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Body      string
 	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	DeletedAt sql.NullTime
 }
 
-type RefreshToken struct {
-	Token     string
+type ChirpLike struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type EmailVerificationToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Email     string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type LoginAttempt struct {
+	Scope        string
+	Subject      string
+	Failures     int32
+	LastFailedAt time.Time
+	BlockedUntil sql.NullTime
+}
+
+type MfaRecoveryCode struct {
+	ID        uuid.UUID
 	UserID    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type ModerationFlag struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Reason     string
+	CreatedAt  time.Time
+	ResolvedAt sql.NullTime
+}
+
+type PasswordResetToken struct {
+	ID        uuid.UUID
+	TokenHash string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	UserID     uuid.UUID
+	ID         uuid.UUID
+	FamilyID   uuid.UUID
+	TokenHash  string
+	UserAgent  string
+	Ip         string
+	LastUsedAt sql.NullTime
+}
+
+type Rechirp struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type RevokedAccessToken struct {
+	Jti       string
+	ExpiresAt time.Time
 }
 
 type User struct {
-	ID             uuid.UUID
-	HashedPassword string
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	IsChirpyRed    sql.NullBool
+	ID                    uuid.UUID
+	HashedPassword        string
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	Email                 string
+	IsChirpyRed           sql.NullBool
+	Role                  string
+	SuspendedAt           sql.NullTime
+	PasswordResetRequired bool
+	TokenVersion          int32
+	EmailVerifiedAt       sql.NullTime
+}
+
+type UserMfa struct {
+	UserID     uuid.UUID
+	TotpSecret string
+	CreatedAt  time.Time
+	EnabledAt  sql.NullTime
+	LastStep   int64
 }
@@ -0,0 +1,198 @@
+package database_test
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	topLevelSelect = regexp.MustCompile(`(?i)\b(SELECT|RETURNING)\b`)
+	topLevelFrom   = regexp.MustCompile(`(?i)\bFROM\b`)
+	columnAlias    = regexp.MustCompile(`(?is)\bAS\s+(\w+)$`)
+	columnName     = regexp.MustCompile(`^[\w.]+$`)
+	countColumn    = regexp.MustCompile(`(?i)^COUNT\s*\(`)
+	existsColumn   = regexp.MustCompile(`(?i)^EXISTS\s*\(`)
+)
+
+// TestQueriesScanTheirColumns catches generated code that was edited by hand so a query
+// selects different columns than its Scan reads, which only shows up against Postgres
+func TestQueriesScanTheirColumns(t *testing.T) {
+	paths, err := filepath.Glob("*.sql.go")
+	if err != nil || len(paths) <= 0 {
+		t.Fatalf(`no generated query files found: %v`, err)
+	}
+
+	fileSet := token.NewFileSet()
+	queries := make(map[string]string)
+	var funcs []*ast.FuncDecl
+	for _, path := range paths {
+		file, err := parser.ParseFile(fileSet, path, nil, 0)
+		if err != nil {
+			t.Fatalf(`parsing %s failed: %v`, path, err)
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					value, ok := spec.(*ast.ValueSpec)
+					if !ok || decl.Tok != token.CONST || len(value.Values) != 1 {
+						continue
+					}
+					literal, ok := value.Values[0].(*ast.BasicLit)
+					if !ok || literal.Kind != token.STRING {
+						continue
+					}
+					query, err := strconv.Unquote(literal.Value)
+					if err == nil {
+						queries[value.Names[0].Name] = query
+					}
+				}
+			case *ast.FuncDecl:
+				funcs = append(funcs, decl)
+			}
+		}
+	}
+
+	checked := 0
+	for _, fn := range funcs {
+		queryName, scanned := queryAndScan(fn)
+		if len(queryName) <= 0 || scanned == nil {
+			continue
+		}
+		query, ok := queries[queryName]
+		if !ok {
+			t.Errorf(`%s runs %s, which isn't a query constant`, fn.Name.Name, queryName)
+			continue
+		}
+		checked++
+
+		columns, err := selectedColumns(query)
+		if err != nil {
+			t.Errorf(`%s: %v`, fn.Name.Name, err)
+			continue
+		}
+		if len(columns) != len(scanned) {
+			t.Errorf(`%s selects %d columns %v but scans %d %v`, fn.Name.Name, len(columns), columns, len(scanned), scanned)
+			continue
+		}
+		for i, column := range columns {
+			if !strings.EqualFold(strings.ReplaceAll(column, "_", ""), strings.ReplaceAll(scanned[i], "_", "")) {
+				t.Errorf(`%s selects %s as column %d but scans it into %s`, fn.Name.Name, column, i+1, scanned[i])
+			}
+		}
+	}
+	if checked <= 0 {
+		t.Errorf(`no queries were checked`)
+	}
+}
+
+// queryAndScan finds the query constant a generated method runs and the names it scans into
+func queryAndScan(fn *ast.FuncDecl) (string, []string) {
+	queryName := ""
+	var scanned []string
+	ast.Inspect(fn, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		switch selector.Sel.Name {
+		case "QueryContext", "QueryRowContext":
+			if len(call.Args) >= 2 {
+				if ident, ok := call.Args[1].(*ast.Ident); ok {
+					queryName = ident.Name
+				}
+			}
+		case "Scan":
+			scanned = []string{}
+			for _, arg := range call.Args {
+				unary, ok := arg.(*ast.UnaryExpr)
+				if !ok {
+					scanned = append(scanned, "?")
+					continue
+				}
+				switch target := unary.X.(type) {
+				case *ast.SelectorExpr:
+					scanned = append(scanned, target.Sel.Name)
+				case *ast.Ident:
+					scanned = append(scanned, target.Name)
+				default:
+					scanned = append(scanned, "?")
+				}
+			}
+		}
+		return true
+	})
+	return queryName, scanned
+}
+
+// selectedColumns names the columns of the last top-level SELECT or RETURNING list
+func selectedColumns(query string) ([]string, error) {
+	_, query, _ = strings.Cut(query, "\n")
+
+	// blank out anything inside parentheses or quotes so only the top level is searched
+	flat := []byte(query)
+	depth := 0
+	quoted := false
+	for i, char := range flat {
+		switch {
+		case char == '\'':
+			quoted = !quoted
+		case quoted:
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+			continue
+		}
+		if quoted || depth > 0 || char == '\'' {
+			flat[i] = ' '
+		}
+	}
+
+	starts := topLevelSelect.FindAllIndex(flat, -1)
+	if len(starts) <= 0 {
+		return nil, errors.New("scans a query with no SELECT or RETURNING")
+	}
+	start := starts[len(starts)-1][1]
+	end := len(flat)
+	from := topLevelFrom.FindIndex(flat[start:])
+	if from != nil {
+		end = start + from[0]
+	}
+
+	var columns []string
+	columnStart := start
+	for i := start; i <= end; i++ {
+		if i < end && flat[i] != ',' {
+			continue
+		}
+		expression := strings.TrimSpace(query[columnStart:i])
+		columnStart = i + 1
+
+		switch {
+		case columnAlias.MatchString(expression):
+			columns = append(columns, columnAlias.FindStringSubmatch(expression)[1])
+		case columnName.MatchString(expression):
+			columns = append(columns, expression[strings.LastIndex(expression, ".")+1:])
+		case countColumn.MatchString(expression):
+			columns = append(columns, "count")
+		case existsColumn.MatchString(expression):
+			columns = append(columns, "exists")
+		default:
+			return nil, fmt.Errorf("can't name the selected column %q", expression)
+		}
+	}
+	return columns, nil
+}
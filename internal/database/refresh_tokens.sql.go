@@ -14,79 +14,188 @@ import (
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, expires_at, revoked_at, user_id)
+INSERT INTO refresh_tokens (id, token_hash, family_id, created_at, updated_at, expires_at, revoked_at, user_id, user_agent, ip, last_used_at)
 VALUES(
     $1,
-    NOW(),
     $2,
-    $3, 
+    $3,
+    NOW(),
     $4,
-    $5
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    NOW()
 )
-RETURNING token, created_at, updated_at, expires_at, revoked_at, user_id
+RETURNING created_at, updated_at, expires_at, revoked_at, user_id, id, family_id, token_hash, user_agent, ip, last_used_at
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string
+	ID        uuid.UUID
+	TokenHash string
+	FamilyID  uuid.UUID
 	UpdatedAt time.Time
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
 	UserID    uuid.UUID
+	UserAgent string
+	Ip        string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
 	row := q.db.QueryRowContext(ctx, createRefreshToken,
-		arg.Token,
+		arg.ID,
+		arg.TokenHash,
+		arg.FamilyID,
 		arg.UpdatedAt,
 		arg.ExpiresAt,
 		arg.RevokedAt,
 		arg.UserID,
+		arg.UserAgent,
+		arg.Ip,
 	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.UserID,
+		&i.ID,
+		&i.FamilyID,
+		&i.TokenHash,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
 
-const getUserViaRefreshToken = `-- name: GetUserViaRefreshToken :one
-SELECT token, created_at, updated_at, expires_at, revoked_at, user_id
+const getRefreshTokenViaHash = `-- name: GetRefreshTokenViaHash :one
+SELECT created_at, updated_at, expires_at, revoked_at, user_id, id, family_id, token_hash, user_agent, ip, last_used_at
 FROM refresh_tokens
-WHERE token = $1
+WHERE token_hash = $1
 `
 
-func (q *Queries) GetUserViaRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getUserViaRefreshToken, token)
+func (q *Queries) GetRefreshTokenViaHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenViaHash, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.UserID,
+		&i.ID,
+		&i.FamilyID,
+		&i.TokenHash,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
 
+const listActiveRefreshTokensOfUser = `-- name: ListActiveRefreshTokensOfUser :many
+SELECT created_at, updated_at, expires_at, revoked_at, user_id, id, family_id, token_hash, user_agent, ip, last_used_at
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListActiveRefreshTokensOfUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveRefreshTokensOfUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.UserID,
+			&i.ID,
+			&i.FamilyID,
+			&i.TokenHash,
+			&i.UserAgent,
+			&i.Ip,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeActiveRefreshToken = `-- name: RevokeActiveRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeActiveRefreshToken(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeActiveRefreshToken, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeAllRefreshTokensOfUser = `-- name: RevokeAllRefreshTokensOfUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllRefreshTokensOfUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllRefreshTokensOfUser, userID)
+	return err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeRefreshTokenFamilyParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, arg RevokeRefreshTokenFamilyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const setRefreshTokenRevoked = `-- name: SetRefreshTokenRevoked :exec
 UPDATE refresh_tokens
 SET revoked_at = $1, updated_at = $2
-WHERE token = $3
+WHERE id = $3
 `
 
 type SetRefreshTokenRevokedParams struct {
 	RevokedAt sql.NullTime
 	UpdatedAt time.Time
-	Token     string
+	ID        uuid.UUID
 }
 
 func (q *Queries) SetRefreshTokenRevoked(ctx context.Context, arg SetRefreshTokenRevokedParams) error {
-	_, err := q.db.ExecContext(ctx, setRefreshTokenRevoked, arg.RevokedAt, arg.UpdatedAt, arg.Token)
+	_, err := q.db.ExecContext(ctx, setRefreshTokenRevoked, arg.RevokedAt, arg.UpdatedAt, arg.ID)
 	return err
 }
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store is every query the API runs against its storage.
+// *Queries satisfies it against Postgres and *MemoryStore satisfies it in memory
+type Store interface {
+	// users
+	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	GetUserViaEmail(ctx context.Context, email string) (User, error)
+	GetUserViaID(ctx context.Context, id uuid.UUID) (User, error)
+	RemoveAllUsers(ctx context.Context) error
+	UpdateUserCredentials(ctx context.Context, arg UpdateUserCredentialsParams) error
+	UpgradeToChirpyRedViaID(ctx context.Context, id uuid.UUID) error
+	SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error)
+	CountUsersWithRole(ctx context.Context, role string) (int64, error)
+	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	SetUserSuspended(ctx context.Context, arg SetUserSuspendedParams) error
+	SetPasswordResetRequired(ctx context.Context, arg SetPasswordResetRequiredParams) error
+	DeleteUser(ctx context.Context, id uuid.UUID) (int64, error)
+	BumpTokenVersion(ctx context.Context, id uuid.UUID) (int32, error)
+	SetUserEmailVerified(ctx context.Context, arg SetUserEmailVerifiedParams) (int64, error)
+	RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error)
+
+	// chirps
+	CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error)
+	DeleteChirpPerm(ctx context.Context, id uuid.UUID) error
+	GetAllChirpsOfUserID(ctx context.Context, userID uuid.UUID) ([]Chirp, error)
+	GetAllChirpsSinceCreation(ctx context.Context) ([]Chirp, error)
+	GetChirpViaID(ctx context.Context, id uuid.UUID) (Chirp, error)
+	GetChirpsAfterCursor(ctx context.Context, arg GetChirpsAfterCursorParams) ([]Chirp, error)
+	GetChirpsBeforeCursor(ctx context.Context, arg GetChirpsBeforeCursorParams) ([]Chirp, error)
+	CountChirpReplies(ctx context.Context, inReplyTo uuid.NullUUID) (int64, error)
+	CountChirpsOfUser(ctx context.Context, userID uuid.UUID) (int64, error)
+	TombstoneChirp(ctx context.Context, arg TombstoneChirpParams) error
+	GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error)
+	GetChirpDescendants(ctx context.Context, inReplyTo uuid.NullUUID) ([]Chirp, error)
+	SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error)
+
+	// follows
+	FollowUser(ctx context.Context, arg FollowUserParams) error
+	UnfollowUser(ctx context.Context, arg UnfollowUserParams) error
+	GetFollowers(ctx context.Context, arg GetFollowersParams) ([]GetFollowersRow, error)
+	GetFollowing(ctx context.Context, arg GetFollowingParams) ([]GetFollowingRow, error)
+	GetTimelineChirps(ctx context.Context, arg GetTimelineChirpsParams) ([]Chirp, error)
+
+	// likes and rechirps
+	CreateChirpLike(ctx context.Context, arg CreateChirpLikeParams) error
+	DeleteChirpLike(ctx context.Context, arg DeleteChirpLikeParams) error
+	CreateRechirp(ctx context.Context, arg CreateRechirpParams) error
+	DeleteRechirp(ctx context.Context, arg DeleteRechirpParams) error
+	GetChirpEngagement(ctx context.Context, arg GetChirpEngagementParams) ([]GetChirpEngagementRow, error)
+
+	// moderation
+	ListBannedWords(ctx context.Context) ([]BannedWord, error)
+	UpsertBannedWord(ctx context.Context, arg UpsertBannedWordParams) error
+	DeleteBannedWord(ctx context.Context, word string) error
+	CreateModerationFlag(ctx context.Context, arg CreateModerationFlagParams) error
+	ListOpenModerationFlags(ctx context.Context) ([]ModerationFlag, error)
+	ResolveModerationFlag(ctx context.Context, arg ResolveModerationFlagParams) error
+
+	// refresh tokens
+	CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error)
+	GetRefreshTokenViaHash(ctx context.Context, tokenHash string) (RefreshToken, error)
+	SetRefreshTokenRevoked(ctx context.Context, arg SetRefreshTokenRevokedParams) error
+	RevokeActiveRefreshToken(ctx context.Context, id uuid.UUID) (int64, error)
+	RevokeRefreshTokenFamily(ctx context.Context, arg RevokeRefreshTokenFamilyParams) (int64, error)
+	ListActiveRefreshTokensOfUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error)
+	RevokeAllRefreshTokensOfUser(ctx context.Context, userID uuid.UUID) error
+
+	// email verification
+	CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) (EmailVerificationToken, error)
+	UseEmailVerificationToken(ctx context.Context, id uuid.UUID) (EmailVerificationToken, error)
+	ListEmailVerificationTokensSince(ctx context.Context, arg ListEmailVerificationTokensSinceParams) ([]EmailVerificationToken, error)
+
+	// password resets
+	CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) (PasswordResetToken, error)
+	GetUsablePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error)
+	UsePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error)
+	ExpirePasswordResetTokensOfUser(ctx context.Context, userID uuid.UUID) error
+	ListPasswordResetTokensSince(ctx context.Context, arg ListPasswordResetTokensSinceParams) ([]PasswordResetToken, error)
+	ResetUserPassword(ctx context.Context, arg ResetUserPasswordParams) error
+
+	// multi-factor authentication
+	StartMFAEnrollment(ctx context.Context, arg StartMFAEnrollmentParams) (UserMfa, error)
+	GetUserMFA(ctx context.Context, userID uuid.UUID) (UserMfa, error)
+	EnableMFA(ctx context.Context, arg EnableMFAParams) (int64, error)
+	UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error)
+	DeleteUserMFA(ctx context.Context, userID uuid.UUID) error
+	CreateMFARecoveryCode(ctx context.Context, arg CreateMFARecoveryCodeParams) error
+	UseMFARecoveryCode(ctx context.Context, arg UseMFARecoveryCodeParams) (int64, error)
+	CountUnusedMFARecoveryCodes(ctx context.Context, userID uuid.UUID) (int64, error)
+	DeleteMFARecoveryCodesOfUser(ctx context.Context, userID uuid.UUID) error
+
+	// failed logins
+	ReserveLoginAttempt(ctx context.Context, arg ReserveLoginAttemptParams) (LoginAttempt, error)
+	RefundLoginAttempt(ctx context.Context, arg RefundLoginAttemptParams) error
+	SetLoginBlockedUntil(ctx context.Context, arg SetLoginBlockedUntilParams) error
+	GetLoginAttempt(ctx context.Context, arg GetLoginAttemptParams) (LoginAttempt, error)
+	ClearLoginAttempts(ctx context.Context, arg ClearLoginAttemptsParams) (int64, error)
+	ListBlockedLoginAttempts(ctx context.Context, scope string) ([]LoginAttempt, error)
+	DeleteStaleLoginAttempts(ctx context.Context, lastFailedAt time.Time) (int64, error)
+
+	// access token denylist
+	DenyAccessToken(ctx context.Context, arg DenyAccessTokenParams) error
+	IsAccessTokenDenied(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredDeniedAccessTokens(ctx context.Context) (int64, error)
+}
+
+var _ Store = (*Queries)(nil)
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// UNNAMED_QUERY is what TimedDBTX reports for SQL that didn't come from sqlc
+const UNNAMED_QUERY = "unnamed"
+
+// TimedDBTX reports how long each query takes, named after its sqlc "-- name:" comment
+type TimedDBTX struct {
+	db      DBTX
+	observe func(query string, duration time.Duration)
+}
+
+func NewTimedDBTX(db DBTX, observe func(query string, duration time.Duration)) *TimedDBTX {
+	return &TimedDBTX{db: db, observe: observe}
+}
+
+func (timed *TimedDBTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer timed.track(query, time.Now())
+	return timed.db.ExecContext(ctx, query, args...)
+}
+
+func (timed *TimedDBTX) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	defer timed.track(query, time.Now())
+	return timed.db.PrepareContext(ctx, query)
+}
+
+func (timed *TimedDBTX) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer timed.track(query, time.Now())
+	return timed.db.QueryContext(ctx, query, args...)
+}
+
+func (timed *TimedDBTX) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer timed.track(query, time.Now())
+	return timed.db.QueryRowContext(ctx, query, args...)
+}
+
+func (timed *TimedDBTX) track(query string, start time.Time) {
+	timed.observe(QueryName(query), time.Since(start))
+}
+
+// QueryName pulls GetUserViaEmail out of "-- name: GetUserViaEmail :one"
+func QueryName(query string) string {
+	rest, found := strings.CutPrefix(query, "-- name: ")
+	if !found {
+		return UNNAMED_QUERY
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
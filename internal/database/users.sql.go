@@ -7,11 +7,39 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const bumpTokenVersion = `-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1
+RETURNING token_version
+`
+
+func (q *Queries) BumpTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, bumpTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const countUsersWithRole = `-- name: CountUsersWithRole :one
+SELECT COUNT(*)
+FROM users
+WHERE role = $1
+`
+
+func (q *Queries) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersWithRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, hashed_password, created_at, updated_at, email)
 VALUES(
@@ -21,7 +49,7 @@ VALUES(
     $3,
     $4
 )
-RETURNING id, hashed_password, created_at, updated_at, email, is_chirpy_red
+RETURNING id, hashed_password, created_at, updated_at, email, is_chirpy_red, role, suspended_at, password_reset_required, token_version, email_verified_at
 `
 
 type CreateUserParams struct {
@@ -46,12 +74,30 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.IsChirpyRed,
+		&i.Role,
+		&i.SuspendedAt,
+		&i.PasswordResetRequired,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getUserViaEmail = `-- name: GetUserViaEmail :one
-SELECT id, hashed_password, created_at, updated_at, email, is_chirpy_red
+SELECT id, hashed_password, created_at, updated_at, email, is_chirpy_red, role, suspended_at, password_reset_required, token_version, email_verified_at
 FROM users
 WHERE email = $1
 `
@@ -66,10 +112,116 @@ func (q *Queries) GetUserViaEmail(ctx context.Context, email string) (User, erro
 		&i.UpdatedAt,
 		&i.Email,
 		&i.IsChirpyRed,
+		&i.Role,
+		&i.SuspendedAt,
+		&i.PasswordResetRequired,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
+const getUserViaID = `-- name: GetUserViaID :one
+SELECT id, hashed_password, created_at, updated_at, email, is_chirpy_red, role, suspended_at, password_reset_required, token_version, email_verified_at
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserViaID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserViaID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.HashedPassword,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.SuspendedAt,
+		&i.PasswordResetRequired,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT id, hashed_password, created_at, updated_at, email, is_chirpy_red, role, suspended_at, password_reset_required, token_version, email_verified_at
+FROM users
+WHERE ($1::text IS NULL OR strpos(lower(email), lower($1::text)) > 0)
+AND ($2::timestamp IS NULL OR (created_at, id) < ($2::timestamp, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListUsersParams struct {
+	EmailSearch     sql.NullString
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers,
+		arg.EmailSearch,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.HashedPassword,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.IsChirpyRed,
+			&i.Role,
+			&i.SuspendedAt,
+			&i.PasswordResetRequired,
+			&i.TokenVersion,
+			&i.EmailVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = $1
+WHERE id = $2 AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	NewHashedPassword string
+	ID                uuid.UUID
+	OldHashedPassword string
+}
+
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rehashUserPassword, arg.NewHashedPassword, arg.ID, arg.OldHashedPassword)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const removeAllUsers = `-- name: RemoveAllUsers :exec
 DELETE FROM users
 `
@@ -79,9 +231,73 @@ func (q *Queries) RemoveAllUsers(ctx context.Context) error {
 	return err
 }
 
+const setPasswordResetRequired = `-- name: SetPasswordResetRequired :exec
+UPDATE users
+SET password_reset_required = $1, updated_at = NOW()
+WHERE id = $2
+`
+
+type SetPasswordResetRequiredParams struct {
+	PasswordResetRequired bool
+	ID                    uuid.UUID
+}
+
+func (q *Queries) SetPasswordResetRequired(ctx context.Context, arg SetPasswordResetRequiredParams) error {
+	_, err := q.db.ExecContext(ctx, setPasswordResetRequired, arg.PasswordResetRequired, arg.ID)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users
+SET role = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING id, hashed_password, created_at, updated_at, email, is_chirpy_red, role, suspended_at, password_reset_required, token_version, email_verified_at
+`
+
+type SetUserRoleParams struct {
+	Role string
+	ID   uuid.UUID
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.Role, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.HashedPassword,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.SuspendedAt,
+		&i.PasswordResetRequired,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const setUserSuspended = `-- name: SetUserSuspended :exec
+UPDATE users
+SET suspended_at = $1, updated_at = NOW()
+WHERE id = $2
+`
+
+type SetUserSuspendedParams struct {
+	SuspendedAt sql.NullTime
+	ID          uuid.UUID
+}
+
+func (q *Queries) SetUserSuspended(ctx context.Context, arg SetUserSuspendedParams) error {
+	_, err := q.db.ExecContext(ctx, setUserSuspended, arg.SuspendedAt, arg.ID)
+	return err
+}
+
 const updateUserCredentials = `-- name: UpdateUserCredentials :exec
 UPDATE users
-SET email = $1, hashed_password = $2
+SET email = $1, hashed_password = $2, password_reset_required = FALSE, token_version = token_version + 1,
+    email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
 WHERE id = $3
 `
 
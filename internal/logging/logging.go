@@ -0,0 +1,190 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const FORMAT_TEXT = "text"
+const FORMAT_JSON = "json"
+
+// REDACTED replaces the value of anything that looks like a credential
+const REDACTED = "[REDACTED]"
+
+// MAX_REQUEST_ID_LENGTH stops clients from stuffing huge values into every log line
+const MAX_REQUEST_ID_LENGTH = 128
+
+var ErrInvalidFormat = errors.New("error: log format must be text or json")
+
+// sensitiveKeys are attribute names whose values never reach the logs.
+// Keys are compared lowercased, with dashes treated like underscores
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set_cookie":    true,
+	"x_api_key":     true,
+	"api_key":       true,
+	"password":      true,
+	"new_password":  true,
+	"secret":        true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// IsSensitiveKey reports whether an attribute or header with this name could hold a credential
+func IsSensitiveKey(key string) bool {
+	normalized := strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	if sensitiveKeys[normalized] {
+		return true
+	}
+	return strings.HasSuffix(normalized, "_password") || strings.HasSuffix(normalized, "_token") || strings.HasSuffix(normalized, "_secret")
+}
+
+// ParseLevel turns debug, info, warn or error into a slog level
+func ParseLevel(raw string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(raw))
+	if err != nil {
+		return 0, fmt.Errorf("error: log level must be debug, info, warn or error, got %q", raw)
+	}
+	return level, nil
+}
+
+// New builds a logger writing text or JSON to out. Credentials are redacted
+// and the request id from the context is added to every record
+func New(out io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case FORMAT_TEXT:
+		handler = slog.NewTextHandler(out, options)
+	case FORMAT_JSON:
+		handler = slog.NewJSONHandler(out, options)
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if IsSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, REDACTED)
+	}
+
+	// catches credentials logged under a harmless name
+	if attr.Value.Kind() == slog.KindString {
+		value := attr.Value.String()
+		if strings.HasPrefix(value, "Bearer ") || strings.HasPrefix(value, "ApiKey ") {
+			return slog.String(attr.Key, REDACTED)
+		}
+	}
+	return attr
+}
+
+// Headers logs request headers as a group, each one redacted by name like any other attribute
+func Headers(headers http.Header) slog.Attr {
+	attrs := make([]any, 0, len(headers))
+	for name, values := range headers {
+		attrs = append(attrs, slog.String(name, strings.Join(values, ", ")))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// contextHandler copies request details from the context onto each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (handler *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); len(id) > 0 {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return handler.Handler.Handle(ctx, record)
+}
+
+func (handler *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: handler.Handler.WithAttrs(attrs)}
+}
+
+func (handler *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: handler.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+type requestInfoKey struct{}
+
+// RequestInfo collects what handlers learn about a request so the access log can report it
+type RequestInfo struct {
+	mu     sync.Mutex
+	userID uuid.UUID
+}
+
+// UserID returns who made the request, or uuid.Nil if nobody logged in
+func (info *RequestInfo) UserID() uuid.UUID {
+	if info == nil {
+		return uuid.Nil
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return info.userID
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id set by WithRequestID, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// CleanRequestID keeps a client supplied X-Request-ID only if it's short and plain,
+// otherwise it makes up a new one
+func CleanRequestID(raw string) string {
+	if len(raw) <= 0 || len(raw) > MAX_REQUEST_ID_LENGTH {
+		return uuid.NewString()
+	}
+	for _, char := range raw {
+		isPlain := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') || strings.ContainsRune("-_.:", char)
+		if !isPlain {
+			return uuid.NewString()
+		}
+	}
+	return raw
+}
+
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// GetRequestInfo returns the info set by WithRequestInfo, or nil
+func GetRequestInfo(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// SetUserID records who made the request, if the access log is listening
+func SetUserID(ctx context.Context, userID uuid.UUID) {
+	info := GetRequestInfo(ctx)
+	if info == nil {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.userID = userID
+}
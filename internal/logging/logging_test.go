@@ -0,0 +1,72 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/CzarRamos/chirpy/internal/logging"
+)
+
+func TestRedaction(t *testing.T) {
+	var output bytes.Buffer
+	logger, err := logging.New(&output, slog.LevelDebug, logging.FORMAT_JSON)
+	if err != nil {
+		t.Fatalf(`New failed: %v`, err)
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer abc.def.ghi")
+	headers.Set("User-Agent", "curl")
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	logger.InfoContext(ctx, "login",
+		"password", "hunter2",
+		"refresh_token", "deadbeef",
+		"note", "Bearer sneaky",
+		"email", "walt@breakingbad.com",
+		logging.Headers(headers),
+	)
+
+	if strings.Contains(output.String(), "hunter2") || strings.Contains(output.String(), "deadbeef") ||
+		strings.Contains(output.String(), "abc.def.ghi") || strings.Contains(output.String(), "sneaky") {
+		t.Fatalf(`credentials leaked into the log: %s`, output.String())
+	}
+
+	record := map[string]any{}
+	err = json.Unmarshal(output.Bytes(), &record)
+	if err != nil {
+		t.Fatalf(`log line is not JSON: %v`, err)
+	}
+	if record["request_id"] != "req-1" || record["email"] != "walt@breakingbad.com" {
+		t.Errorf(`log line is missing attributes: %v`, record)
+	}
+	if headers, _ := record["headers"].(map[string]any); headers["User-Agent"] != "curl" || headers["Authorization"] != logging.REDACTED {
+		t.Errorf(`headers were not logged right: %v`, record["headers"])
+	}
+}
+
+func TestCleanRequestID(t *testing.T) {
+	if id := logging.CleanRequestID("abc-123_x.y:z"); id != "abc-123_x.y:z" {
+		t.Errorf(`plain request id should be kept, got %q`, id)
+	}
+	for _, raw := range []string{"", "has space", "new\nline", strings.Repeat("a", 200)} {
+		if id := logging.CleanRequestID(raw); id == raw || len(id) <= 0 {
+			t.Errorf(`request id %q should be replaced, got %q`, raw, id)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := logging.ParseLevel("warn")
+	if err != nil || level != slog.LevelWarn {
+		t.Errorf(`ParseLevel("warn") = %v %v`, level, err)
+	}
+	if _, err := logging.ParseLevel("loud"); err == nil {
+		t.Errorf(`ParseLevel should reject unknown levels`)
+	}
+}
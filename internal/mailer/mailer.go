@@ -0,0 +1,140 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MAILER_LOG = "log"
+const MAILER_FILE = "file"
+const MAILER_SMTP = "smtp"
+
+// MAX_EMAIL_LENGTH is the longest address SMTP can carry, RFC 5321 caps the path at 254
+const MAX_EMAIL_LENGTH = 254
+
+var ErrInvalidEmail = errors.New("error: email must be a plain address like someone@example.com")
+
+// ValidateEmail accepts a bare address with a dotted domain. Display names, comments
+// and anything net/mail would have to rewrite are rejected so what we store is what we mail
+func ValidateEmail(email string) error {
+	if len(email) <= 0 || len(email) > MAX_EMAIL_LENGTH {
+		return ErrInvalidEmail
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return ErrInvalidEmail
+	}
+
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// format writes a message as plain text headers and body. Header values lose any
+// line breaks so a subject or address can't smuggle in headers of its own
+func format(from string, message Message, date time.Time) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&builder, "To: %s\r\n", headerValue(message.To))
+	fmt.Fprintf(&builder, "Subject: %s\r\n", headerValue(message.Subject))
+	fmt.Fprintf(&builder, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	builder.WriteString("\r\n")
+	return builder.String()
+}
+
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// LogMailer only logs who would have been mailed. The body stays out of the
+// logs since it usually holds a token
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+func (mailer *LogMailer) Send(ctx context.Context, message Message) error {
+	logger := mailer.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.InfoContext(ctx, "email not sent, log mailer", "to", message.To, "subject", message.Subject)
+	return nil
+}
+
+// FileMailer appends every message to a file, handy for reading tokens during development
+type FileMailer struct {
+	From string
+	Path string
+
+	mu sync.Mutex
+}
+
+func (mailer *FileMailer) Send(ctx context.Context, message Message) error {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+
+	file, err := os.OpenFile(mailer.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.WriteString(file, format(mailer.From, message, time.Now())+"\r\n")
+	return err
+}
+
+// SMTPMailer sends through a relay, logging in with PLAIN auth when a username is set.
+// net/smtp upgrades to TLS when the server offers it and refuses PLAIN auth without it,
+// except to localhost
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (mailer *SMTPMailer) Send(ctx context.Context, message Message) error {
+	err := ValidateEmail(message.To)
+	if err != nil {
+		return err
+	}
+
+	var smtpAuth smtp.Auth
+	if len(mailer.Username) > 0 {
+		host, _, err := net.SplitHostPort(mailer.Addr)
+		if err != nil {
+			return err
+		}
+		smtpAuth = smtp.PlainAuth("", mailer.Username, mailer.Password, host)
+	}
+
+	return smtp.SendMail(mailer.Addr, smtpAuth, mailer.From, []string{message.To}, []byte(format(mailer.From, message, time.Now())))
+}
@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateEmail(t *testing.T) {
+	cases := []struct {
+		email string
+		valid bool
+	}{
+		{"someone@example.com", true},
+		{"first.last+tag@mail.example.co.uk", true},
+		{"", false},
+		{"someone", false},
+		{"someone@localhost", false},
+		{"someone@example.", false},
+		{"@example.com", false},
+		{"Someone <someone@example.com>", false},
+		{"someone@example.com (work)", false},
+		{" someone@example.com", false},
+		{"some one@example.com", false},
+		{"someone@example.com\r\nBcc: victim@example.com", false},
+		{strings.Repeat("a", MAX_EMAIL_LENGTH) + "@example.com", false},
+	}
+
+	for _, c := range cases {
+		err := ValidateEmail(c.email)
+		if (err == nil) != c.valid {
+			t.Errorf(`ValidateEmail(%q) = %v, want valid %v`, c.email, err, c.valid)
+		}
+	}
+}
+
+func TestFormatStripsHeaderBreaks(t *testing.T) {
+	message := Message{
+		To:      "someone@example.com",
+		Subject: "Hello\r\nBcc: victim@example.com",
+		Body:    "line one\nline two",
+	}
+
+	formatted := format("chirpy@example.com", message, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	headers, body, found := strings.Cut(formatted, "\r\n\r\n")
+	if !found {
+		t.Fatalf(`formatted message has no blank line between headers and body: %q`, formatted)
+	}
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Errorf(`a subject should not be able to add headers: %q`, headers)
+	}
+	if body != "line one\r\nline two\r\n" {
+		t.Errorf(`body lines should end in CRLF, got %q`, body)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	mailer := &FileMailer{From: "chirpy@example.com", Path: path}
+
+	for _, subject := range []string{"first", "second"} {
+		err := mailer.Send(context.Background(), Message{To: "someone@example.com", Subject: subject, Body: "hi"})
+		if err != nil {
+			t.Fatalf(`Send failed: %v`, err)
+		}
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf(`error reading mail file: %v`, err)
+	}
+	if !strings.Contains(string(written), "Subject: first") || !strings.Contains(string(written), "Subject: second") {
+		t.Errorf(`FileMailer should append every message, got %q`, written)
+	}
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+const LOGIN_SUCCESS = "success"
+const LOGIN_FAILURE = "failure"
+
+// UNMATCHED_ROUTE labels requests no route matched, so random paths can't blow up the label count
+const UNMATCHED_ROUTE = "unmatched"
+
+// Metrics is everything chirpy measures. A nil *Metrics records nothing,
+// which keeps handlers simple when metrics aren't wired up
+type Metrics struct {
+	Registry        *Registry
+	Requests        *CounterVec
+	RequestDuration *HistogramVec
+	InFlight        *Gauge
+	QueryDuration   *HistogramVec
+	Logins          *CounterVec
+	ChirpsCreated   *CounterVec
+}
+
+func New() *Metrics {
+	registry := NewRegistry()
+	return &Metrics{
+		Registry:        registry,
+		Requests:        registry.NewCounterVec("chirpy_http_requests_total", "HTTP requests handled, by route, method and status class.", "route", "method", "status"),
+		RequestDuration: registry.NewHistogramVec("chirpy_http_request_duration_seconds", "Time spent handling HTTP requests.", DEFAULT_BUCKETS, "route", "method"),
+		InFlight:        registry.NewGauge("chirpy_http_requests_in_flight", "HTTP requests currently being handled."),
+		QueryDuration:   registry.NewHistogramVec("chirpy_db_query_duration_seconds", "Time spent running database queries, by query name.", DEFAULT_BUCKETS, "query"),
+		Logins:          registry.NewCounterVec("chirpy_logins_total", "Login attempts, by result.", "result"),
+		ChirpsCreated:   registry.NewCounterVec("chirpy_chirps_created_total", "Chirps created."),
+	}
+}
+
+// StatusClass turns 404 into "4xx"
+func StatusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	if len(route) <= 0 {
+		route = UNMATCHED_ROUTE
+	}
+	m.Requests.Inc(route, method, StatusClass(status))
+	m.RequestDuration.Observe(duration.Seconds(), route, method)
+}
+
+func (m *Metrics) ObserveQuery(query string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.QueryDuration.Observe(duration.Seconds(), query)
+}
+
+func (m *Metrics) ObserveLogin(success bool) {
+	if m == nil {
+		return
+	}
+	if success {
+		m.Logins.Inc(LOGIN_SUCCESS)
+		return
+	}
+	m.Logins.Inc(LOGIN_FAILURE)
+}
+
+func (m *Metrics) ObserveChirpCreated() {
+	if m == nil {
+		return
+	}
+	m.ChirpsCreated.Inc()
+}
@@ -0,0 +1,343 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CONTENT_TYPE is the Prometheus text exposition format, version 0.0.4
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// DEFAULT_BUCKETS are latency buckets in seconds, the same ones the Prometheus client libraries use
+var DEFAULT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is anything that can write itself out in the text format
+type collector interface {
+	write(w io.Writer) error
+}
+
+// Registry holds every metric the server exposes, written out in the order they were registered
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) register(c collector) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors = append(registry.collectors, c)
+}
+
+// WritePrometheus writes every registered metric in the Prometheus text format
+func (registry *Registry) WritePrometheus(w io.Writer) error {
+	registry.mu.Lock()
+	collectors := append([]collector(nil), registry.collectors...)
+	registry.mu.Unlock()
+
+	for _, c := range collectors {
+		err := c.write(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// desc is what every metric has: a name, help text and the names of its labels
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+func (d desc) writeHeader(w io.Writer, metricType string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, metricType)
+	return err
+}
+
+// labelKey joins label values so they can key a map, \xff never shows up in valid UTF-8
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels renders {name="value",...}, with extra appended for things like le
+func (d desc) formatLabels(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+	for idx, name := range d.labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[idx])))
+	}
+	for idx := 0; idx+1 < len(extra); idx += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[idx], escapeLabel(extra[idx+1])))
+	}
+	if len(pairs) <= 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d desc) checkLabels(values []string) {
+	if len(values) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s wants %d label values, got %d", d.name, len(d.labelNames), len(values)))
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// CounterVec counts things that only go up, split by label values
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	count  float64
+}
+
+func (registry *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	counter := &CounterVec{
+		desc:   desc{name: name, help: help, labelNames: labelNames},
+		values: make(map[string]*counterValue),
+	}
+	registry.register(counter)
+	return counter
+}
+
+func (counter *CounterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *CounterVec) Add(delta float64, labelValues ...string) {
+	counter.checkLabels(labelValues)
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	key := labelKey(labelValues)
+	value, found := counter.values[key]
+	if !found {
+		value = &counterValue{labels: append([]string(nil), labelValues...)}
+		counter.values[key] = value
+	}
+	value.count += delta
+}
+
+// Value returns the current count for the label values, mostly useful in tests
+func (counter *CounterVec) Value(labelValues ...string) float64 {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	value, found := counter.values[labelKey(labelValues)]
+	if !found {
+		return 0
+	}
+	return value.count
+}
+
+func (counter *CounterVec) write(w io.Writer) error {
+	err := counter.writeHeader(w, "counter")
+	if err != nil {
+		return err
+	}
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	for _, key := range sortedKeys(counter.values) {
+		value := counter.values[key]
+		_, err = fmt.Fprintf(w, "%s%s %s\n", counter.name, counter.formatLabels(value.labels), formatFloat(value.count))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge is a single value that goes up and down
+type Gauge struct {
+	desc
+	value atomic.Int64
+}
+
+func (registry *Registry) NewGauge(name, help string) *Gauge {
+	gauge := &Gauge{desc: desc{name: name, help: help}}
+	registry.register(gauge)
+	return gauge
+}
+
+func (gauge *Gauge) Inc() {
+	gauge.value.Add(1)
+}
+
+func (gauge *Gauge) Dec() {
+	gauge.value.Add(-1)
+}
+
+func (gauge *Gauge) Set(value int64) {
+	gauge.value.Store(value)
+}
+
+func (gauge *Gauge) Value() int64 {
+	return gauge.value.Load()
+}
+
+func (gauge *Gauge) write(w io.Writer) error {
+	err := gauge.writeHeader(w, "gauge")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s %d\n", gauge.name, gauge.value.Load())
+	return err
+}
+
+// GaugeFunc reads its value when scraped, for numbers that already live somewhere else
+type GaugeFunc struct {
+	desc
+	read func() float64
+}
+
+func (registry *Registry) NewGaugeFunc(name, help string, read func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{desc: desc{name: name, help: help}, read: read}
+	registry.register(gauge)
+	return gauge
+}
+
+func (gauge *GaugeFunc) write(w io.Writer) error {
+	err := gauge.writeHeader(w, "gauge")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s %s\n", gauge.name, formatFloat(gauge.read()))
+	return err
+}
+
+// HistogramVec sorts observations into cumulative buckets, split by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels       []string
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+func (registry *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sortedBuckets := append([]float64(nil), buckets...)
+	sort.Float64s(sortedBuckets)
+
+	histogram := &HistogramVec{
+		desc:    desc{name: name, help: help, labelNames: labelNames},
+		buckets: sortedBuckets,
+		values:  make(map[string]*histogramValue),
+	}
+	registry.register(histogram)
+	return histogram
+}
+
+func (histogram *HistogramVec) Observe(value float64, labelValues ...string) {
+	histogram.checkLabels(labelValues)
+
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+
+	key := labelKey(labelValues)
+	observed, found := histogram.values[key]
+	if !found {
+		observed = &histogramValue{
+			labels:       append([]string(nil), labelValues...),
+			bucketCounts: make([]uint64, len(histogram.buckets)),
+		}
+		histogram.values[key] = observed
+	}
+
+	for idx, upperBound := range histogram.buckets {
+		if value <= upperBound {
+			observed.bucketCounts[idx]++
+		}
+	}
+	observed.count++
+	observed.sum += value
+}
+
+// Count returns how many values were observed for the label values, mostly useful in tests
+func (histogram *HistogramVec) Count(labelValues ...string) uint64 {
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+
+	observed, found := histogram.values[labelKey(labelValues)]
+	if !found {
+		return 0
+	}
+	return observed.count
+}
+
+func (histogram *HistogramVec) write(w io.Writer) error {
+	err := histogram.writeHeader(w, "histogram")
+	if err != nil {
+		return err
+	}
+
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+
+	for _, key := range sortedKeys(histogram.values) {
+		observed := histogram.values[key]
+		for idx, upperBound := range histogram.buckets {
+			_, err = fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, histogram.formatLabels(observed.labels, "le", formatFloat(upperBound)), observed.bucketCounts[idx])
+			if err != nil {
+				return err
+			}
+		}
+
+		labels := histogram.formatLabels(observed.labels)
+		_, err = fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			histogram.name, histogram.formatLabels(observed.labels, "le", "+Inf"), observed.count,
+			histogram.name, labels, formatFloat(observed.sum),
+			histogram.name, labels, observed.count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests.\nBy route.", "route")
+	latency := registry.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.5, 0.1}, "route")
+	inFlight := registry.NewGauge("test_in_flight", "In flight.")
+
+	requests.Inc(`GET /api/"chirps"`)
+	requests.Add(2, "GET /api/healthz")
+	latency.Observe(0.05, "GET /api/healthz")
+	latency.Observe(0.3, "GET /api/healthz")
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+
+	var output strings.Builder
+	err := registry.WritePrometheus(&output)
+	if err != nil {
+		t.Fatalf(`WritePrometheus failed: %v`, err)
+	}
+
+	want := `# HELP test_requests_total Requests.\nBy route.
+# TYPE test_requests_total counter
+test_requests_total{route="GET /api/\"chirps\""} 1
+test_requests_total{route="GET /api/healthz"} 2
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="GET /api/healthz",le="0.1"} 1
+test_latency_seconds_bucket{route="GET /api/healthz",le="0.5"} 2
+test_latency_seconds_bucket{route="GET /api/healthz",le="+Inf"} 2
+test_latency_seconds_sum{route="GET /api/healthz"} 0.35
+test_latency_seconds_count{route="GET /api/healthz"} 2
+# HELP test_in_flight In flight.
+# TYPE test_in_flight gauge
+test_in_flight 1
+`
+	if output.String() != want {
+		t.Errorf("WritePrometheus wrote:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("GET /api/healthz", "GET", 200, time.Millisecond)
+	m.ObserveLogin(true)
+	m.ObserveChirpCreated()
+	m.ObserveQuery("GetUserViaID", time.Millisecond)
+}
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET /api/chirps", "GET", 200, time.Millisecond)
+	m.ObserveRequest("GET /api/chirps", "GET", 204, time.Millisecond)
+	m.ObserveRequest("", "GET", 404, time.Millisecond)
+
+	if count := m.Requests.Value("GET /api/chirps", "GET", "2xx"); count != 2 {
+		t.Errorf(`2xx count is %v, want 2`, count)
+	}
+	if count := m.Requests.Value(UNMATCHED_ROUTE, "GET", "4xx"); count != 1 {
+		t.Errorf(`unmatched 4xx count is %v, want 1`, count)
+	}
+	if count := m.RequestDuration.Count("GET /api/chirps", "GET"); count != 2 {
+		t.Errorf(`latency count is %v, want 2`, count)
+	}
+}
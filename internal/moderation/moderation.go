@@ -0,0 +1,334 @@
+package moderation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type Action string
+
+// actions from least to most severe
+const (
+	ACTION_ALLOW  Action = "allow"
+	ACTION_MASK   Action = "mask"
+	ACTION_FLAG   Action = "flag"
+	ACTION_REJECT Action = "reject"
+)
+
+const MASK = "****"
+
+var ErrInvalidAction = errors.New("error: action must be one of mask, flag or reject")
+var ErrInvalidWord = errors.New("error: word must be a single word of letters or digits")
+
+var severity = map[Action]int{
+	ACTION_ALLOW:  0,
+	ACTION_MASK:   1,
+	ACTION_FLAG:   2,
+	ACTION_REJECT: 3,
+}
+
+func ParseAction(raw string) (Action, error) {
+	action := Action(strings.ToLower(strings.TrimSpace(raw)))
+	if action == ACTION_ALLOW || severity[action] <= 0 {
+		return "", ErrInvalidAction
+	}
+	return action, nil
+}
+
+// Verdict is what a filter decided about a chirp body.
+// Body is the chirp after masking, even when the chirp is flagged
+type Verdict struct {
+	Action  Action
+	Body    string
+	Reasons []string
+}
+
+type Filter interface {
+	Check(body string) Verdict
+}
+
+// Chain runs every filter in order. Masks carry over to the next filter,
+// flags pile up, and the first reject stops the chain
+type Chain struct {
+	filters []Filter
+}
+
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+func (chain *Chain) Check(body string) Verdict {
+	result := Verdict{
+		Action: ACTION_ALLOW,
+		Body:   body,
+	}
+
+	for _, filter := range chain.filters {
+		verdict := filter.Check(result.Body)
+		if verdict.Action == ACTION_REJECT {
+			return Verdict{Action: ACTION_REJECT, Body: body, Reasons: verdict.Reasons}
+		}
+
+		result.Body = verdict.Body
+		result.Reasons = append(result.Reasons, verdict.Reasons...)
+		if severity[verdict.Action] > severity[result.Action] {
+			result.Action = verdict.Action
+		}
+	}
+
+	return result
+}
+
+type word struct {
+	text  string
+	start int
+	end   int
+}
+
+// splitWords breaks text into runs of letters, digits and combining marks
+// so punctuation like "kerfuffle!" or "«fornax»" never hides a word
+func splitWords(text string) []word {
+	words := make([]word, 0)
+	start := -1
+	for idx, char := range text {
+		isWordChar := unicode.IsLetter(char) || unicode.IsDigit(char) || unicode.IsMark(char)
+		if isWordChar && start < 0 {
+			start = idx
+		}
+		if !isWordChar && start >= 0 {
+			words = append(words, word{text: text[start:idx], start: start, end: idx})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, word{text: text[start:], start: start, end: len(text)})
+	}
+	return words
+}
+
+// NormalizeWord folds case so "KERFUFFLE", "Kerfuffle" and "kerfuffle" are the same word
+func NormalizeWord(text string) string {
+	return strings.ToLower(text)
+}
+
+// ParseWord checks that text is a single word and returns it the way the list stores it
+func ParseWord(text string) (string, error) {
+	found := splitWords(text)
+	if len(found) != 1 || found[0].text != strings.TrimSpace(text) {
+		return "", ErrInvalidWord
+	}
+	return NormalizeWord(found[0].text), nil
+}
+
+// WordListFilter matches whole words against a list that can be edited while the server runs.
+// Each word carries the action to take when it shows up
+type WordListFilter struct {
+	mu    sync.RWMutex
+	words map[string]Action
+}
+
+func NewWordListFilter(words map[string]Action) *WordListFilter {
+	filter := &WordListFilter{words: make(map[string]Action)}
+	for text, action := range words {
+		filter.words[NormalizeWord(text)] = action
+	}
+	return filter
+}
+
+// Set adds a word to the list or changes the action of one already on it.
+// It returns the word as it was stored
+func (filter *WordListFilter) Set(text string, action Action) (string, error) {
+	if _, err := ParseAction(string(action)); err != nil {
+		return "", err
+	}
+
+	normalized, err := ParseWord(text)
+	if err != nil {
+		return "", err
+	}
+
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	filter.words[normalized] = action
+	return normalized, nil
+}
+
+func (filter *WordListFilter) Remove(text string) {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	delete(filter.words, NormalizeWord(strings.TrimSpace(text)))
+}
+
+// Words returns a copy of the list and each word's action
+func (filter *WordListFilter) Words() map[string]Action {
+	filter.mu.RLock()
+	defer filter.mu.RUnlock()
+
+	words := make(map[string]Action, len(filter.words))
+	for text, action := range filter.words {
+		words[text] = action
+	}
+	return words
+}
+
+func (filter *WordListFilter) Check(body string) Verdict {
+	filter.mu.RLock()
+	defer filter.mu.RUnlock()
+
+	verdict := Verdict{Action: ACTION_ALLOW}
+
+	var masked strings.Builder
+	lastEnd := 0
+	for _, found := range splitWords(body) {
+		action, isListed := filter.words[NormalizeWord(found.text)]
+		if !isListed {
+			continue
+		}
+
+		if severity[action] > severity[verdict.Action] {
+			verdict.Action = action
+		}
+		verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("contains the word %q", NormalizeWord(found.text)))
+
+		// flagged words stay as they were, so moderators can see what was posted
+		if action != ACTION_MASK {
+			continue
+		}
+		masked.WriteString(body[lastEnd:found.start])
+		masked.WriteString(MASK)
+		lastEnd = found.end
+	}
+	masked.WriteString(body[lastEnd:])
+
+	verdict.Body = masked.String()
+	return verdict
+}
+
+// RegexFilter matches a pattern anywhere in the chirp
+type RegexFilter struct {
+	pattern *regexp.Regexp
+	action  Action
+	reason  string
+}
+
+func NewRegexFilter(pattern string, action Action, reason string) (*RegexFilter, error) {
+	if _, err := ParseAction(string(action)); err != nil {
+		return nil, err
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reason) <= 0 {
+		reason = fmt.Sprintf("matches the pattern %q", pattern)
+	}
+
+	return &RegexFilter{
+		pattern: compiled,
+		action:  action,
+		reason:  reason,
+	}, nil
+}
+
+func (filter *RegexFilter) Check(body string) Verdict {
+	if !filter.pattern.MatchString(body) {
+		return Verdict{Action: ACTION_ALLOW, Body: body}
+	}
+
+	verdict := Verdict{
+		Action:  filter.action,
+		Body:    body,
+		Reasons: []string{filter.reason},
+	}
+	if filter.action == ACTION_MASK {
+		verdict.Body = filter.pattern.ReplaceAllString(body, MASK)
+	}
+	return verdict
+}
+
+// LoadWordList reads a word list file: one word per line, optionally followed by its action.
+// Words without an action are masked. Blank lines and lines starting with # are skipped
+func LoadWordList(path string) (map[string]Action, error) {
+	words := make(map[string]Action)
+	err := readConfigLines(path, func(line string) error {
+		fields := strings.Fields(line)
+		action := ACTION_MASK
+		if len(fields) > 1 {
+			parsedAction, err := ParseAction(fields[1])
+			if err != nil {
+				return err
+			}
+			action = parsedAction
+		}
+		words[NormalizeWord(fields[0])] = action
+		return nil
+	})
+	return words, err
+}
+
+// LoadPatterns reads a pattern file: each line is an action followed by a regular expression
+func LoadPatterns(path string) ([]Filter, error) {
+	filters := make([]Filter, 0)
+	err := readConfigLines(path, func(line string) error {
+		rawAction, pattern, found := strings.Cut(line, " ")
+		if !found {
+			return fmt.Errorf("error: pattern line %q needs an action and a pattern", line)
+		}
+
+		action, err := ParseAction(rawAction)
+		if err != nil {
+			return err
+		}
+
+		filter, err := NewRegexFilter(strings.TrimSpace(pattern), action, "")
+		if err != nil {
+			return err
+		}
+		filters = append(filters, filter)
+		return nil
+	})
+	return filters, err
+}
+
+func readConfigLines(path string, handleLine func(line string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) <= 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		err := handleLine(line)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", path, lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// SortedWords is Words as a list, handy for stable output
+func SortedWords(words map[string]Action) []string {
+	sorted := make([]string, 0, len(words))
+	for text := range words {
+		sorted = append(sorted, text)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
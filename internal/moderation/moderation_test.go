@@ -0,0 +1,120 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWordListFilter(t *testing.T) {
+	filter := NewWordListFilter(map[string]Action{
+		"kerfuffle": ACTION_MASK,
+		"fornax":    ACTION_MASK,
+		"crème":     ACTION_FLAG,
+	})
+
+	cases := []struct {
+		body       string
+		wantBody   string
+		wantAction Action
+	}{
+		{"nothing to see here", "nothing to see here", ACTION_ALLOW},
+		{"what a kerfuffle!", "what a ****!", ACTION_MASK},
+		{"KERFUFFLE, Fornax.", "****, ****.", ACTION_MASK},
+		{"«fornax»", "«****»", ACTION_MASK},
+		{"kerfuffles are fine", "kerfuffles are fine", ACTION_ALLOW},
+		{"CRÈME brûlée", "CRÈME brûlée", ACTION_FLAG},
+		{"crème de la kerfuffle", "crème de la ****", ACTION_FLAG},
+	}
+
+	for _, c := range cases {
+		verdict := filter.Check(c.body)
+		if verdict.Body != c.wantBody || verdict.Action != c.wantAction {
+			t.Errorf(`Check(%q) = %q %s, want %q %s`, c.body, verdict.Body, verdict.Action, c.wantBody, c.wantAction)
+		}
+	}
+}
+
+func TestWordListFilterEdits(t *testing.T) {
+	filter := NewWordListFilter(nil)
+
+	word, err := filter.Set(" Sharbert ", ACTION_REJECT)
+	if err != nil || word != "sharbert" {
+		t.Errorf(`Set returned %q %v, want "sharbert"`, word, err)
+		return
+	}
+	if verdict := filter.Check("sharbert!"); verdict.Action != ACTION_REJECT {
+		t.Errorf(`added word should be rejected, got %s`, verdict.Action)
+		return
+	}
+
+	filter.Remove("SHARBERT")
+	if verdict := filter.Check("sharbert!"); verdict.Action != ACTION_ALLOW {
+		t.Errorf(`removed word should be allowed, got %s`, verdict.Action)
+		return
+	}
+
+	if _, err := filter.Set("two words", ACTION_MASK); err != ErrInvalidWord {
+		t.Errorf(`Set with two words returned %v, want ErrInvalidWord`, err)
+	}
+	if _, err := filter.Set("word", ACTION_ALLOW); err != ErrInvalidAction {
+		t.Errorf(`Set with allow returned %v, want ErrInvalidAction`, err)
+	}
+
+	if word, err := ParseWord(" Fornax "); err != nil || word != "fornax" {
+		t.Errorf(`ParseWord returned %q %v, want "fornax"`, word, err)
+	}
+	if _, err := ParseWord("two words"); err != ErrInvalidWord {
+		t.Errorf(`ParseWord with two words returned %v, want ErrInvalidWord`, err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	words := NewWordListFilter(map[string]Action{"fornax": ACTION_MASK, "sharbert": ACTION_FLAG})
+	links, err := NewRegexFilter(`https?://`, ACTION_FLAG, "contains a link")
+	if err != nil {
+		t.Fatalf(`error making regex filter: %v`, err)
+	}
+	shouting, err := NewRegexFilter(`^[A-Z *]{12,}$`, ACTION_REJECT, "")
+	if err != nil {
+		t.Fatalf(`error making regex filter: %v`, err)
+	}
+	chain := NewChain(words, links, shouting)
+
+	verdict := chain.Check("fornax sharbert at http://example.com")
+	if verdict.Action != ACTION_FLAG || verdict.Body != "**** sharbert at http://example.com" {
+		t.Errorf(`chain verdict is wrong: %+v`, verdict)
+		return
+	}
+	wantReasons := []string{`contains the word "fornax"`, `contains the word "sharbert"`, "contains a link"}
+	if !slices.Equal(verdict.Reasons, wantReasons) {
+		t.Errorf(`reasons = %q, want %q`, verdict.Reasons, wantReasons)
+		return
+	}
+
+	verdict = chain.Check("STOP SHOUTING FORNAX")
+	if verdict.Action != ACTION_REJECT || verdict.Body != "STOP SHOUTING FORNAX" {
+		t.Errorf(`shouting should be rejected untouched: %+v`, verdict)
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir := t.TempDir()
+	wordsPath := filepath.Join(dir, "words.txt")
+	os.WriteFile(wordsPath, []byte("# banned words\nKerfuffle\nfornax reject\n\n"), 0o644)
+
+	words, err := LoadWordList(wordsPath)
+	if err != nil {
+		t.Fatalf(`error loading word list: %v`, err)
+	}
+	if len(words) != 2 || words["kerfuffle"] != ACTION_MASK || words["fornax"] != ACTION_REJECT {
+		t.Errorf(`word list is wrong: %v`, words)
+	}
+
+	patternsPath := filepath.Join(dir, "patterns.txt")
+	os.WriteFile(patternsPath, []byte("flag https?://\nexplode [\n"), 0o644)
+	if _, err := LoadPatterns(patternsPath); err == nil {
+		t.Errorf(`loading a bad pattern file should fail`)
+	}
+}
@@ -0,0 +1,199 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const DEFAULT_PAGE_LIMIT = 20
+const MAX_PAGE_LIMIT = 100
+
+const DIRECTION_NEXT = "n"
+const DIRECTION_PREV = "p"
+const OFFSET_PREFIX = "o"
+
+// MAX_OFFSET is the largest offset Postgres gets, since offsets are passed as int4
+const MAX_OFFSET = math.MaxInt32
+
+var ErrInvalidCursor = errors.New("error: cursor is not valid")
+var ErrInvalidLimit = errors.New("error: limit must be a number between 1 and 100")
+
+// Cursor marks a position in a list ordered by (created_at, id).
+// Direction says whether the page after or before that position was asked for
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+	Direction string
+}
+
+// IsPrev reports whether the cursor walks back towards the start of the list
+func (cursor *Cursor) IsPrev() bool {
+	return cursor != nil && cursor.Direction == DIRECTION_PREV
+}
+
+// EncodeCursor turns a cursor into an opaque string clients pass back as is
+func EncodeCursor(cursor Cursor) string {
+	raw := fmt.Sprintf("%s|%s|%s", cursor.Direction, cursor.CreatedAt.UTC().Format(time.RFC3339Nano), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(encoded string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || (parts[0] != DIRECTION_NEXT && parts[0] != DIRECTION_PREV) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return Cursor{
+		CreatedAt: createdAt,
+		ID:        id,
+		Direction: parts[0],
+	}, nil
+}
+
+// ParseCursor decodes the cursor query parameter, returning nil for the first page
+func ParseCursor(raw string) (*Cursor, error) {
+	if len(raw) <= 0 {
+		return nil, nil
+	}
+
+	cursor, err := DecodeCursor(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// ParseLimit reads the limit query parameter, falling back to DEFAULT_PAGE_LIMIT
+func ParseLimit(raw string) (int, error) {
+	if len(raw) <= 0 {
+		return DEFAULT_PAGE_LIMIT, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > MAX_PAGE_LIMIT {
+		return 0, ErrInvalidLimit
+	}
+	return limit, nil
+}
+
+// Window trims rows fetched with limit+1 down to one page and works out the cursors around it.
+// Rows come in the order they were queried: towards the end of the list for a next cursor
+// or the first page, and back towards the start for a prev cursor.
+// The returned rows are always in display order
+func Window[T any](rows []T, limit int, cursor *Cursor, position func(T) (time.Time, uuid.UUID)) ([]T, string, string) {
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	if cursor.IsPrev() {
+		slices.Reverse(rows)
+	}
+
+	if len(rows) <= 0 {
+		return rows, "", ""
+	}
+
+	nextCursor := ""
+	prevCursor := ""
+
+	firstCreatedAt, firstID := position(rows[0])
+	lastCreatedAt, lastID := position(rows[len(rows)-1])
+
+	// going forward there is a previous page whenever we came from a cursor,
+	// going back there is always the page we came from
+	hasNext := hasMore
+	hasPrev := cursor != nil
+	if cursor.IsPrev() {
+		hasNext = true
+		hasPrev = hasMore
+	}
+
+	if hasNext {
+		nextCursor = EncodeCursor(Cursor{CreatedAt: lastCreatedAt, ID: lastID, Direction: DIRECTION_NEXT})
+	}
+	if hasPrev {
+		prevCursor = EncodeCursor(Cursor{CreatedAt: firstCreatedAt, ID: firstID, Direction: DIRECTION_PREV})
+	}
+
+	return rows, nextCursor, prevCursor
+}
+
+// EncodeOffsetCursor is for lists without a stable (created_at, id) order, like ranked search results
+func EncodeOffsetCursor(offset int) string {
+	raw := fmt.Sprintf("%s|%d", OFFSET_PREFIX, offset)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseOffsetCursor decodes the cursor query parameter of an offset paginated list,
+// returning 0 for the first page
+func ParseOffsetCursor(encoded string) (int, error) {
+	if len(encoded) <= 0 {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	prefix, rawOffset, found := strings.Cut(string(raw), "|")
+	if !found || prefix != OFFSET_PREFIX {
+		return 0, ErrInvalidCursor
+	}
+
+	offset, err := strconv.Atoi(rawOffset)
+	if err != nil || offset < 0 || offset > MAX_OFFSET {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+// LinkHeader builds an RFC 8288 Link header pointing at the next and previous pages
+func LinkHeader(requestURL *url.URL, nextCursor, prevCursor string) string {
+	links := make([]string, 0, 2)
+
+	for _, link := range []struct {
+		cursor string
+		rel    string
+	}{
+		{nextCursor, "next"},
+		{prevCursor, "prev"},
+	} {
+		if len(link.cursor) <= 0 {
+			continue
+		}
+
+		pageURL := *requestURL
+		query := pageURL.Query()
+		query.Set("cursor", link.cursor)
+		pageURL.RawQuery = query.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, pageURL.RequestURI(), link.rel))
+	}
+
+	return strings.Join(links, ", ")
+}
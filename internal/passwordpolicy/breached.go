@@ -0,0 +1,117 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SHA-1 hashes are split the way k-anonymity range lookups split them,
+// so the list can answer a range query without ever seeing the password
+const (
+	HASH_PREFIX_LENGTH = 5
+	HASH_LENGTH        = 40
+)
+
+var ErrInvalidBreachedHash = errors.New("error: breached password lines must be a SHA-1 hash in hex, optionally followed by :count")
+
+// BreachedList is a set of SHA-1 hashes of leaked passwords, grouped by prefix
+type BreachedList struct {
+	ranges map[string]map[string]struct{}
+	count  int
+}
+
+func NewBreachedList() *BreachedList {
+	return &BreachedList{ranges: make(map[string]map[string]struct{})}
+}
+
+// LoadBreachedList reads one SHA-1 hash per line, as downloaded from a range API
+// ("HASH:count"). Blank lines and lines starting with # are skipped
+func LoadBreachedList(path string) (*BreachedList, error) {
+	list := NewBreachedList()
+	err := readConfigLines(path, func(line string) error {
+		hash, _, _ := strings.Cut(line, ":")
+		return list.AddHash(strings.TrimSpace(hash))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// AddHash adds a hex SHA-1 hash, in either case
+func (list *BreachedList) AddHash(hash string) error {
+	if len(hash) != HASH_LENGTH {
+		return ErrInvalidBreachedHash
+	}
+	_, err := hex.DecodeString(hash)
+	if err != nil {
+		return ErrInvalidBreachedHash
+	}
+
+	hash = strings.ToUpper(hash)
+	prefix, suffix := hash[:HASH_PREFIX_LENGTH], hash[HASH_PREFIX_LENGTH:]
+	suffixes, ok := list.ranges[prefix]
+	if !ok {
+		suffixes = make(map[string]struct{})
+		list.ranges[prefix] = suffixes
+	}
+	if _, ok := suffixes[suffix]; !ok {
+		suffixes[suffix] = struct{}{}
+		list.count++
+	}
+	return nil
+}
+
+// Range returns the sorted hash suffixes that share a prefix, like a range API would
+func (list *BreachedList) Range(prefix string) []string {
+	suffixes := list.ranges[strings.ToUpper(prefix)]
+	sorted := make([]string, 0, len(suffixes))
+	for suffix := range suffixes {
+		sorted = append(sorted, suffix)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Contains reports whether the password's hash is on the list
+func (list *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, ok := list.ranges[hash[:HASH_PREFIX_LENGTH]][hash[HASH_PREFIX_LENGTH:]]
+	return ok
+}
+
+// Len is how many hashes are on the list
+func (list *BreachedList) Len() int {
+	return list.count
+}
+
+func readConfigLines(path string, handleLine func(line string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) <= 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		err := handleLine(line)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", path, lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,119 @@
+package passwordpolicy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const DEFAULT_MIN_LENGTH = 8
+
+// MIN_ENTROPY_BITS is a little under what 8 random lowercase letters give
+const MIN_ENTROPY_BITS = 35.0
+
+// MIN_EMAIL_PART_LENGTH keeps short local parts like "al" from ruling out half of all passwords
+const MIN_EMAIL_PART_LENGTH = 3
+
+var ErrTooShort = errors.New("error: password is too short")
+var ErrTooPredictable = errors.New("error: password is too easy to guess, make it longer or less repetitive")
+var ErrContainsEmail = errors.New("error: password can't contain your email")
+var ErrBreached = errors.New("error: password has shown up in a data breach, pick another one")
+
+// Policy decides which passwords are good enough to hash
+type Policy struct {
+	MinLength int
+	// Breached is checked when set
+	Breached *BreachedList
+}
+
+// Check returns the first rule a password breaks, or nil.
+// email is the address the password belongs to, and may be empty
+func (policy Policy) Check(password, email string) error {
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrTooShort, policy.MinLength)
+	}
+	if containsEmail(password, email) {
+		return ErrContainsEmail
+	}
+	if EntropyBits(password) < MIN_ENTROPY_BITS {
+		return ErrTooPredictable
+	}
+	if policy.Breached != nil && policy.Breached.Contains(password) {
+		return ErrBreached
+	}
+	return nil
+}
+
+// containsEmail looks for the whole address and for the part before the @
+func containsEmail(password, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) <= 0 {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+
+	localPart, _, _ := strings.Cut(email, "@")
+	return utf8.RuneCountInString(localPart) >= MIN_EMAIL_PART_LENGTH && strings.Contains(password, localPart)
+}
+
+// EntropyBits estimates how many guesses a password is worth, as if each character were picked
+// at random from the kinds of characters it uses. A character that repeats the one before it or
+// carries on a run like "abc" or "321" only counts for one bit, since guessers try those first
+func EntropyBits(password string) float64 {
+	poolBits := math.Log2(float64(poolSize(password)))
+
+	bits := 0.0
+	previous := rune(-1)
+	for _, char := range password {
+		step := char - previous
+		if previous >= 0 && step >= -1 && step <= 1 {
+			bits++
+		} else {
+			bits += poolBits
+		}
+		previous = char
+	}
+	return bits
+}
+
+// poolSize is how many characters a guesser would try for each position
+func poolSize(password string) int {
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	for _, char := range password {
+		switch {
+		case char >= 'a' && char <= 'z':
+			hasLower = true
+		case char >= 'A' && char <= 'Z':
+			hasUpper = true
+		case char >= '0' && char <= '9':
+			hasDigit = true
+		case char < unicode.MaxASCII && unicode.IsPrint(char):
+			hasSymbol = true
+		default:
+			hasOther = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{
+		{hasLower, 26},
+		{hasUpper, 26},
+		{hasDigit, 10},
+		{hasSymbol, 33},
+		{hasOther, 100},
+	} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return max(size, 1)
+}
@@ -0,0 +1,85 @@
+package passwordpolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	breached := NewBreachedList()
+	// sha-1 of "qwerty123456"
+	breached.AddHash("f3ba381b6baef526bf70ff220b1da4906989224b")
+	policy := Policy{MinLength: DEFAULT_MIN_LENGTH, Breached: breached}
+
+	cases := []struct {
+		password string
+		email    string
+		wantErr  error
+	}{
+		{"my-super-secure-password", "walt@breakingbad.com", nil},
+		{"Tr0ub4dor&3", "", nil},
+		{"pw", "walt@breakingbad.com", ErrTooShort},
+		{"crème-brûlée", "", nil},
+		{"aaaaaaaaaaaaaaaaaaaa", "", ErrTooPredictable},
+		{"abcdefghijklmnop", "", ErrTooPredictable},
+		{"98765432109876", "", ErrTooPredictable},
+		{"password", "", ErrTooPredictable},
+		{"im-WALT@breakingbad.com!", "walt@breakingbad.com", ErrContainsEmail},
+		{"heisenberg-walter", "walt@breakingbad.com", ErrContainsEmail},
+		{"al-is-a-chemist", "al@breakingbad.com", nil},
+		{"qwerty123456", "", ErrBreached},
+	}
+
+	for _, c := range cases {
+		err := policy.Check(c.password, c.email)
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf(`Check(%q, %q) = %v, want %v`, c.password, c.email, err, c.wantErr)
+		}
+	}
+
+	err := policy.Check("pw", "")
+	if err == nil || !strings.Contains(err.Error(), "at least 8 characters") {
+		t.Errorf(`a short password should say how long it needs to be, got %v`, err)
+	}
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	os.WriteFile(path, []byte(`# from a range download
+F3BA381B6BAEF526BF70FF220B1DA4906989224B:1210934
+f3ba3aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa:2
+
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+F3BA381B6BAEF526BF70FF220B1DA4906989224B:1210934
+`), 0o644)
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf(`LoadBreachedList failed: %v`, err)
+	}
+	if list.Len() != 3 {
+		t.Errorf(`duplicates should only count once, got %d hashes`, list.Len())
+	}
+	if !list.Contains("qwerty123456") || !list.Contains("password") || list.Contains("my-super-secure-password") {
+		t.Errorf(`Contains doesn't match the hashes that were loaded`)
+	}
+
+	got := list.Range("f3ba3")
+	want := []string{"81B6BAEF526BF70FF220B1DA4906989224B", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}
+	if !slices.Equal(got, want) {
+		t.Errorf(`Range returned %q, want %q`, got, want)
+	}
+	if len(list.Range("00000")) != 0 {
+		t.Errorf(`an unknown prefix should have an empty range`)
+	}
+
+	os.WriteFile(path, []byte("F3BA381B6BAEF526BF70FF220B1DA4906989224B\nnot-a-hash:12\n"), 0o644)
+	_, err = LoadBreachedList(path)
+	if !errors.Is(err, ErrInvalidBreachedHash) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf(`a bad line should be reported with its number, got %v`, err)
+	}
+}
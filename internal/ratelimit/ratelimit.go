@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket holding up to Burst requests, refilled steadily so it's full again after Period.
+// Burst must be at least 1
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// interval is how long one token takes to come back
+func (limit Limit) interval() time.Duration {
+	return limit.Period / time.Duration(limit.Burst)
+}
+
+// Result is how a bucket looked once a request tried to take from it
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+	// RetryAfter is how long until the next request gets through, zero if this one did
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. MemoryStore counts for this process only,
+// a shared store would let every instance count together
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket remembers when it would be full rather than how many tokens it holds,
+// so refilling needs no background work
+type bucket struct {
+	fullAt time.Time
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]bucket),
+		now:     time.Now,
+	}
+}
+
+// Take spends one token from the key's bucket if there's one to spend
+func (store *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.now()
+	interval := limit.interval()
+	fullAt := store.buckets[key].fullAt
+	if fullAt.Before(now) {
+		fullAt = now
+	}
+
+	// an empty bucket is full a whole period from now, and one more token can't fit
+	spentAt := fullAt.Add(interval)
+	if spentAt.Sub(now) > limit.Period {
+		return Result{
+			Allowed:    false,
+			Limit:      limit.Burst,
+			Remaining:  0,
+			ResetAfter: fullAt.Sub(now),
+			RetryAfter: spentAt.Sub(now) - limit.Period,
+		}, nil
+	}
+
+	store.buckets[key] = bucket{fullAt: spentAt}
+	resetAfter := spentAt.Sub(now)
+	return Result{
+		Allowed:    true,
+		Limit:      limit.Burst,
+		Remaining:  int((limit.Period - resetAfter) / interval),
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+// Prune forgets buckets that have filled back up, they'd start out full anyway
+func (store *MemoryStore) Prune() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	prunedCount := 0
+	now := store.now()
+	for key, bucket := range store.buckets {
+		if !bucket.fullAt.After(now) {
+			delete(store.buckets, key)
+			prunedCount++
+		}
+	}
+	return prunedCount
+}
+
+// RunPrune prunes every interval until ctx is done
+func (store *MemoryStore) RunPrune(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			store.Prune()
+		}
+	}
+}
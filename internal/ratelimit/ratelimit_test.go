@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	store := NewMemoryStore()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	store.now = func() time.Time { return now }
+	limit := Limit{Burst: 3, Period: 3 * time.Second}
+
+	for wantRemaining := 2; wantRemaining >= 0; wantRemaining-- {
+		result, err := store.Take(context.Background(), "walt", limit)
+		if err != nil || !result.Allowed || result.Remaining != wantRemaining || result.Limit != 3 {
+			t.Fatalf(`take should be allowed with %d left, got %+v: %v`, wantRemaining, result, err)
+		}
+	}
+
+	result, _ := store.Take(context.Background(), "walt", limit)
+	if result.Allowed || result.RetryAfter != time.Second || result.ResetAfter != 3*time.Second {
+		t.Errorf(`an empty bucket should refuse and say when the next token comes, got %+v`, result)
+	}
+
+	// buckets are per key
+	result, _ = store.Take(context.Background(), "jesse", limit)
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf(`another key should have its own bucket, got %+v`, result)
+	}
+
+	now = start.Add(1500 * time.Millisecond)
+	result, _ = store.Take(context.Background(), "walt", limit)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf(`one token should have come back after a second and a half, got %+v`, result)
+	}
+	result, _ = store.Take(context.Background(), "walt", limit)
+	if result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Errorf(`the half token isn't enough yet, got %+v`, result)
+	}
+
+	// a full bucket is forgotten, taking from it again starts fresh
+	now = start.Add(time.Minute)
+	if pruned := store.Prune(); pruned != 2 {
+		t.Errorf(`Prune should drop both full buckets, dropped %d`, pruned)
+	}
+	result, _ = store.Take(context.Background(), "walt", limit)
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf(`a refilled bucket should start full, got %+v`, result)
+	}
+}
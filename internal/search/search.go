@@ -0,0 +1,210 @@
+package search
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// HIGHLIGHT_START and HIGHLIGHT_STOP wrap matched words in snippets,
+// the same markers the postgres search query hands to ts_headline
+const HIGHLIGHT_START = "<mark>"
+const HIGHLIGHT_STOP = "</mark>"
+
+// stopWords are skipped the same way the postgres english configuration skips them
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true, "to": true, "was": true,
+	"will": true, "with": true, "i": true, "me": true, "my": true, "we": true, "our": true, "you": true,
+	"he": true, "she": true, "his": true, "her": true, "its": true, "them": true, "were": true,
+	"been": true, "have": true, "has": true, "had": true, "do": true, "does": true, "did": true,
+	"what": true, "which": true, "who": true, "s": true, "t": true,
+}
+
+// htmlEscaper matches the replace() calls the postgres search query runs before ts_headline
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Query is a parsed websearch style query: plain words must all appear,
+// "quoted phrases" must appear in order and -words must not appear at all
+type Query struct {
+	Terms    []string
+	Phrases  [][]string
+	Excluded []string
+}
+
+type word struct {
+	text  string
+	start int
+	end   int
+}
+
+// splitWords breaks text into runs of letters and digits, remembering where each run sits
+func splitWords(text string) []word {
+	words := make([]word, 0)
+	start := -1
+	for idx, char := range text {
+		isWordChar := unicode.IsLetter(char) || unicode.IsDigit(char)
+		if isWordChar && start < 0 {
+			start = idx
+		}
+		if !isWordChar && start >= 0 {
+			words = append(words, word{text: text[start:idx], start: start, end: idx})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, word{text: text[start:], start: start, end: len(text)})
+	}
+	return words
+}
+
+// normalize lowercases a word and strips common english suffixes.
+// It returns an empty string for stop words
+func normalize(text string) string {
+	lexeme := strings.ToLower(text)
+	if stopWords[lexeme] {
+		return ""
+	}
+
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		stem, hasSuffix := strings.CutSuffix(lexeme, suffix)
+		if hasSuffix && len(stem) >= 3 {
+			return stem
+		}
+	}
+	return lexeme
+}
+
+// Tokenize turns text into the lexemes used for matching, in order
+func Tokenize(text string) []string {
+	tokens := make([]string, 0)
+	for _, found := range splitWords(text) {
+		lexeme := normalize(found.text)
+		if len(lexeme) > 0 {
+			tokens = append(tokens, lexeme)
+		}
+	}
+	return tokens
+}
+
+func ParseQuery(raw string) Query {
+	query := Query{}
+
+	// pull out the quoted phrases first, an unclosed quote runs to the end
+	remaining := make([]string, 0)
+	for idx, part := range strings.Split(raw, `"`) {
+		if idx%2 == 0 {
+			remaining = append(remaining, part)
+			continue
+		}
+		phrase := Tokenize(part)
+		if len(phrase) > 0 {
+			query.Phrases = append(query.Phrases, phrase)
+		}
+	}
+
+	for _, field := range strings.Fields(strings.Join(remaining, " ")) {
+		excludedField, isExcluded := strings.CutPrefix(field, "-")
+		for _, lexeme := range Tokenize(excludedField) {
+			if isExcluded {
+				query.Excluded = append(query.Excluded, lexeme)
+			} else {
+				query.Terms = append(query.Terms, lexeme)
+			}
+		}
+	}
+
+	return query
+}
+
+// IsEmpty reports whether the query has nothing that could match a chirp
+func (query Query) IsEmpty() bool {
+	return len(query.Terms) <= 0 && len(query.Phrases) <= 0
+}
+
+// Match reports whether text satisfies the query and how well it matches.
+// Like ts_rank, more hits score higher and longer texts are scored down
+func (query Query) Match(text string) (bool, float32) {
+	if query.IsEmpty() {
+		return false, 0
+	}
+
+	tokens := Tokenize(text)
+	for _, excluded := range query.Excluded {
+		if slices.Contains(tokens, excluded) {
+			return false, 0
+		}
+	}
+
+	hits := 0
+	for _, term := range query.Terms {
+		count := countToken(tokens, term)
+		if count <= 0 {
+			return false, 0
+		}
+		hits += count
+	}
+
+	for _, phrase := range query.Phrases {
+		count := countPhrase(tokens, phrase)
+		if count <= 0 {
+			return false, 0
+		}
+		hits += count * len(phrase)
+	}
+
+	rank := float64(hits) / (1 + math.Log(1+float64(len(tokens))))
+	return true, float32(rank)
+}
+
+// Highlight escapes text for html and wraps every word the query looks for in HIGHLIGHT_START and HIGHLIGHT_STOP
+func (query Query) Highlight(text string) string {
+	wanted := make(map[string]bool)
+	for _, term := range query.Terms {
+		wanted[term] = true
+	}
+	for _, phrase := range query.Phrases {
+		for _, lexeme := range phrase {
+			wanted[lexeme] = true
+		}
+	}
+
+	var snippet strings.Builder
+	lastEnd := 0
+	for _, found := range splitWords(text) {
+		if !wanted[normalize(found.text)] {
+			continue
+		}
+		snippet.WriteString(htmlEscaper.Replace(text[lastEnd:found.start]))
+		snippet.WriteString(HIGHLIGHT_START)
+		snippet.WriteString(htmlEscaper.Replace(found.text))
+		snippet.WriteString(HIGHLIGHT_STOP)
+		lastEnd = found.end
+	}
+	snippet.WriteString(htmlEscaper.Replace(text[lastEnd:]))
+
+	return snippet.String()
+}
+
+func countToken(tokens []string, lexeme string) int {
+	count := 0
+	for _, token := range tokens {
+		if token == lexeme {
+			count++
+		}
+	}
+	return count
+}
+
+func countPhrase(tokens []string, phrase []string) int {
+	count := 0
+	for idx := 0; idx+len(phrase) <= len(tokens); idx++ {
+		if slices.Equal(tokens[idx:idx+len(phrase)], phrase) {
+			count++
+		}
+	}
+	return count
+}
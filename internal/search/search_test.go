@@ -0,0 +1,59 @@
+package search_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/CzarRamos/chirpy/internal/search"
+)
+
+func TestTokenize(t *testing.T) {
+	output := search.Tokenize("The cooks were Cooking, and the cook's cookies!")
+	expected := []string{"cook", "cook", "cook", "cooki"}
+	if !slices.Equal(output, expected) {
+		t.Errorf(`Tokenize returned %v, want %v`, output, expected)
+	}
+}
+
+func TestMatchTermsPhrasesAndExclusions(t *testing.T) {
+	body := "Science is cooking blue crystals in the desert"
+
+	cases := []struct {
+		query   string
+		isMatch bool
+	}{
+		{"crystals", true},
+		{"CRYSTAL desert", true},
+		{"crystals suburbs", false},
+		{`"blue crystals"`, true},
+		{`"crystals blue"`, false},
+		{"crystals -desert", false},
+		{"crystals -suburbs", true},
+		{"the", false},
+	}
+
+	for _, testCase := range cases {
+		isMatch, _ := search.ParseQuery(testCase.query).Match(body)
+		if isMatch != testCase.isMatch {
+			t.Errorf(`Match(%q) returned %v, want %v`, testCase.query, isMatch, testCase.isMatch)
+		}
+	}
+}
+
+func TestMatchRanksMoreHitsHigher(t *testing.T) {
+	query := search.ParseQuery("cook")
+
+	_, oneHit := query.Match("time to cook something")
+	_, twoHits := query.Match("cook, cook, cook something")
+	if twoHits <= oneHit {
+		t.Errorf(`more hits should rank higher: got %v for more hits and %v for one`, twoHits, oneHit)
+	}
+}
+
+func TestHighlightEscapesHTML(t *testing.T) {
+	output := search.ParseQuery("danger").Highlight("I am the <b>danger</b> & the one")
+	expected := "I am the &lt;b&gt;<mark>danger</mark>&lt;/b&gt; &amp; the one"
+	if output != expected {
+		t.Errorf(`Highlight returned %q, want %q`, output, expected)
+	}
+}
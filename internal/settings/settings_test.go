@@ -0,0 +1,189 @@
+package settings_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CzarRamos/chirpy/internal/settings"
+)
+
+const testSecret = "this-is-a-secret-that-is-long-enough"
+
+func fakeEnv(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, found := values[name]
+		return value, found
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	loaded, err := settings.Load(nil, fakeEnv(map[string]string{
+		"secret": testSecret,
+		"STORE":  "memory",
+	}))
+	if err != nil {
+		t.Fatalf(`Load failed: %v`, err)
+	}
+
+	if loaded.Addr != settings.DEFAULT_ADDR || loaded.AccessTokenLifetime != time.Hour || loaded.BcryptCost != 10 {
+		t.Errorf(`defaults are wrong: %+v`, loaded)
+	}
+	if loaded.IsDev() {
+		t.Errorf(`the platform should default to prod, got %q`, loaded.Platform)
+	}
+	if loaded.RefreshTokenLifetime != 60*24*time.Hour {
+		t.Errorf(`refresh tokens should last 60 days by default, got %s`, loaded.RefreshTokenLifetime)
+	}
+	if loaded.PasswordHasher != "bcrypt" {
+		t.Errorf(`passwords should be hashed with bcrypt by default, got %q`, loaded.PasswordHasher)
+	}
+	if !loaded.RateLimit {
+		t.Errorf(`rate limiting should be on by default`)
+	}
+	if loaded.PasswordMinLength != 8 || loaded.BreachedPasswordsFile != "" {
+		t.Errorf(`passwords should need 8 characters and no breached list by default, got %d and %q`, loaded.PasswordMinLength, loaded.BreachedPasswordsFile)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "chirpy.yaml")
+	os.WriteFile(yamlPath, []byte(`# chirpy settings
+addr: ":9000"
+db_url: postgres://chirpy@localhost:5432/chirpy # local database
+bcrypt_cost: 12
+access_token_lifetime: 15m
+`), 0o644)
+
+	loaded, err := settings.Load([]string{"-config", yamlPath, "-bcrypt-cost", "11"}, fakeEnv(map[string]string{
+		"secret": testSecret,
+		"ADDR":   ":9100",
+	}))
+	if err != nil {
+		t.Fatalf(`Load failed: %v`, err)
+	}
+
+	if loaded.DBURL != "postgres://chirpy@localhost:5432/chirpy" || loaded.AccessTokenLifetime != 15*time.Minute {
+		t.Errorf(`config file values were not used: %+v`, loaded)
+	}
+	if loaded.Addr != ":9100" {
+		t.Errorf(`environment should beat the config file, got %q`, loaded.Addr)
+	}
+	if loaded.BcryptCost != 11 {
+		t.Errorf(`flags should beat everything, got %d`, loaded.BcryptCost)
+	}
+
+	tomlPath := filepath.Join(dir, "chirpy.toml")
+	os.WriteFile(tomlPath, []byte(`store = "memory"
+secret = "`+testSecret+`"
+`), 0o644)
+	loaded, err = settings.Load(nil, fakeEnv(map[string]string{"CONFIG_FILE": tomlPath}))
+	if err != nil {
+		t.Fatalf(`Load with a toml file failed: %v`, err)
+	}
+	if !loaded.UsesMemoryStore() || loaded.Secret != testSecret {
+		t.Errorf(`toml values were not used: %+v`, loaded)
+	}
+}
+
+func TestLoadListsEveryProblem(t *testing.T) {
+	_, err := settings.Load([]string{"-addr", "localhost:99999"}, fakeEnv(map[string]string{
+		"secret":              "short",
+		"DB_URL":              "mysql://somewhere",
+		"BCRYPT_COST":         "100",
+		"PASSWORD_HASHER":     "md5",
+		"PASSWORD_MIN_LENGTH": "0",
+		"READ_TIMEOUT":        "soon",
+		"TLS_CERT_FILE":       "cert.pem",
+		"PLATFORM":            "staging",
+	}))
+
+	settingsErr := &settings.Error{}
+	if !errors.As(err, &settingsErr) {
+		t.Fatalf(`Load should fail with a settings.Error, got %v`, err)
+	}
+
+	for _, want := range []string{"secret", "db_url", "addr", "bcrypt_cost", "password_hasher", "password_min_length", "read_timeout", "tls_key_file", "platform"} {
+		found := false
+		for _, problem := range settingsErr.Problems {
+			if strings.Contains(problem, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf(`problems should mention %s: %q`, want, settingsErr.Problems)
+		}
+	}
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	loaded, err := settings.Load([]string{"-jwt-key-files", "rsa-2024=keys/rsa-2024.pem, ed-2025=keys/ed-2025.pem", "-jwt-retired-keys", "secret"}, fakeEnv(map[string]string{
+		"secret": testSecret,
+		"STORE":  "memory",
+	}))
+	if err != nil {
+		t.Fatalf(`Load failed: %v`, err)
+	}
+	if len(loaded.JWTKeyFiles) != 2 || loaded.JWTKeyFiles[1] != (settings.JWTKeyFile{ID: "ed-2025", Path: "keys/ed-2025.pem"}) {
+		t.Errorf(`key files were not parsed: %+v`, loaded.JWTKeyFiles)
+	}
+	if loaded.ActiveJWTKey() != "rsa-2024" || loaded.JWTIssuer != "chirpy" {
+		t.Errorf(`the first key file should sign by default, got %q`, loaded.ActiveJWTKey())
+	}
+
+	// key files can replace the secret entirely, but then purpose tokens need a secret of their own
+	_, err = settings.Load([]string{"-jwt-key-files", "ed-2025=keys/ed-2025.pem"}, fakeEnv(map[string]string{"STORE": "memory"}))
+	settingsErr := &settings.Error{}
+	if !errors.As(err, &settingsErr) || len(settingsErr.Problems) != 1 || !strings.Contains(settingsErr.Problems[0], "purpose_secret") {
+		t.Errorf(`purpose_secret should be needed without a secret: %v`, err)
+	}
+	_, err = settings.Load([]string{"-jwt-key-files", "ed-2025=keys/ed-2025.pem"}, fakeEnv(map[string]string{
+		"PURPOSE_SECRET": testSecret,
+		"STORE":          "memory",
+	}))
+	if err != nil {
+		t.Errorf(`a secret should not be needed with key files: %v`, err)
+	}
+	_, err = settings.Load(nil, fakeEnv(map[string]string{
+		"secret":         testSecret,
+		"PURPOSE_SECRET": testSecret,
+		"STORE":          "memory",
+	}))
+	if !errors.As(err, &settingsErr) || len(settingsErr.Problems) != 1 {
+		t.Errorf(`purpose_secret should not be allowed to repeat the secret: %v`, err)
+	}
+
+	_, err = settings.Load([]string{"-jwt-key-files", "ed-2025=keys/ed-2025.pem,secret=keys/other.pem", "-jwt-active-key", "ed-2025", "-jwt-retired-keys", "ed-2025,rsa-1999"}, fakeEnv(map[string]string{
+		"secret": testSecret,
+		"STORE":  "memory",
+	}))
+	if !errors.As(err, &settingsErr) || len(settingsErr.Problems) != 3 {
+		t.Errorf(`a taken kid, an unknown retired kid and a retired active key should all be problems: %v`, err)
+	}
+}
+
+func TestLoadMailer(t *testing.T) {
+	loaded, err := settings.Load([]string{"-mailer", "smtp", "-smtp-addr", "mail.example.com:587", "-mail-from", "chirpy@example.com", "-require-verified-email", "true"}, fakeEnv(map[string]string{
+		"secret": testSecret,
+		"STORE":  "memory",
+	}))
+	if err != nil {
+		t.Fatalf(`Load failed: %v`, err)
+	}
+	if !loaded.RequireVerifiedEmail || loaded.EmailVerificationLifetime != 24*time.Hour || loaded.PasswordResetLifetime != time.Hour {
+		t.Errorf(`mailer settings are wrong: %+v`, loaded)
+	}
+
+	_, err = settings.Load([]string{"-mailer", "smtp", "-mail-from", "Chirpy <chirpy@example.com>", "-email-verification-url", "chirpy.example.com/verify", "-password-reset-url", "ftp://chirpy.example.com/reset", "-require-verified-email", "maybe"}, fakeEnv(map[string]string{
+		"secret": testSecret,
+		"STORE":  "memory",
+	}))
+	settingsErr := &settings.Error{}
+	if !errors.As(err, &settingsErr) || len(settingsErr.Problems) != 5 {
+		t.Errorf(`a bad bool, a missing smtp_addr, a dressed up mail_from and two bad urls should all be problems: %v`, err)
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"sync/atomic"
 
+	"github.com/CzarRamos/chirpy/internal/auth"
 	"github.com/CzarRamos/chirpy/internal/config"
 	"github.com/CzarRamos/chirpy/internal/database"
+	"github.com/CzarRamos/chirpy/internal/logging"
+	"github.com/CzarRamos/chirpy/internal/metrics"
+	"github.com/CzarRamos/chirpy/internal/ratelimit"
+	"github.com/CzarRamos/chirpy/internal/settings"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -19,48 +26,199 @@ const ERROR_STATUS_CODE = 400
 func main() {
 
 	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	db, err := sql.Open("postgres", dbURL)
-	if err != nil {
-		fmt.Printf("error unable to open %s: %s", dbURL, err)
+
+	if len(os.Args) > 1 && os.Args[1] == PROMOTE_ADMIN_COMMAND {
+		err := runPromoteAdmin(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	officialSecretToken := os.Getenv("secret")
-	OfficialPolkaKey := os.Getenv("POLKA_KEY")
+	appSettings, err := settings.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.New(os.Stderr, appSettings.LogLevel, appSettings.LogFormat)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// run returns instead of exiting so its deferred cleanup happens first
+	err = run(appSettings)
+	if err != nil {
+		slog.Error("chirpy stopped", "err", err)
+		os.Exit(1)
+	}
+}
 
-	dbQueries := database.New(db)
+// run serves the API until a shutdown signal drains it, or returns why it couldn't start
+func run(appSettings settings.Settings) error {
+	appMetrics := metrics.New()
+
+	var dbQueries database.Store
+	if appSettings.UsesMemoryStore() {
+		// everything is lost once the server stops
+		dbQueries = database.NewMemoryStore()
+	} else {
+		db, err := sql.Open("postgres", appSettings.DBURL)
+		if err != nil {
+			return fmt.Errorf("error unable to open database: %w", err)
+		}
+		// closed once the server has drained
+		defer db.Close()
+		dbQueries = database.New(database.NewTimedDBTX(db, appMetrics.ObserveQuery))
+	}
+
+	keys, err := config.LoadKeyRing(appSettings)
+	if err != nil {
+		return fmt.Errorf("error loading access token keys: %w", err)
+	}
+
+	passwordHasher, err := auth.NewPasswordHasher(appSettings.PasswordHasher, appSettings.BcryptCost)
+	if err != nil {
+		return fmt.Errorf("error picking password hasher: %w", err)
+	}
+	passwordPolicy, err := config.LoadPasswordPolicy(appSettings)
+	if err != nil {
+		return fmt.Errorf("error loading breached password list: %w", err)
+	}
+	if passwordPolicy.Breached != nil {
+		slog.Info("loaded breached password list", "hashes", passwordPolicy.Breached.Len())
+	}
 
 	userConfig := config.ApiConfig{
 		FileserverHits: atomic.Int32{},
 		DbQueries:      dbQueries,
-		SecretToken:    officialSecretToken,
-		PolkaKey:       OfficialPolkaKey,
+		Keys:           keys,
+		PurposeKeys:    config.LoadPurposeKeyRing(appSettings),
+		PolkaKey:       appSettings.PolkaKey,
+		MaxBodyBytes:   int64(appSettings.MaxBodyBytes),
+
+		AccessTokenLifetime:  appSettings.AccessTokenLifetime,
+		RefreshTokenLifetime: appSettings.RefreshTokenLifetime,
+		PasswordHasher:       passwordHasher,
+		PasswordPolicy:       passwordPolicy,
+
+		Metrics:  appMetrics,
+		Platform: appSettings.Platform,
+
+		Mailer:                    config.LoadMailer(appSettings),
+		EmailVerificationURL:      appSettings.EmailVerificationURL,
+		EmailVerificationLifetime: appSettings.EmailVerificationLifetime,
+		RequireVerifiedEmail:      appSettings.RequireVerifiedEmail,
+		PasswordResetURL:          appSettings.PasswordResetURL,
+		PasswordResetLifetime:     appSettings.PasswordResetLifetime,
+	}
+	appMetrics.Registry.NewGaugeFunc("chirpy_app_visits", "Visits to the home page since the last reset.", func() float64 {
+		return float64(userConfig.FileserverHits.Load())
+	})
+
+	wordFilter, moderator, err := config.LoadModeration(context.Background(), dbQueries, appSettings.ModerationWordsFile, appSettings.ModerationPatternsFile)
+	if err != nil {
+		return fmt.Errorf("error loading moderation rules: %w", err)
+	}
+	userConfig.WordFilter = wordFilter
+	userConfig.Moderator = moderator
+
+	rateLimiter := ratelimit.NewMemoryStore()
+	if appSettings.RateLimit {
+		userConfig.RateLimiter = rateLimiter
 	}
 
 	serverMux := http.NewServeMux()
 
 	homepageHandler := http.StripPrefix("/app/", http.FileServer(http.Dir(".")))
 
-	serverMux.Handle("/app/", userConfig.MiddlewareMetricsInc(homepageHandler))          // shows the home page
-	serverMux.HandleFunc("GET /admin/metrics", userConfig.HandlerMetrics)                // shows number of visitors to home page
-	serverMux.HandleFunc("POST /admin/reset", userConfig.HandlerResetMetrics)            // reset all metrics to zero
-	serverMux.HandleFunc("GET /api/healthz", userConfig.HandlerHealthz)                  // helps check if website is running
-	serverMux.HandleFunc("POST /api/users", userConfig.CreateNewUserHandler)             // registers a new user
-	serverMux.HandleFunc("PUT /api/users", userConfig.UpdateCredentialsHandler)          // lets user update their email and password
-	serverMux.HandleFunc("GET /api/chirps", userConfig.GetAllChirpsHandler)              // shows all chirps
-	serverMux.HandleFunc("GET /api/chirps/{chirp_id}", userConfig.GetChirpViaIdHandler)  // lets user find chirps
-	serverMux.HandleFunc("DELETE /api/chirps/{chirp_id}", userConfig.DeleteChirpHandler) // lets user delete chirps
-	serverMux.HandleFunc("POST /api/chirps", userConfig.NewChirpHandler)                 // lets user creates new chirps
-	serverMux.HandleFunc("POST /api/login", userConfig.LoginHandler)                     // lets the user log in
-	serverMux.HandleFunc("POST /api/refresh", userConfig.RefreshHandler)                 // gives user access token with valid refresh token
-	serverMux.HandleFunc("POST /api/revoke", userConfig.RevokeRefreshTokenHandler)       // remove access to refresh token
-	serverMux.HandleFunc("POST /api/polka/webhooks", userConfig.UpgradeUserHandler)      // upgrades user to chirpy red
-
-	server := http.Server{
-		Addr:    ":8080",
-		Handler: serverMux,
-	}
+	serverMux.Handle("/app/", userConfig.MiddlewareMetricsInc(homepageHandler))                                                                                  // shows the home page
+	serverMux.Handle("POST /admin/reset", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.HandlerResetMetrics))                                     // reset all metrics to zero, dev platform only
+	serverMux.HandleFunc("GET /.well-known/jwks.json", userConfig.HandlerJWKS)                                                                                   // public keys for checking access tokens
+	serverMux.HandleFunc("GET /api/healthz", userConfig.HandlerHealthz)                                                                                          // helps check if website is running
+	serverMux.Handle("POST /api/users", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.CreateNewUserHandler)))               // registers a new user
+	serverMux.Handle("PUT /api/users", userConfig.MiddlewareRequireAuth(userConfig.UpdateCredentialsHandler))                                                    // lets user update their email and password
+	serverMux.HandleFunc("GET /api/chirps", userConfig.GetAllChirpsHandler)                                                                                      // shows all chirps
+	serverMux.HandleFunc("GET /api/chirps/{chirp_id}", userConfig.GetChirpViaIdHandler)                                                                          // lets user find chirps
+	serverMux.Handle("DELETE /api/chirps/{chirp_id}", userConfig.MiddlewareRequireAuth(userConfig.DeleteChirpHandler))                                           // lets user delete chirps
+	serverMux.Handle("POST /api/chirps", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_CHIRPS, userConfig.MiddlewareRequireAuth(userConfig.NewChirpHandler))) // lets user creates new chirps
+	serverMux.Handle("POST /api/login", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.LoginHandler)))                       // lets the user log in
+	serverMux.HandleFunc("POST /api/refresh", userConfig.RefreshHandler)                                                                                         // gives user access token with valid refresh token
+	serverMux.HandleFunc("POST /api/revoke", userConfig.RevokeRefreshTokenHandler)                                                                               // remove access to refresh token
+	serverMux.Handle("GET /api/sessions", userConfig.MiddlewareRequireAuth(userConfig.ListSessionsHandler))                                                      // lists the devices a user is signed in on
+	serverMux.Handle("DELETE /api/sessions/{session_id}", userConfig.MiddlewareRequireAuth(userConfig.RevokeSessionHandler))                                     // signs one device out
+	serverMux.Handle("POST /api/logout", userConfig.MiddlewareRequireAuth(userConfig.LogoutHandler))                                                             // kills the access token it's called with
+	serverMux.Handle("POST /api/logout-all", userConfig.MiddlewareRequireAuth(userConfig.LogoutAllHandler))                                                      // signs every device out
+	serverMux.HandleFunc("POST /api/polka/webhooks", userConfig.UpgradeUserHandler)                                                                              // upgrades user to chirpy red
+
+	serverMux.HandleFunc("POST /api/users/verify", userConfig.VerifyEmailHandler)                                                                             // confirms an email address with the emailed token
+	serverMux.Handle("POST /api/users/verify/resend", userConfig.MiddlewareRequireAuth(userConfig.ResendVerificationHandler))                                 // mails another verification token
+	serverMux.Handle("POST /api/password/forgot", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.ForgotPasswordHandler))) // mails a password reset link
+	serverMux.Handle("POST /api/password/reset", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.ResetPasswordHandler)))   // sets a new password with the emailed token
+
+	serverMux.Handle("POST /api/login/mfa", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, http.HandlerFunc(userConfig.LoginMFAHandler)))                                                    // finishes a login with an authenticator or recovery code
+	serverMux.Handle("POST /api/mfa/totp/enroll", userConfig.MiddlewareRequireAuth(userConfig.EnrollTOTPHandler))                                                                                    // makes a secret for an authenticator app
+	serverMux.Handle("POST /api/mfa/totp/confirm", userConfig.MiddlewareRequireAuth(userConfig.ConfirmTOTPHandler))                                                                                  // turns MFA on with a first code
+	serverMux.Handle("POST /api/mfa/totp/disable", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, userConfig.MiddlewareRequireAuth(userConfig.DisableTOTPHandler)))                          // turns MFA off
+	serverMux.Handle("POST /api/mfa/recovery-codes/regenerate", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_AUTH, userConfig.MiddlewareRequireAuth(userConfig.RegenerateRecoveryCodesHandler))) // replaces the recovery codes
+
+	serverMux.Handle("POST /api/users/{user_id}/follow", userConfig.MiddlewareRequireAuth(userConfig.FollowUserHandler))     // follows another user
+	serverMux.Handle("DELETE /api/users/{user_id}/follow", userConfig.MiddlewareRequireAuth(userConfig.UnfollowUserHandler)) // unfollows another user
+	serverMux.HandleFunc("GET /api/users/{user_id}/followers", userConfig.GetFollowersHandler)                               // lists who follows a user
+	serverMux.HandleFunc("GET /api/users/{user_id}/following", userConfig.GetFollowingHandler)                               // lists who a user follows
+	serverMux.Handle("GET /api/timeline", userConfig.MiddlewareRequireAuth(userConfig.TimelineHandler))                      // shows chirps from followed users
 
-	server.ListenAndServe()
+	serverMux.HandleFunc("GET /api/chirps/{chirp_id}/thread", userConfig.GetChirpThreadHandler) // shows the conversation around a chirp
+
+	serverMux.Handle("POST /api/chirps/{chirp_id}/like", userConfig.MiddlewareRequireAuth(userConfig.LikeChirpHandler))        // likes a chirp
+	serverMux.Handle("DELETE /api/chirps/{chirp_id}/like", userConfig.MiddlewareRequireAuth(userConfig.UnlikeChirpHandler))    // takes back a like
+	serverMux.Handle("POST /api/chirps/{chirp_id}/rechirp", userConfig.MiddlewareRequireAuth(userConfig.RechirpHandler))       // rechirps a chirp
+	serverMux.Handle("DELETE /api/chirps/{chirp_id}/rechirp", userConfig.MiddlewareRequireAuth(userConfig.UndoRechirpHandler)) // takes back a rechirp
+
+	serverMux.Handle("GET /api/chirps/search", userConfig.MiddlewareRateLimit(config.RATE_LIMIT_SEARCH, http.HandlerFunc(userConfig.SearchChirpsHandler))) // searches chirp bodies
+
+	serverMux.Handle("GET /admin/metrics", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.HandlerMetrics))                                          // shows number of visitors to home page
+	serverMux.Handle("GET /admin/users", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.ListUsersHandler))                                          // lists and searches accounts
+	serverMux.Handle("GET /admin/users/{user_id}", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.GetUserDetailHandler))                            // shows one account in detail
+	serverMux.Handle("DELETE /admin/users/{user_id}", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.DeleteUserHandler))                            // deletes an account and everything it owns
+	serverMux.Handle("PUT /admin/users/{user_id}/role", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.SetUserRoleHandler))                         // promotes or demotes a user
+	serverMux.Handle("POST /admin/users/{user_id}/suspend", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.SuspendUserHandler))                     // blocks an account and logs it out
+	serverMux.Handle("POST /admin/users/{user_id}/unsuspend", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.UnsuspendUserHandler))                 // lets a suspended account back in
+	serverMux.Handle("POST /admin/users/{user_id}/force-password-reset", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.ForcePasswordResetHandler)) // logs an account out until it picks a new password
+
+	serverMux.Handle("GET /admin/lockouts", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.ListLockoutsHandler))             // lists accounts locked out by failed logins
+	serverMux.Handle("DELETE /admin/lockouts/{email}", userConfig.MiddlewareRequireRole(auth.ROLE_ADMIN, userConfig.UnlockAccountHandler)) // lets a locked out account try again
+
+	serverMux.Handle("GET /admin/moderation/words", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.ListBannedWordsHandler))                          // lists banned words
+	serverMux.Handle("PUT /admin/moderation/words/{word}", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.SetBannedWordHandler))                     // bans a word or changes its action
+	serverMux.Handle("DELETE /admin/moderation/words/{word}", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.DeleteBannedWordHandler))               // unbans a word
+	serverMux.Handle("GET /admin/moderation/flags", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.ListModerationFlagsHandler))                      // lists chirps waiting for review
+	serverMux.Handle("POST /admin/moderation/flags/{flag_id}/resolve", userConfig.MiddlewareRequireRole(auth.ROLE_MODERATOR, userConfig.ResolveModerationFlagHandler)) // marks a flagged chirp as reviewed
+
+	// request ids go outermost so everything else can log them,
+	// then metrics and access logs so they see every request, even ones that fail early
+	handler := userConfig.MiddlewareLimitBody(serverMux)
+	handler = userConfig.MiddlewareRequestMetrics(handler)
+	handler = userConfig.MiddlewareAccessLog(handler)
+	handler = userConfig.MiddlewareRequestID(handler)
+
+	// stops once the server has drained
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	go userConfig.RunDenylistCleanup(cleanupCtx, config.DENYLIST_CLEANUP_INTERVAL)
+	go userConfig.RunLoginAttemptCleanup(cleanupCtx, config.LOGIN_ATTEMPT_CLEANUP_INTERVAL)
+	go rateLimiter.RunPrune(cleanupCtx, config.RATE_LIMIT_PRUNE_INTERVAL)
+
+	server := newServer(appSettings, handler)
+
+	err = runServer(server, appSettings)
+	// emails still going out need the database until they're done
+	userConfig.WaitForBackground()
+	if err != nil {
+		return fmt.Errorf("error running server: %w", err)
+	}
+	return nil
 }
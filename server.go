@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/CzarRamos/chirpy/internal/settings"
+)
+
+func newServer(settings settings.Settings, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              settings.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: settings.ReadHeaderTimeout,
+		ReadTimeout:       settings.ReadTimeout,
+		WriteTimeout:      settings.WriteTimeout,
+		IdleTimeout:       settings.IdleTimeout,
+		MaxHeaderBytes:    settings.MaxHeaderBytes,
+	}
+}
+
+// runServer serves until SIGINT or SIGTERM, then stops taking new connections and
+// gives in-flight requests until the shutdown timeout to finish
+func runServer(server *http.Server, settings settings.Settings) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if settings.UsesTLS() {
+			slog.Info("serving https", "addr", server.Addr)
+			serveErr <- server.ListenAndServeTLS(settings.TLSCertFile, settings.TLSKeyFile)
+			return
+		}
+		slog.Info("serving http", "addr", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-serveErr:
+		// the server never started, usually a taken port or a bad certificate
+		return err
+	case sig := <-stop:
+		slog.Info("shutting down", "signal", sig.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("error draining requests: %w", err)
+	}
+
+	err = <-serveErr
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -1,24 +1,25 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES(
     $1,
     NOW(),
     $2,
     $3, 
-    $4
+    $4,
+    $5
 )
 RETURNING *;
 
 -- name: GetAllChirpsSinceCreation :many
 SELECT * 
 FROM chirps
-WHERE id IS NOT NULL
+WHERE id IS NOT NULL AND deleted_at IS NULL
 ORDER BY created_at ASC;
 
 -- name: GetAllChirpsOfUserID :many
 SELECT *
 FROM chirps
-WHERE user_id = $1
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY created_at ASC;
 
 -- name: GetChirpViaID :one
@@ -28,4 +29,65 @@ WHERE id = $1;
 
 -- name: DeleteChirpPerm :exec
 DELETE from chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetChirpsAfterCursor :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpsBeforeCursor :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: CountChirpReplies :one
+SELECT COUNT(*)
+FROM chirps
+WHERE in_reply_to = $1;
+
+-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = $1, updated_at = $2
+WHERE id = $3;
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.*
+    FROM chirps AS parent
+    WHERE parent.id = (SELECT child.in_reply_to FROM chirps AS child WHERE child.id = $1)
+    UNION ALL
+    SELECT chirps.*
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT *
+FROM ancestors
+ORDER BY created_at ASC, id ASC;
+
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT chirps.*
+    FROM chirps
+    WHERE chirps.in_reply_to = $1
+    UNION ALL
+    SELECT chirps.*
+    FROM chirps
+    JOIN descendants ON chirps.in_reply_to = descendants.id
+)
+SELECT *
+FROM descendants
+ORDER BY created_at ASC, id ASC;
+
+-- name: CountChirpsOfUser :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL;
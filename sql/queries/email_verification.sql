@@ -0,0 +1,27 @@
+-- name: CreateEmailVerificationToken :one
+INSERT INTO email_verification_tokens (id, user_id, email, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+)
+RETURNING *;
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: ListEmailVerificationTokensSince :many
+SELECT *
+FROM email_verification_tokens
+WHERE user_id = $1 AND created_at > $2
+ORDER BY created_at DESC;
+
+-- name: SetUserEmailVerified :execrows
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2;
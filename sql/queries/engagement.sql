@@ -0,0 +1,40 @@
+-- name: CreateChirpLike :exec
+INSERT INTO chirp_likes (user_id, chirp_id, created_at)
+VALUES(
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteChirpLike :exec
+DELETE FROM chirp_likes
+WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: CreateRechirp :exec
+INSERT INTO rechirps (user_id, chirp_id, created_at)
+VALUES(
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteRechirp :exec
+DELETE FROM rechirps
+WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: GetChirpEngagement :many
+SELECT chirps.id AS chirp_id,
+    (SELECT COUNT(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id) AS like_count,
+    (SELECT COUNT(*) FROM rechirps WHERE rechirps.chirp_id = chirps.id) AS rechirp_count,
+    EXISTS(
+        SELECT 1 FROM chirp_likes
+        WHERE chirp_likes.chirp_id = chirps.id AND chirp_likes.user_id = sqlc.narg('viewer_id')::uuid
+    ) AS liked_by_me,
+    EXISTS(
+        SELECT 1 FROM rechirps
+        WHERE rechirps.chirp_id = chirps.id AND rechirps.user_id = sqlc.narg('viewer_id')::uuid
+    ) AS rechirped_by_me
+FROM chirps
+WHERE chirps.id = ANY(sqlc.arg('chirp_ids')::uuid[]);
\ No newline at end of file
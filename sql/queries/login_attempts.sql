@@ -0,0 +1,54 @@
+-- name: ReserveLoginAttempt :one
+INSERT INTO login_attempts (scope, subject, failures, last_failed_at)
+VALUES(
+    sqlc.arg('scope'),
+    sqlc.arg('subject'),
+    1,
+    NOW()
+)
+ON CONFLICT (scope, subject) DO UPDATE
+SET failures = CASE
+        WHEN login_attempts.last_failed_at < sqlc.arg('window_start')::timestamp THEN 1
+        ELSE login_attempts.failures + 1
+    END,
+    last_failed_at = NOW(),
+    blocked_until = CASE
+        WHEN login_attempts.last_failed_at >= sqlc.arg('window_start')::timestamp
+            AND login_attempts.failures + 1 >= sqlc.arg('lockout_failures')::integer THEN sqlc.arg('lockout_until')::timestamp
+        ELSE NULL
+    END
+WHERE login_attempts.blocked_until IS NULL OR login_attempts.blocked_until <= NOW()
+RETURNING *;
+
+-- name: RefundLoginAttempt :exec
+UPDATE login_attempts
+SET failures = GREATEST(failures - 1, 0),
+    blocked_until = CASE
+        WHEN blocked_until = sqlc.narg('reserved_blocked_until')::timestamp THEN NULL
+        ELSE blocked_until
+    END
+WHERE scope = sqlc.arg('scope') AND subject = sqlc.arg('subject');
+
+-- name: SetLoginBlockedUntil :exec
+UPDATE login_attempts
+SET blocked_until = GREATEST(blocked_until, $3)
+WHERE scope = $1 AND subject = $2;
+
+-- name: GetLoginAttempt :one
+SELECT *
+FROM login_attempts
+WHERE scope = $1 AND subject = $2;
+
+-- name: ClearLoginAttempts :execrows
+DELETE FROM login_attempts
+WHERE scope = $1 AND subject = $2;
+
+-- name: ListBlockedLoginAttempts :many
+SELECT *
+FROM login_attempts
+WHERE scope = $1 AND blocked_until > NOW()
+ORDER BY blocked_until DESC, subject;
+
+-- name: DeleteStaleLoginAttempts :execrows
+DELETE FROM login_attempts
+WHERE last_failed_at < $1 AND (blocked_until IS NULL OR blocked_until <= NOW());
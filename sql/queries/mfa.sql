@@ -0,0 +1,55 @@
+-- name: StartMFAEnrollment :one
+INSERT INTO user_mfa (user_id, totp_secret, created_at, enabled_at, last_step)
+VALUES(
+    $1,
+    $2,
+    NOW(),
+    NULL,
+    0
+)
+ON CONFLICT (user_id) DO UPDATE
+SET totp_secret = EXCLUDED.totp_secret, created_at = NOW(), last_step = 0
+WHERE user_mfa.enabled_at IS NULL
+RETURNING *;
+
+-- name: GetUserMFA :one
+SELECT *
+FROM user_mfa
+WHERE user_id = $1;
+
+-- name: EnableMFA :execrows
+UPDATE user_mfa
+SET enabled_at = NOW(), last_step = $2
+WHERE user_id = $1 AND enabled_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_mfa
+SET last_step = $2
+WHERE user_id = $1 AND last_step < $2;
+
+-- name: DeleteUserMFA :exec
+DELETE FROM user_mfa
+WHERE user_id = $1;
+
+-- name: CreateMFARecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    NOW()
+);
+
+-- name: UseMFARecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CountUnusedMFARecoveryCodes :one
+SELECT COUNT(*)
+FROM mfa_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: DeleteMFARecoveryCodesOfUser :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
@@ -0,0 +1,38 @@
+-- name: ListBannedWords :many
+SELECT *
+FROM banned_words
+ORDER BY word ASC;
+
+-- name: UpsertBannedWord :exec
+INSERT INTO banned_words (word, action, created_at)
+VALUES(
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT (word) DO UPDATE
+SET action = EXCLUDED.action;
+
+-- name: DeleteBannedWord :exec
+DELETE FROM banned_words
+WHERE word = $1;
+
+-- name: CreateModerationFlag :exec
+INSERT INTO moderation_flags (id, chirp_id, reason, created_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    NOW()
+);
+
+-- name: ListOpenModerationFlags :many
+SELECT *
+FROM moderation_flags
+WHERE resolved_at IS NULL
+ORDER BY created_at ASC;
+
+-- name: ResolveModerationFlag :exec
+UPDATE moderation_flags
+SET resolved_at = $1
+WHERE id = $2;
\ No newline at end of file
@@ -0,0 +1,37 @@
+-- name: CreatePasswordResetToken :one
+INSERT INTO password_reset_tokens (id, token_hash, user_id, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+)
+RETURNING *;
+
+-- name: GetUsablePasswordResetToken :one
+SELECT *
+FROM password_reset_tokens
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW();
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: ExpirePasswordResetTokensOfUser :exec
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: ListPasswordResetTokensSince :many
+SELECT *
+FROM password_reset_tokens
+WHERE user_id = $1 AND created_at > $2
+ORDER BY created_at DESC;
+
+-- name: ResetUserPassword :exec
+UPDATE users
+SET hashed_password = $1, password_reset_required = FALSE, token_version = token_version + 1, updated_at = NOW()
+WHERE id = $2;
@@ -1,21 +1,47 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, expires_at, revoked_at, user_id)
+INSERT INTO refresh_tokens (id, token_hash, family_id, created_at, updated_at, expires_at, revoked_at, user_id, user_agent, ip, last_used_at)
 VALUES(
     $1,
-    NOW(),
     $2,
-    $3, 
+    $3,
+    NOW(),
     $4,
-    $5
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    NOW()
 )
 RETURNING *;
 
--- name: GetUserViaRefreshToken :one
+-- name: GetRefreshTokenViaHash :one
 SELECT *
 FROM refresh_tokens
-WHERE token = $1;
+WHERE token_hash = $1;
 
 -- name: SetRefreshTokenRevoked :exec
 UPDATE refresh_tokens
 SET revoked_at = $1, updated_at = $2
-WHERE token = $3;
\ No newline at end of file
+WHERE id = $3;
+
+-- name: RevokeActiveRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: ListActiveRefreshTokensOfUser :many
+SELECT *
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC;
+
+-- name: RevokeAllRefreshTokensOfUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
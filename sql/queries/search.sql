@@ -0,0 +1,18 @@
+-- name: SearchChirps :many
+SELECT chirps.*,
+    ts_rank(to_tsvector('english', chirps.body), websearch_to_tsquery('english', sqlc.arg('query'))) AS rank,
+    ts_headline(
+        'english',
+        replace(replace(replace(chirps.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+        websearch_to_tsquery('english', sqlc.arg('query')),
+        'StartSel=<mark>, StopSel=</mark>, HighlightAll=TRUE'
+    ) AS snippet
+FROM chirps
+WHERE chirps.deleted_at IS NULL
+AND to_tsvector('english', chirps.body) @@ websearch_to_tsquery('english', sqlc.arg('query'))
+AND (sqlc.narg('author_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR chirps.created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR chirps.created_at < sqlc.narg('until')::timestamp)
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit')
+OFFSET sqlc.arg('page_offset');
\ No newline at end of file
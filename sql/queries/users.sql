@@ -19,10 +19,60 @@ WHERE email = $1;
 
 -- name: UpdateUserCredentials :exec
 UPDATE users
-SET email = $1, hashed_password = $2
+SET email = $1, hashed_password = $2, password_reset_required = FALSE, token_version = token_version + 1,
+    email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
 WHERE id = $3;
 
 -- name: UpgradeToChirpyRedViaID :exec
 UPDATE users
 SET is_chirpy_red = TRUE
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetUserViaID :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: SetUserRole :one
+UPDATE users
+SET role = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*)
+FROM users
+WHERE role = $1;
+
+-- name: ListUsers :many
+SELECT *
+FROM users
+WHERE (sqlc.narg('email_search')::text IS NULL OR strpos(lower(email), lower(sqlc.narg('email_search')::text)) > 0)
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: SetUserSuspended :exec
+UPDATE users
+SET suspended_at = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: SetPasswordResetRequired :exec
+UPDATE users
+SET password_reset_required = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1
+RETURNING token_version;
+
+-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = sqlc.arg(new_hashed_password)
+WHERE id = sqlc.arg(id) AND hashed_password = sqlc.arg(old_hashed_password);
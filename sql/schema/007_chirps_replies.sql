@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN in_reply_to UUID NULL,
+ADD COLUMN deleted_at TIMESTAMP NULL,
+ADD CONSTRAINT fk_in_reply_to
+FOREIGN KEY (in_reply_to)
+REFERENCES chirps(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_chirps_in_reply_to ON chirps (in_reply_to);
+
+-- +goose Down
+DROP INDEX idx_chirps_in_reply_to;
+
+ALTER TABLE chirps
+DROP CONSTRAINT fk_in_reply_to,
+DROP COLUMN deleted_at,
+DROP COLUMN in_reply_to;
\ No newline at end of file
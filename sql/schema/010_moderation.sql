@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE banned_words (
+    word TEXT PRIMARY KEY,
+    action TEXT NOT NULL DEFAULT 'mask',
+    created_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO banned_words (word, action, created_at)
+VALUES
+    ('kerfuffle', 'mask', NOW()),
+    ('sharbert', 'mask', NOW()),
+    ('fornax', 'mask', NOW());
+
+CREATE TABLE moderation_flags (
+    id UUID PRIMARY KEY,
+    chirp_id UUID NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP NULL,
+    CONSTRAINT fk_chirp_id
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE moderation_flags;
+DROP TABLE banned_words;
\ No newline at end of file
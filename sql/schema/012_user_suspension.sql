@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN suspended_at TIMESTAMP NULL,
+ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX idx_users_created_at ON users (created_at);
+
+-- +goose Down
+DROP INDEX idx_users_created_at;
+
+ALTER TABLE users
+DROP COLUMN password_reset_required,
+DROP COLUMN suspended_at;
@@ -0,0 +1,39 @@
+-- +goose Up
+-- tokens are kept as sha256 hashes so a database leak doesn't hand out sessions.
+-- every token starts in a family of its own, rotated tokens join the family of the token they replaced
+ALTER TABLE refresh_tokens
+ADD COLUMN id UUID NULL,
+ADD COLUMN family_id UUID NULL,
+ADD COLUMN token_hash TEXT NULL;
+
+UPDATE refresh_tokens
+SET id = gen_random_uuid(),
+    family_id = gen_random_uuid(),
+    token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+
+ALTER TABLE refresh_tokens
+DROP CONSTRAINT refresh_tokens_pkey,
+DROP COLUMN token,
+ALTER COLUMN id SET NOT NULL,
+ALTER COLUMN family_id SET NOT NULL,
+ALTER COLUMN token_hash SET NOT NULL,
+ADD PRIMARY KEY (id),
+ADD CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+-- +goose Down
+-- hashes can't be turned back into tokens, so everyone has to log in again
+DELETE FROM refresh_tokens;
+
+DROP INDEX idx_refresh_tokens_user_id;
+DROP INDEX idx_refresh_tokens_family_id;
+
+ALTER TABLE refresh_tokens
+DROP CONSTRAINT refresh_tokens_token_hash_key,
+DROP CONSTRAINT refresh_tokens_pkey,
+DROP COLUMN token_hash,
+DROP COLUMN family_id,
+DROP COLUMN id,
+ADD COLUMN token TEXT PRIMARY KEY;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- like refresh tokens, reset tokens are kept as sha256 hashes so a database leak can't reset passwords
+CREATE TABLE password_reset_tokens (
+    id UUID PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    user_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens (user_id, created_at);
+
+-- +goose Down
+DROP TABLE password_reset_tokens;
@@ -0,0 +1,30 @@
+-- +goose Up
+-- a row is made when a user starts enrolling and enabled_at is set once they confirm a first code.
+-- last_step is the newest TOTP time step used, so a code can't be replayed
+CREATE TABLE user_mfa (
+    user_id UUID PRIMARY KEY,
+    totp_secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    enabled_at TIMESTAMP NULL,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- recovery codes are kept as sha256 hashes, like refresh tokens
+CREATE TABLE mfa_recovery_codes (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE (user_id, code_hash)
+);
+
+-- +goose Down
+DROP TABLE mfa_recovery_codes;
+DROP TABLE user_mfa;
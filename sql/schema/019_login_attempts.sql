@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE login_attempts (
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP NULL,
+    PRIMARY KEY (scope, subject)
+);
+
+CREATE INDEX idx_login_attempts_last_failed_at ON login_attempts (last_failed_at);
+
+-- +goose Down
+DROP TABLE login_attempts;